@@ -0,0 +1,354 @@
+package basebackup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+
+	"github.com/vbp1/pgclone/internal/postgres"
+	"github.com/vbp1/pgclone/internal/progress"
+)
+
+// Options configures Run.
+type Options struct {
+	Label          string // backup label; default "pgclone"
+	FastCheckpoint bool   // request an immediate checkpoint
+
+	DataDir string // destination for the main archive (base.tar)
+	// TablespaceDir maps a tablespace to its local directory; nil keeps the primary location.
+	TablespaceDir func(oid uint32, location string) string
+
+	ShowBar          bool
+	ProgressMode     string
+	ProgressInterval int
+}
+
+// Result describes a finished base backup.
+type Result struct {
+	StartLSN postgres.LSN
+	StartTLI uint32
+	StopLSN  postgres.LSN
+	StopTLI  uint32
+
+	Tablespaces []postgres.Tablespace
+
+	Files int64 // regular files written
+	Bytes int64 // bytes of file data written
+}
+
+// Summary returns a short multi-line report similar to rsync.Stats.Summary.
+func (r Result) Summary(elapsed time.Duration) string {
+	if elapsed <= 0 {
+		elapsed = time.Second
+	}
+	rate := int64(float64(r.Bytes) / elapsed.Seconds())
+	return fmt.Sprintf("\nNumber of files: %d\nNumber of tablespaces: %d\nTotal transferred file size: %s\n\nreceived %s (%s/sec)",
+		r.Files,
+		len(r.Tablespaces),
+		progress.FormatBytes(r.Bytes),
+		progress.FormatBytes(r.Bytes),
+		progress.FormatBytes(rate),
+	)
+}
+
+// Command returns the BASE_BACKUP command (PostgreSQL 15+ option syntax).
+// WAL is not requested: pgclone streams it separately, so the server must not wait for archiving either.
+func (o Options) Command() string {
+	label := o.Label
+	if label == "" {
+		label = "pgclone"
+	}
+	opts := []string{"LABEL " + postgres.QuoteLiteral(label), "PROGRESS"}
+	if o.FastCheckpoint {
+		opts = append(opts, "CHECKPOINT 'fast'")
+	}
+	opts = append(opts, "WAIT false", "TABLESPACE_MAP")
+	return "BASE_BACKUP ( " + strings.Join(opts, ", ") + " )"
+}
+
+// Run issues BASE_BACKUP on a replication connection and unpacks every archive
+// directly into opts.DataDir and the tablespace directories.
+func Run(ctx context.Context, conn *pgconn.PgConn, opts Options) (Result, error) {
+	var res Result
+
+	conn.Frontend().Send(&pgproto3.Query{String: opts.Command()})
+	if err := conn.Frontend().Flush(); err != nil {
+		return res, fmt.Errorf("send BASE_BACKUP: %w", err)
+	}
+
+	// 1st result set: start position
+	rows, err := readResultSet(ctx, conn)
+	if err != nil {
+		return res, fmt.Errorf("BASE_BACKUP start: %w", err)
+	}
+	if res.StartLSN, res.StartTLI, err = parsePosition(rows); err != nil {
+		return res, err
+	}
+	slog.Info("base backup started", "start_lsn", res.StartLSN, "tli", res.StartTLI)
+
+	// 2nd result set: tablespaces (spcoid, spclocation, size in kB)
+	rows, err = readResultSet(ctx, conn)
+	if err != nil {
+		return res, fmt.Errorf("BASE_BACKUP tablespaces: %w", err)
+	}
+	var totalBytes int64
+	for _, r := range rows {
+		if len(r) < 3 {
+			return res, fmt.Errorf("BASE_BACKUP tablespaces: unexpected row %q", r)
+		}
+		if kb, err := strconv.ParseInt(string(r[2]), 10, 64); err == nil {
+			totalBytes += kb * 1024
+		}
+		if r[0] == nil {
+			continue // main data directory
+		}
+		oid, err := strconv.ParseUint(string(r[0]), 10, 32)
+		if err != nil {
+			return res, fmt.Errorf("BASE_BACKUP tablespaces: bad oid %q", r[0])
+		}
+		res.Tablespaces = append(res.Tablespaces, postgres.Tablespace{Oid: uint32(oid), Location: string(r[1])})
+	}
+
+	tracker := progress.New("basebackup", totalBytes, opts.ShowBar, opts.ProgressMode, opts.ProgressInterval)
+	defer tracker.Abort()
+
+	if err := receiveArchives(ctx, conn, opts, &res, tracker); err != nil {
+		return res, err
+	}
+	tracker.Finish()
+
+	// last result set: stop position
+	rows, err = readResultSet(ctx, conn)
+	if err != nil {
+		return res, fmt.Errorf("BASE_BACKUP stop: %w", err)
+	}
+	if res.StopLSN, res.StopTLI, err = parsePosition(rows); err != nil {
+		return res, err
+	}
+	if err := waitReady(ctx, conn); err != nil {
+		return res, err
+	}
+	slog.Info("base backup finished", "stop_lsn", res.StopLSN, "tli", res.StopTLI, "files", res.Files)
+	return res, nil
+}
+
+// receiveArchives consumes the COPY stream: 'n' starts a new archive, 'd' carries its data,
+// 'p' reports progress and 'm' starts the (unused) manifest.
+func receiveArchives(ctx context.Context, conn *pgconn.PgConn, opts Options, res *Result, tracker *progress.Tracker) error {
+	var cur *archive
+	inManifest := false
+	finish := func() error {
+		if cur == nil {
+			return nil
+		}
+		err := cur.finish()
+		res.Files += cur.files
+		res.Bytes += cur.bytes
+		cur = nil
+		return err
+	}
+	defer func() {
+		if cur != nil {
+			cur.abort()
+		}
+	}()
+
+	for {
+		msg, err := conn.ReceiveMessage(ctx)
+		if err != nil {
+			return fmt.Errorf("BASE_BACKUP stream: %w", err)
+		}
+		switch m := msg.(type) {
+		case *pgproto3.CopyOutResponse:
+			// stream begins
+		case *pgproto3.CopyData:
+			if len(m.Data) == 0 {
+				continue
+			}
+			switch m.Data[0] {
+			case 'n':
+				if err := finish(); err != nil {
+					return err
+				}
+				inManifest = false
+				name, spcPath, err := parseArchiveHeader(m.Data[1:])
+				if err != nil {
+					return err
+				}
+				dst, err := archiveTarget(opts, res.Tablespaces, name, spcPath)
+				if err != nil {
+					return err
+				}
+				slog.Info("receiving archive", "name", name, "dst", dst)
+				cur = startArchive(dst)
+			case 'd':
+				if inManifest {
+					continue
+				}
+				if cur == nil {
+					return fmt.Errorf("BASE_BACKUP stream: data before archive header")
+				}
+				if err := cur.write(m.Data[1:]); err != nil {
+					return err
+				}
+				tracker.Add(int64(len(m.Data) - 1))
+			case 'p':
+				// server-side progress; we count received bytes ourselves
+			case 'm':
+				if err := finish(); err != nil {
+					return err
+				}
+				inManifest = true
+			default:
+				return fmt.Errorf("BASE_BACKUP stream: unexpected message type %q", m.Data[0])
+			}
+		case *pgproto3.CopyDone:
+			return finish()
+		case *pgproto3.ErrorResponse:
+			return fmt.Errorf("BASE_BACKUP: %w", pgconn.ErrorResponseToPgError(m))
+		case *pgproto3.NoticeResponse:
+		default:
+			return fmt.Errorf("BASE_BACKUP stream: unexpected message %T", msg)
+		}
+	}
+}
+
+// archiveTarget resolves the local directory for an archive announced by the server.
+func archiveTarget(opts Options, spcs []postgres.Tablespace, name, spcPath string) (string, error) {
+	if spcPath == "" {
+		return opts.DataDir, nil
+	}
+	oid64, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(name), ".tar"), 10, 32)
+	if err != nil {
+		return "", fmt.Errorf("BASE_BACKUP: unexpected archive name %q", name)
+	}
+	oid := uint32(oid64)
+	for _, t := range spcs {
+		if t.Oid == oid && opts.TablespaceDir != nil {
+			return opts.TablespaceDir(oid, t.Location), nil
+		}
+	}
+	return spcPath, nil
+}
+
+// parseArchiveHeader splits the payload of an 'n' message: archive name and tablespace path, both NUL-terminated.
+func parseArchiveHeader(b []byte) (name, spcPath string, err error) {
+	parts := bytes.SplitN(b, []byte{0}, 3)
+	if len(parts) < 3 {
+		return "", "", fmt.Errorf("BASE_BACKUP stream: malformed archive header")
+	}
+	return string(parts[0]), string(parts[1]), nil
+}
+
+// parsePosition reads a single (recptr, tli) row.
+func parsePosition(rows [][][]byte) (postgres.LSN, uint32, error) {
+	if len(rows) != 1 || len(rows[0]) < 2 {
+		return 0, 0, fmt.Errorf("BASE_BACKUP: unexpected position result %q", rows)
+	}
+	lsn, err := postgres.ParseLSN(string(rows[0][0]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("BASE_BACKUP: %w", err)
+	}
+	tli, err := strconv.ParseUint(string(rows[0][1]), 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("BASE_BACKUP: bad timeline %q", rows[0][1])
+	}
+	return lsn, uint32(tli), nil
+}
+
+// readResultSet reads RowDescription/DataRow messages until CommandComplete.
+// Values are copied because pgproto3 reuses its buffers.
+func readResultSet(ctx context.Context, conn *pgconn.PgConn) ([][][]byte, error) {
+	var rows [][][]byte
+	for {
+		msg, err := conn.ReceiveMessage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		switch m := msg.(type) {
+		case *pgproto3.RowDescription:
+		case *pgproto3.DataRow:
+			row := make([][]byte, len(m.Values))
+			for i, v := range m.Values {
+				if v != nil {
+					row[i] = append([]byte{}, v...)
+				}
+			}
+			rows = append(rows, row)
+		case *pgproto3.CommandComplete:
+			return rows, nil
+		case *pgproto3.ErrorResponse:
+			return nil, pgconn.ErrorResponseToPgError(m)
+		case *pgproto3.NoticeResponse:
+		default:
+			return nil, fmt.Errorf("unexpected message %T", msg)
+		}
+	}
+}
+
+// waitReady drains messages until ReadyForQuery.
+func waitReady(ctx context.Context, conn *pgconn.PgConn) error {
+	for {
+		msg, err := conn.ReceiveMessage(ctx)
+		if err != nil {
+			return err
+		}
+		switch m := msg.(type) {
+		case *pgproto3.ReadyForQuery:
+			return nil
+		case *pgproto3.ErrorResponse:
+			return pgconn.ErrorResponseToPgError(m)
+		}
+	}
+}
+
+// archive feeds one tar stream into Extract running in its own goroutine.
+type archive struct {
+	pw    *io.PipeWriter
+	done  chan error
+	files int64
+	bytes int64
+}
+
+func startArchive(dst string) *archive {
+	pr, pw := io.Pipe()
+	a := &archive{pw: pw, done: make(chan error, 1)}
+	go func() {
+		files, n, err := Extract(pr, dst)
+		a.files, a.bytes = files, n
+		if err != nil {
+			_ = pr.CloseWithError(err)
+		} else {
+			// consume trailing zero blocks so the writer never blocks
+			_, _ = io.Copy(io.Discard, pr)
+		}
+		a.done <- err
+	}()
+	return a
+}
+
+func (a *archive) write(p []byte) error {
+	if _, err := a.pw.Write(p); err != nil {
+		return fmt.Errorf("extract archive: %w", err)
+	}
+	return nil
+}
+
+func (a *archive) finish() error {
+	_ = a.pw.Close()
+	return <-a.done
+}
+
+func (a *archive) abort() {
+	_ = a.pw.CloseWithError(io.ErrUnexpectedEOF)
+	<-a.done
+}
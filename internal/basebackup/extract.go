@@ -0,0 +1,94 @@
+package basebackup
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Extract unpacks a tar stream produced by BASE_BACKUP into dst.
+// It returns number of regular files written and their total size.
+// Entries escaping dst (absolute paths, "..") are rejected.
+func Extract(r io.Reader, dst string) (files int64, bytes int64, err error) {
+	if err := os.MkdirAll(dst, 0o700); err != nil {
+		return 0, 0, err
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return files, bytes, nil
+		}
+		if err != nil {
+			return files, bytes, fmt.Errorf("read tar: %w", err)
+		}
+		target, err := safeJoin(dst, hdr.Name)
+		if err != nil {
+			return files, bytes, err
+		}
+		mode := hdr.FileInfo().Mode().Perm()
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o700); err != nil {
+				return files, bytes, err
+			}
+			_ = os.Chmod(target, mode)
+		case tar.TypeSymlink:
+			// pg_tblspc/<oid> links are recreated from tablespace_map anyway; keep them consistent with the archive
+			if err := os.RemoveAll(target); err != nil {
+				return files, bytes, err
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0o700); err != nil {
+				return files, bytes, err
+			}
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return files, bytes, err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0o700); err != nil {
+				return files, bytes, err
+			}
+			n, err := writeFile(target, tr, mode)
+			if err != nil {
+				return files, bytes, err
+			}
+			_ = os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+			files++
+			bytes += n
+		default:
+			slog.Debug("basebackup: skip tar entry", "name", hdr.Name, "type", hdr.Typeflag)
+		}
+	}
+}
+
+func writeFile(path string, r io.Reader, mode os.FileMode) (int64, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, r)
+	if err != nil {
+		_ = f.Close()
+		return n, fmt.Errorf("write %s: %w", path, err)
+	}
+	return n, f.Close()
+}
+
+// safeJoin joins name to root and ensures the result stays inside root.
+func safeJoin(root, name string) (string, error) {
+	if filepath.IsAbs(name) {
+		return "", fmt.Errorf("tar entry %q: absolute path", name)
+	}
+	p := filepath.Join(root, name)
+	rel, err := filepath.Rel(root, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("tar entry %q escapes %s", name, root)
+	}
+	return p, nil
+}
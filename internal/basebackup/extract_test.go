@@ -0,0 +1,86 @@
+package basebackup
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func buildTar(t *testing.T, entries []tar.Header, bodies map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, h := range entries {
+		h := h
+		body := bodies[h.Name]
+		h.Size = int64(len(body))
+		if err := tw.WriteHeader(&h); err != nil {
+			t.Fatalf("write header: %v", err)
+		}
+		if body != "" {
+			if _, err := tw.Write([]byte(body)); err != nil {
+				t.Fatalf("write body: %v", err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("close tar: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtract(t *testing.T) {
+	data := buildTar(t, []tar.Header{
+		{Name: "base/", Typeflag: tar.TypeDir, Mode: 0o700},
+		{Name: "base/1/", Typeflag: tar.TypeDir, Mode: 0o700},
+		{Name: "base/1/1259", Typeflag: tar.TypeReg, Mode: 0o600},
+		{Name: "PG_VERSION", Typeflag: tar.TypeReg, Mode: 0o600},
+		{Name: "pg_tblspc/16384", Typeflag: tar.TypeSymlink, Linkname: "/srv/spc"},
+	}, map[string]string{"base/1/1259": "relation", "PG_VERSION": "15\n"})
+
+	dst := t.TempDir()
+	files, n, err := Extract(bytes.NewReader(data), dst)
+	if err != nil {
+		t.Fatalf("extract: %v", err)
+	}
+	if files != 2 || n != int64(len("relation")+len("15\n")) {
+		t.Fatalf("unexpected counters: files=%d bytes=%d", files, n)
+	}
+	got, err := os.ReadFile(filepath.Join(dst, "base", "1", "1259"))
+	if err != nil || string(got) != "relation" {
+		t.Fatalf("relation file: %q, %v", got, err)
+	}
+	link, err := os.Readlink(filepath.Join(dst, "pg_tblspc", "16384"))
+	if err != nil || link != "/srv/spc" {
+		t.Fatalf("symlink: %q, %v", link, err)
+	}
+}
+
+func TestExtractRejectsEscape(t *testing.T) {
+	data := buildTar(t, []tar.Header{
+		{Name: "../evil", Typeflag: tar.TypeReg, Mode: 0o600},
+	}, map[string]string{"../evil": "x"})
+	if _, _, err := Extract(bytes.NewReader(data), t.TempDir()); err == nil {
+		t.Fatalf("expected error for entry escaping destination")
+	}
+}
+
+func TestParseArchiveHeader(t *testing.T) {
+	name, path, err := parseArchiveHeader([]byte("16384.tar\x00/srv/spc\x00"))
+	if err != nil || name != "16384.tar" || path != "/srv/spc" {
+		t.Fatalf("unexpected header parse: %q %q %v", name, path, err)
+	}
+	if _, _, err := parseArchiveHeader([]byte("base.tar")); err == nil {
+		t.Fatalf("expected error for malformed header")
+	}
+}
+
+func TestCommand(t *testing.T) {
+	got := Options{Label: "it's", FastCheckpoint: true}.Command()
+	want := "BASE_BACKUP ( LABEL 'it''s', PROGRESS, CHECKPOINT 'fast', WAIT false, TABLESPACE_MAP )"
+	if got != want {
+		t.Fatalf("command mismatch\nwant %s\n got %s", want, got)
+	}
+}
@@ -0,0 +1,29 @@
+package cli
+
+import (
+	"context"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/vbp1/pgclone/internal/clone"
+	"github.com/vbp1/pgclone/internal/util/signalctx"
+)
+
+// agentCmd is the replica side of controller mode, started over SSH by pgclone --replica-host;
+// it talks JSON lines on stdin/stdout and is not meant to be run by hand.
+var agentCmd = &cobra.Command{
+	Use:    "agent",
+	Short:  "Replica-side agent of controller mode (started by pgclone --replica-host)",
+	Hidden: true,
+	Args:   cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel, _ := signalctx.WithSignals(context.Background())
+		defer cancel()
+		return clone.ServeAgent(ctx, os.Stdin, os.Stdout)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(agentCmd)
+}
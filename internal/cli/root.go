@@ -4,6 +4,10 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 
@@ -11,8 +15,12 @@ import (
 	"github.com/vbp1/pgclone/internal/debug"
 	"github.com/vbp1/pgclone/internal/lock"
 	"github.com/vbp1/pgclone/internal/log"
+	"github.com/vbp1/pgclone/internal/patroni"
+	"github.com/vbp1/pgclone/internal/pgconf"
+	"github.com/vbp1/pgclone/internal/postgres"
 	"github.com/vbp1/pgclone/internal/runctx"
 	"github.com/vbp1/pgclone/internal/util/signalctx"
+	"github.com/vbp1/pgclone/internal/wal"
 )
 
 // Config holds values of CLI flags
@@ -22,12 +30,32 @@ type Config struct {
 	PGHost        string
 	PGPort        int
 	PGUser        string
+	PGDatabase    string
+	PGSSLMode     string
+	PGService     string
+	PGPassFile    string
+	Conninfo      string
+	RecoveryConf  bool
+	Detach        bool
+	TargetTime    string
+	TargetLSN     string
+	FromStandby   bool
+	MaxStandbyLag time.Duration
+	PatroniURLs   []string
+	PatroniRole   string
 	PrimaryPGData string
 	ReplicaPGData string
 	ReplicaWALDir string
 	SSHKey        string
 	SSHUser       string
 	TempWALDir    string
+	WALReceiver   string
+	WALCompress   string
+	WALSource     string
+	WALArchive    string
+	RestoreCmd    bool
+	Method        string
+	Incremental   bool
 	Parallel      int
 	Paranoid      bool
 	DropExisting  bool
@@ -38,6 +66,31 @@ type Config struct {
 	Progress      string
 	ProgressInt   int
 	Verbose       bool
+
+	Handoff        bool
+	HandoffTimeout time.Duration
+	ReplicaAppName string
+
+	Start         bool
+	PGCtl         string
+	StartLog      string
+	StartTimeout  time.Duration
+	MaxReplicaLag int64
+
+	ValidateBoot bool
+	ValidateSQL  []string
+
+	SetGUC    []string
+	UnsetGUC  []string
+	RulesFile string
+
+	NoLocal     bool
+	SpcMappings []string
+
+	ReplicaHost    string
+	ReplicaSSHUser string
+	RemotePgclone  string
+	Targets        []string
 }
 
 var cfg = &Config{}
@@ -70,19 +123,36 @@ var RootCmd = &cobra.Command{
 			}
 		}()
 
-		// file lock on replica PGDATA (must be provided at this point)
-		if cfg.ReplicaPGData == "" {
-			return fmt.Errorf("--replica-pgdata required before running")
+		if err := validate(cfg); err != nil {
+			return err
+		}
+
+		rules, err := confRules(cfg)
+		if err != nil {
+			return err
 		}
-		lk := lock.New(cfg.ReplicaPGData)
-		ok, err := lk.TryLock()
+		spcMapping, err := tablespaceMapping(cfg.SpcMappings)
 		if err != nil {
-			return fmt.Errorf("acquire lock: %w", err)
+			return err
 		}
-		if !ok {
-			return fmt.Errorf("another pgclone process is running for %s", cfg.ReplicaPGData)
+		targets, err := cloneTargets(cfg)
+		if err != nil {
+			return err
+		}
+
+		// file lock on replica PGDATA; in controller mode and with several targets the replica
+		// side of each target locks it
+		if cfg.ReplicaHost == "" && len(targets) == 0 {
+			lk := lock.New(cfg.ReplicaPGData)
+			ok, err := lk.TryLock()
+			if err != nil {
+				return fmt.Errorf("acquire lock: %w", err)
+			}
+			if !ok {
+				return fmt.Errorf("another pgclone process is running for %s", cfg.ReplicaPGData)
+			}
+			defer func() { _ = lk.Unlock() }()
 		}
-		defer func() { _ = lk.Unlock() }()
 
 		// main context with signals
 		ctx, cancel, _ := signalctx.WithSignals(context.Background())
@@ -95,17 +165,67 @@ var RootCmd = &cobra.Command{
 			PrimaryPGData: cfg.PrimaryPGData,
 			ReplicaPGData: cfg.ReplicaPGData,
 			ReplicaWALDir: cfg.ReplicaWALDir,
+			DropExisting:  cfg.DropExisting,
 			SSHKey:        cfg.SSHKey,
 			SSHUser:       cfg.SSHUser,
 			InsecureSSH:   cfg.InsecureSSH,
 			TempWALDir:    cfg.TempWALDir,
+			WALReceiver:   cfg.WALReceiver,
+			WALCompress:   cfg.WALCompress,
 			UseSlot:       cfg.UseSlot,
+			Method:        cfg.Method,
 			Parallel:      cfg.Parallel,
 			Paranoid:      cfg.Paranoid,
 			Verbose:       cfg.Verbose,
 			KeepRunTmp:    cfg.KeepRunTmp,
 			Progress:      cfg.Progress,
 			ProgressInt:   cfg.ProgressInt,
+
+			Handoff:        cfg.Handoff,
+			HandoffTimeout: cfg.HandoffTimeout,
+			ReplicaAppName: cfg.ReplicaAppName,
+
+			WALSource:           cfg.WALSource,
+			WALArchive:          cfg.WALArchive,
+			WriteRestoreCommand: cfg.RestoreCmd,
+
+			PrimaryConninfo:   cfg.Conninfo,
+			PGDatabase:        cfg.PGDatabase,
+			PGSSLMode:         cfg.PGSSLMode,
+			PGService:         cfg.PGService,
+			PGPassFile:        cfg.PGPassFile,
+			WriteRecoveryConf: cfg.RecoveryConf,
+
+			Detach:             cfg.Detach,
+			RecoveryTargetTime: cfg.TargetTime,
+			RecoveryTargetLSN:  cfg.TargetLSN,
+
+			FromStandby:   cfg.FromStandby,
+			MaxStandbyLag: cfg.MaxStandbyLag,
+
+			Incremental: cfg.Incremental,
+
+			PatroniURLs: cfg.PatroniURLs,
+			PatroniRole: cfg.PatroniRole,
+
+			Start:         cfg.Start,
+			PGCtl:         cfg.PGCtl,
+			StartLog:      cfg.StartLog,
+			StartTimeout:  cfg.StartTimeout,
+			MaxReplicaLag: cfg.MaxReplicaLag,
+
+			ValidateBoot: cfg.ValidateBoot,
+			ValidateSQL:  cfg.ValidateSQL,
+
+			ConfRules: rules,
+
+			DisableLocal:      cfg.NoLocal,
+			TablespaceMapping: spcMapping,
+
+			ReplicaHost:    cfg.ReplicaHost,
+			ReplicaSSHUser: cfg.ReplicaSSHUser,
+			RemotePgclone:  cfg.RemotePgclone,
+			Targets:        targets,
 		}
 
 		if err := clone.Run(ctx, cloneCfg); err != nil {
@@ -117,24 +237,223 @@ var RootCmd = &cobra.Command{
 	},
 }
 
+// validate checks flag combinations that cobra cannot express.
+func validate(c *Config) error {
+	if c.ReplicaPGData == "" {
+		return fmt.Errorf("--replica-pgdata required before running")
+	}
+	if len(c.PatroniURLs) > 0 {
+		if c.PGHost != "" {
+			return fmt.Errorf("--pghost and --patroni-url are mutually exclusive")
+		}
+		switch c.PatroniRole {
+		case patroni.RoleLeader:
+		case patroni.RoleReplica:
+			c.FromStandby = true
+		default:
+			return fmt.Errorf("unknown --patroni-role %q (want %s|%s)", c.PatroniRole, patroni.RoleLeader, patroni.RoleReplica)
+		}
+	} else if c.PGHost == "" && c.Conninfo == "" && c.PGService == "" && os.Getenv("PGHOST") == "" && os.Getenv("PGSERVICE") == "" {
+		return fmt.Errorf("primary not specified: use --pghost, --primary-conninfo, --pgservice or --patroni-url")
+	}
+	switch c.Method {
+	case clone.MethodRsync:
+	case clone.MethodBaseBackup:
+		if c.Incremental {
+			return fmt.Errorf("--incremental requires --method %s", clone.MethodRsync)
+		}
+	default:
+		return fmt.Errorf("unknown --method %q (want %s|%s)", c.Method, clone.MethodRsync, clone.MethodBaseBackup)
+	}
+	switch c.WALReceiver {
+	case clone.ReceiverNative, clone.ReceiverPgReceivewal:
+	default:
+		return fmt.Errorf("unknown --wal-receiver %q (want %s|%s)", c.WALReceiver, clone.ReceiverNative, clone.ReceiverPgReceivewal)
+	}
+	if !wal.ValidCompress(c.WALCompress) {
+		return fmt.Errorf("unknown --wal-compress %q (want none|gzip|lz4|zstd)", c.WALCompress)
+	}
+	switch c.WALSource {
+	case clone.WALSourceStream:
+	case clone.WALSourceArchive:
+		if c.WALArchive == "" {
+			return fmt.Errorf("--wal-archive required for --wal-source %s", c.WALSource)
+		}
+	default:
+		return fmt.Errorf("unknown --wal-source %q (want %s|%s)", c.WALSource, clone.WALSourceStream, clone.WALSourceArchive)
+	}
+	if c.RestoreCmd && c.WALArchive == "" {
+		return fmt.Errorf("--write-restore-command requires --wal-archive")
+	}
+	if c.Detach {
+		if c.RecoveryConf || c.Handoff {
+			return fmt.Errorf("--detach cannot be combined with --write-recovery-conf or --handoff")
+		}
+		if c.TargetTime != "" && c.TargetLSN != "" {
+			return fmt.Errorf("--recovery-target-time and --recovery-target-lsn are mutually exclusive")
+		}
+		if c.TargetLSN != "" {
+			if _, err := postgres.ParseLSN(c.TargetLSN); err != nil {
+				return fmt.Errorf("--recovery-target-lsn: %w", err)
+			}
+		}
+	} else if c.TargetTime != "" || c.TargetLSN != "" {
+		return fmt.Errorf("--recovery-target-time/--recovery-target-lsn require --detach")
+	}
+	if c.ReplicaHost != "" || len(c.Targets) > 0 {
+		flag := "--replica-host"
+		if c.ReplicaHost == "" {
+			flag = "--target"
+		}
+		switch {
+		case c.Method != clone.MethodRsync:
+			return fmt.Errorf("%s requires --method %s", flag, clone.MethodRsync)
+		case c.WALSource != clone.WALSourceStream:
+			return fmt.Errorf("%s requires --wal-source %s", flag, clone.WALSourceStream)
+		case c.Incremental || c.Handoff:
+			return fmt.Errorf("%s cannot be combined with --incremental or --handoff", flag)
+		}
+	}
+	if len(c.ValidateSQL) > 0 && !c.ValidateBoot {
+		return fmt.Errorf("--validate-sql requires --validate-boot")
+	}
+	if c.Start && !c.RecoveryConf && !c.Detach {
+		// without either the copy comes up as a second writable primary
+		return fmt.Errorf("--start requires --write-recovery-conf or --detach")
+	}
+	if (c.Start || c.ValidateBoot) && c.StartTimeout <= 0 {
+		return fmt.Errorf("--start-timeout must be positive")
+	}
+	if c.WALReceiver == clone.ReceiverPgReceivewal && c.WALCompress == wal.CompressZstd {
+		return fmt.Errorf("pg_receivewal does not support --wal-compress %s", c.WALCompress)
+	}
+	return nil
+}
+
+// confRules collects the postgresql.auto.conf rules: the rules file first, then --set, then --unset.
+func confRules(c *Config) ([]pgconf.Rule, error) {
+	var rules []pgconf.Rule
+	if c.RulesFile != "" {
+		r, err := pgconf.LoadRules(c.RulesFile)
+		if err != nil {
+			return nil, fmt.Errorf("--conf-rules: %w", err)
+		}
+		rules = r
+	}
+	for _, s := range c.SetGUC {
+		r, err := pgconf.ParseSet(s)
+		if err != nil {
+			return nil, fmt.Errorf("--set: %w", err)
+		}
+		rules = append(rules, r)
+	}
+	for _, s := range c.UnsetGUC {
+		r, err := pgconf.ParseUnset(s)
+		if err != nil {
+			return nil, fmt.Errorf("--unset: %w", err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// tablespaceMapping parses OLDDIR=NEWDIR pairs (both absolute) into a map keyed by the cleaned OLDDIR.
+func tablespaceMapping(pairs []string) (map[string]string, error) {
+	m := make(map[string]string, len(pairs))
+	for _, p := range pairs {
+		oldDir, newDir, ok := strings.Cut(p, "=")
+		if !ok || !filepath.IsAbs(oldDir) || !filepath.IsAbs(newDir) {
+			return nil, fmt.Errorf("--tablespace-mapping %q: want OLDDIR=NEWDIR with absolute paths", p)
+		}
+		m[filepath.Clean(oldDir)] = filepath.Clean(newDir)
+	}
+	return m, nil
+}
+
+// cloneTargets parses the --target values ([HOST:]PGDATA) and rejects targets that would
+// get in each other's way on one host, the --replica-host/--replica-pgdata target included.
+func cloneTargets(c *Config) ([]clone.Target, error) {
+	if len(c.Targets) == 0 {
+		return nil, nil
+	}
+	var targets []clone.Target
+	for _, spec := range c.Targets {
+		t := clone.Target{PGData: spec}
+		if i := strings.Index(spec, ":/"); i > 0 {
+			t = clone.Target{Host: spec[:i], PGData: spec[i+1:]}
+		}
+		if !filepath.IsAbs(t.PGData) {
+			return nil, fmt.Errorf("--target %q: want [HOST:]PGDATA with an absolute PGDATA", spec)
+		}
+		t.PGData = filepath.Clean(t.PGData)
+		targets = append(targets, t)
+	}
+	all := append([]clone.Target{{Host: c.ReplicaHost, PGData: filepath.Clean(c.ReplicaPGData)}}, targets...)
+	for i, a := range all {
+		for _, b := range all[i+1:] {
+			if a.Host != b.Host {
+				continue
+			}
+			switch {
+			case a.PGData == b.PGData || strings.HasPrefix(b.PGData, a.PGData+"/") || strings.HasPrefix(a.PGData, b.PGData+"/"):
+				return nil, fmt.Errorf("targets %s and %s overlap", a, b)
+			case c.ReplicaWALDir != "" || c.StartLog != "":
+				return nil, fmt.Errorf("targets %s and %s are on one host: --replica-waldir and --start-log would be shared", a, b)
+			case c.Start:
+				return nil, fmt.Errorf("targets %s and %s are on one host: --start would run both on the same port", a, b)
+			}
+		}
+	}
+	return targets, nil
+}
+
 // Execute parses flags and runs the root command.
 func Execute() error { return RootCmd.Execute() }
 
 func init() {
 	// Define global flags mirroring Bash version
 	f := RootCmd.Flags()
-	f.StringVar(&cfg.PGHost, "pghost", "", "Primary host (required)")
-	f.IntVar(&cfg.PGPort, "pgport", 5432, "Primary port (default 5432)")
-	f.StringVar(&cfg.PGUser, "pguser", "", "Primary user (required)")
-	f.StringVar(&cfg.PrimaryPGData, "primary-pgdata", "", "Primary PGDATA path (required)")
+	f.StringVar(&cfg.PGHost, "pghost", "", "Primary host; several comma-separated hosts = use the one that is read-write (or host in --primary-conninfo / service / PGHOST)")
+	f.IntVar(&cfg.PGPort, "pgport", 0, "Primary port (default from conninfo or 5432)")
+	f.StringVar(&cfg.PGUser, "pguser", "", "Primary user (default from conninfo or libpq default)")
+	f.StringVar(&cfg.PGDatabase, "pgdatabase", "", "Database for the control connection")
+	f.StringVar(&cfg.PGSSLMode, "pgsslmode", "", "libpq sslmode: disable|allow|prefer|require|verify-ca|verify-full")
+	f.StringVar(&cfg.PGService, "pgservice", "", "libpq service name from pg_service.conf")
+	f.StringVar(&cfg.PGPassFile, "pgpassfile", "", "Password file (default ~/.pgpass)")
+	f.StringVar(&cfg.Conninfo, "primary-conninfo", "", "Primary libpq DSN (keyword/value or URI: sslrootcert, sslcert, sslkey, ...); the options above override it")
+	f.BoolVar(&cfg.FromStandby, "from-standby", false, "Copy from a hot standby (the host must be in recovery) to offload the primary")
+	f.DurationVar(&cfg.MaxStandbyLag, "max-standby-lag", 5*time.Minute, "With --from-standby refuse a standby whose replay is further behind (0 = no limit)")
+	f.StringArrayVar(&cfg.PatroniURLs, "patroni-url", nil, "Patroni REST API URL (repeatable); the cluster member to clone from is taken from /cluster")
+	f.StringVar(&cfg.PatroniRole, "patroni-role", patroni.RoleLeader, "Patroni member to clone from: leader|replica (replica implies --from-standby)")
+	f.BoolVar(&cfg.RecoveryConf, "write-recovery-conf", false, "Write standby.signal and primary_conninfo (application_name = --replica-app-name) to the replica")
+	f.BoolVar(&cfg.Detach, "detach", false, "Make an independent copy: recovery.signal, recover to the end of the backup (or the target below) and promote; primary_conninfo/primary_slot_name are removed and archive_mode is turned off")
+	f.StringVar(&cfg.TargetTime, "recovery-target-time", "", "With --detach recover up to this timestamp (WAL past the backup needs --write-restore-command)")
+	f.StringVar(&cfg.TargetLSN, "recovery-target-lsn", "", "With --detach recover up to this LSN (WAL past the backup needs --write-restore-command)")
+	f.StringArrayVar(&cfg.SetGUC, "set", nil, "Set a parameter in the replica postgresql.auto.conf after the copy: name=value (repeatable)")
+	f.StringArrayVar(&cfg.UnsetGUC, "unset", nil, "Remove a parameter from the replica postgresql.auto.conf after the copy (repeatable)")
+	f.StringVar(&cfg.RulesFile, "conf-rules", "", "File of replica settings: \"name = value\" and \"unset name\" lines, applied before --set/--unset")
+	f.StringVar(&cfg.PrimaryPGData, "primary-pgdata", "", "Primary PGDATA path (default: data_directory reported by the primary)")
 	f.StringVar(&cfg.ReplicaPGData, "replica-pgdata", "", "Replica PGDATA path (default same as primary)")
 	f.StringVar(&cfg.ReplicaWALDir, "replica-waldir", "", "Replica pg_wal path (optional)")
 	f.StringVar(&cfg.SSHKey, "ssh-key", "", "SSH private key file")
-	f.StringVar(&cfg.SSHUser, "ssh-user", "", "SSH user (required)")
+	f.StringVar(&cfg.SSHUser, "ssh-user", "", "SSH user (required for --method rsync unless the primary is on this host)")
+	f.BoolVar(&cfg.NoLocal, "no-local", false, "Use SSH + rsync even when the primary is on this host (socket or loopback --pghost with a readable --primary-pgdata)")
+	f.StringArrayVar(&cfg.SpcMappings, "tablespace-mapping", nil, "Put the tablespace at OLDDIR into NEWDIR on the replica: OLDDIR=NEWDIR (repeatable; required for tablespaces in local mode)")
+	f.StringVar(&cfg.ReplicaHost, "replica-host", "", "Controller mode: run the replica side (WAL receiver, rsync workers, file writes, --start) on this host over SSH; --replica-pgdata and --temp-waldir are paths there")
+	f.StringVar(&cfg.ReplicaSSHUser, "replica-ssh-user", "", "SSH user on --replica-host, owner of the replica PGDATA (default --ssh-user)")
+	f.StringVar(&cfg.RemotePgclone, "remote-pgclone", "", "pgclone binary on --replica-host (default: upload this executable for the run)")
+	f.StringArrayVar(&cfg.Targets, "target", nil, "Further replica cloned from the same backup session and WAL: [HOST:]PGDATA (repeatable; every target copies PGDATA from the primary on its own, a HOST runs an agent as with --replica-host, application_name gets a _N suffix per target)")
 	f.StringVar(&cfg.TempWALDir, "temp-waldir", "", "Temporary WAL directory")
+	f.StringVar(&cfg.WALReceiver, "wal-receiver", clone.ReceiverNative, "WAL receiver: native (built-in replication client) | pg_receivewal (external binary)")
+	f.StringVar(&cfg.WALCompress, "wal-compress", wal.CompressNone, "Compress WAL in the temporary directory: none|gzip|lz4|zstd (lz4/zstd need the CLI tools)")
+	f.StringVar(&cfg.WALSource, "wal-source", clone.WALSourceStream, "WAL source: stream (receiver during the copy) | archive (copy from --wal-archive after backup stop)")
+	f.StringVar(&cfg.WALArchive, "wal-archive", "", "WAL archive directory or restore_command-style command with %f/%p")
+	f.BoolVar(&cfg.RestoreCmd, "write-restore-command", false, "Write restore_command for --wal-archive to replica postgresql.auto.conf")
+	f.StringVar(&cfg.Method, "method", clone.MethodRsync, "Copy method: rsync (SSH + rsyncd) | basebackup (replication protocol BASE_BACKUP)")
+	f.BoolVar(&cfg.Incremental, "incremental", false, "Refresh an existing, stopped replica: fetch only blocks changed since its last checkpoint using WAL summaries (PostgreSQL 17+, summarize_wal = on)")
 	f.IntVar(&cfg.Parallel, "parallel", 0, "Number of parallel rsync jobs (default: CPU cores)")
 	f.BoolVar(&cfg.Paranoid, "paranoid", false, "Enable checksum verification (slow)")
-	f.BoolVar(&cfg.DropExisting, "drop-existing", false, "Remove existing data in replica PGDATA before cloning")
+	f.BoolVar(&cfg.DropExisting, "drop-existing", false, "Let --method basebackup empty a non-empty replica PGDATA or tablespace directory")
 	f.BoolVar(&cfg.Debug, "debug", false, "Enable debug trace output")
 	f.BoolVar(&cfg.KeepRunTmp, "keep-run-tmp", false, "Preserve temporary run directory")
 	f.BoolVar(&cfg.UseSlot, "slot", false, "Use a temporary physical replication slot")
@@ -142,9 +461,14 @@ func init() {
 	f.StringVar(&cfg.Progress, "progress", "auto", "Progress display mode: auto|bar|plain|none")
 	f.IntVar(&cfg.ProgressInt, "progress-interval", 30, "Seconds between updates in plain mode")
 	f.BoolVar(&cfg.Verbose, "verbose", false, "Verbose output")
-
-	_ = RootCmd.MarkFlagRequired("pghost")
-	_ = RootCmd.MarkFlagRequired("pguser")
-	_ = RootCmd.MarkFlagRequired("primary-pgdata")
-	_ = RootCmd.MarkFlagRequired("ssh-user")
+	f.BoolVar(&cfg.Handoff, "handoff", false, "After the copy keep streaming WAL into replica pg_wal until the replica connects to the primary")
+	f.DurationVar(&cfg.HandoffTimeout, "handoff-timeout", 30*time.Minute, "Maximum time to wait for the replica in --handoff mode")
+	f.StringVar(&cfg.ReplicaAppName, "replica-app-name", "walreceiver", "application_name of the replica in pg_stat_replication")
+	f.BoolVar(&cfg.Start, "start", false, "Start the replica with pg_ctl after the copy (requires --write-recovery-conf or --detach); with --write-recovery-conf also wait until it streams from the primary, with --detach until it is promoted")
+	f.StringVar(&cfg.PGCtl, "pg-ctl", "pg_ctl", "pg_ctl binary used by --start and --validate-boot")
+	f.StringVar(&cfg.StartLog, "start-log", "", "Server log file of the started replica (default <replica-pgdata>/pg_ctl.log)")
+	f.DurationVar(&cfg.StartTimeout, "start-timeout", 10*time.Minute, "Maximum time to reach consistency, and then to catch up, in --start and --validate-boot mode")
+	f.BoolVar(&cfg.ValidateBoot, "validate-boot", false, "Boot the clone once as a throwaway hot standby on a free port, run checks and shut it down before finishing")
+	f.StringArrayVar(&cfg.ValidateSQL, "validate-sql", nil, "SQL check for --validate-boot, run in the postgres database (repeatable; default: count databases, bt_index_check on sampled indexes where amcheck is installed)")
+	f.Int64Var(&cfg.MaxReplicaLag, "max-replica-lag", 16<<20, "Replay lag in bytes the started replica must get below to count as healthy")
 }
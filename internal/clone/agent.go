@@ -0,0 +1,369 @@
+package clone
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/vbp1/pgclone/internal/lock"
+	"github.com/vbp1/pgclone/internal/log"
+	"github.com/vbp1/pgclone/internal/pgctl"
+	"github.com/vbp1/pgclone/internal/postgres"
+	"github.com/vbp1/pgclone/internal/rsync"
+	"github.com/vbp1/pgclone/internal/wal"
+)
+
+// Controller mode: pgclone runs on a third host and starts "pgclone agent" on the replica host
+// over SSH. The controller keeps the control session, rsyncd and backup start/stop; the agent
+// streams WAL, runs the rsync workers and writes and configures the replica. Requests and
+// replies are JSON lines on the agent's stdin/stdout; its stderr (logs, plain progress) is
+// copied to the controller's stderr. Targets on the controller host are served in-process by
+// the same handlers (localReplica).
+
+// Agent requests, in the order the controller sends them.
+const (
+	agentInit    = "init"    // initArgs: replica-side config, lock replica PGDATA
+	agentStream  = "stream"  // streamArgs: start the WAL receiver in a temp directory
+	agentCopy    = "copy"    // copyArgs: initial and parallel rsync from the primary rsyncd
+	agentBackup  = "backup"  // backupArgs: write backup_label, tablespace_map, pg_control
+	agentWAL     = "wal"     // collect, complete and verify WAL from START to STOP LSN
+	agentVerify  = "verify"  // instead of wal: verify the WAL copied from the first target
+	agentFinish  = "finish"  // final checks, replica configuration, validation boot, start
+	agentRunning = "running" // reply: whether postmaster.pid of the replica exists
+
+	agentCancel = "cancel" // not replied to: abort the request in progress
+)
+
+// agentCleanupTimeout bounds the cleanup of an agent whose controller hung up.
+const agentCleanupTimeout = 30 * time.Second
+
+// errControllerGone aborts the agent when its stdin closes.
+var errControllerGone = errors.New("controller closed the connection")
+
+// agentErrorKinds are the error categories that survive the trip from agent to controller.
+var agentErrorKinds = []error{ErrWALStreamLost, ErrReplicaUnhealthy, ErrValidateBoot, wal.ErrTimelineSwitch}
+
+type agentRequest struct {
+	ID   int             `json:"id"`
+	Op   string          `json:"op"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+// agentReply is a line written by the agent: something it printed, or the reply to request ID.
+type agentReply struct {
+	Stdout string          `json:"stdout,omitempty"`
+	ID     int             `json:"id,omitempty"`
+	Done   bool            `json:"done,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+	Kind   string          `json:"kind,omitempty"` // one of agentErrorKinds the error wraps
+}
+
+type initArgs struct {
+	Config      *Config `json:"config"`
+	Conninfo    string  `json:"conninfo"`     // resolved primary conninfo (receiver, primary_conninfo)
+	PrimaryHost string  `json:"primary_host"` // rsyncd host
+	Debug       bool    `json:"debug"`
+	Label       string  `json:"label,omitempty"` // target name with several targets
+}
+
+type streamArgs struct {
+	Slot    string `json:"slot"`
+	AppName string `json:"app_name"`
+	SegSize uint64 `json:"seg_size"`
+}
+
+type copyArgs struct {
+	RsyncPort   int                   `json:"rsync_port"`
+	RsyncSecret string                `json:"rsync_secret"`
+	Excludes    []string              `json:"excludes"`
+	Tablespaces []postgres.Tablespace `json:"tablespaces"`
+}
+
+type backupArgs struct {
+	Label         []byte       `json:"label"`
+	TablespaceMap []byte       `json:"tablespace_map"`
+	Control       []byte       `json:"control"`
+	StartLSN      postgres.LSN `json:"start_lsn"`
+	StopLSN       postgres.LSN `json:"stop_lsn"`
+	SystemID      uint64       `json:"system_id"`
+	Timeline      uint32       `json:"timeline"`
+	SegSize       uint64       `json:"seg_size"`
+}
+
+// remoteError is an agent failure as seen by the controller; errors.Is still finds its category.
+type remoteError struct {
+	msg  string
+	kind error
+}
+
+func (e *remoteError) Error() string { return e.msg }
+func (e *remoteError) Unwrap() error { return e.kind }
+
+// replyError turns an error reply back into an error.
+func replyError(host string, m agentReply) error {
+	e := &remoteError{msg: fmt.Sprintf("replica host %s: %s", host, m.Error)}
+	for _, k := range agentErrorKinds {
+		if k.Error() == m.Kind {
+			e.kind = k
+		}
+	}
+	return e
+}
+
+// agent serves the requests of one controller connection, or of one target in-process.
+type agent struct {
+	o      *Orchestrator
+	lock   *lock.FileLock
+	remote bool // "pgclone agent": owns logging, stdout is the protocol
+
+	mu  sync.Mutex
+	enc *json.Encoder
+
+	opMu     sync.Mutex
+	opCancel context.CancelFunc // of the request in progress
+}
+
+// ServeAgent is the replica side of controller mode ("pgclone agent"): it executes the
+// requests read from in and writes the replies to out, together with everything printed to
+// os.Stdout meanwhile. It returns once in is closed, after stopping the WAL receiver.
+func ServeAgent(ctx context.Context, in io.Reader, out io.Writer) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	a := &agent{o: &Orchestrator{cancel: cancel}, remote: true, enc: json.NewEncoder(out)}
+	restore, err := a.forwardStdout()
+	if err != nil {
+		return err
+	}
+	defer restore()
+
+	// buffered: a cancel must get through while a request is handled
+	reqs := make(chan agentRequest, 16)
+	go func() {
+		defer close(reqs)
+		dec := json.NewDecoder(in)
+		for {
+			var req agentRequest
+			if err := dec.Decode(&req); err != nil {
+				if !errors.Is(err, io.EOF) {
+					slog.Warn("agent: read request", "err", err)
+				}
+				cancel(errControllerGone)
+				return
+			}
+			if req.Op == agentCancel {
+				a.cancelOp()
+				continue
+			}
+			reqs <- req
+		}
+	}()
+
+	for req := range reqs {
+		slog.Debug("agent request", "op", req.Op)
+		opCtx, opCancel := context.WithCancel(ctx)
+		a.opMu.Lock()
+		a.opCancel = opCancel
+		a.opMu.Unlock()
+		res, err := a.handle(opCtx, req)
+		opCancel()
+		if err != nil {
+			err = abortCause(ctx, err)
+		}
+		a.reply(req.ID, res, err)
+	}
+
+	cctx, stop := context.WithTimeout(context.WithoutCancel(ctx), agentCleanupTimeout)
+	defer stop()
+	a.close(cctx)
+	return nil
+}
+
+// cancelOp aborts the request in progress, if any.
+func (a *agent) cancelOp() {
+	a.opMu.Lock()
+	defer a.opMu.Unlock()
+	if a.opCancel != nil {
+		a.opCancel()
+	}
+}
+
+// close stops what the requests started and releases the replica PGDATA.
+func (a *agent) close(ctx context.Context) {
+	a.o.Close(ctx)
+	if a.lock != nil {
+		_ = a.lock.Unlock()
+		a.lock = nil
+	}
+}
+
+// handle executes one request.
+func (a *agent) handle(ctx context.Context, req agentRequest) (any, error) {
+	o := a.o
+	if req.Op != agentInit && o.cfg == nil {
+		return nil, fmt.Errorf("agent: %s before %s", req.Op, agentInit)
+	}
+	switch req.Op {
+	case agentInit:
+		var args initArgs
+		if err := json.Unmarshal(req.Args, &args); err != nil {
+			return nil, err
+		}
+		if err := a.init(args); err != nil {
+			return nil, err
+		}
+		// the rsync workers of this host, for the controller's rsyncd connection limit
+		return rsync.Workers(args.Config.Parallel), nil
+	case agentStream:
+		var args streamArgs
+		if err := json.Unmarshal(req.Args, &args); err != nil {
+			return nil, err
+		}
+		o.slot, o.appName, o.segSize = args.Slot, args.AppName, args.SegSize
+		return nil, o.startTempReceiver(ctx)
+	case agentCopy:
+		var args copyArgs
+		if err := json.Unmarshal(req.Args, &args); err != nil {
+			return nil, err
+		}
+		o.rsyncPort, o.rsyncSecret, o.tablespaces = args.RsyncPort, args.RsyncSecret, args.Tablespaces
+		return nil, o.copyData(ctx, args.Excludes)
+	case agentBackup:
+		var args backupArgs
+		if err := json.Unmarshal(req.Args, &args); err != nil {
+			return nil, err
+		}
+		o.startLSN, o.stopLSN = args.StartLSN, args.StopLSN
+		o.systemID, o.timeline, o.segSize = args.SystemID, args.Timeline, args.SegSize
+		if err := o.writeBackupFiles(args.Label, args.TablespaceMap, args.Control); err != nil {
+			return nil, err
+		}
+		return nil, o.checkBackupFiles()
+	case agentWAL:
+		return nil, o.finalizeWAL(ctx)
+	case agentVerify:
+		return nil, o.checkWAL(ctx, o.replicaWALDir())
+	case agentFinish:
+		return nil, a.finish(ctx)
+	case agentRunning:
+		_, err := pgctl.ReadPidFile(o.cfg.ReplicaPGData)
+		return !errors.Is(err, os.ErrNotExist), nil
+	}
+	return nil, fmt.Errorf("agent: unknown request %q", req.Op)
+}
+
+// init takes over the replica-side configuration and locks the replica data directory.
+func (a *agent) init(args initArgs) error {
+	if a.o.cfg != nil {
+		return fmt.Errorf("agent: already initialized")
+	}
+	cfg := args.Config
+	if cfg == nil || cfg.ReplicaPGData == "" {
+		return fmt.Errorf("agent: replica PGDATA not set")
+	}
+	if a.remote {
+		// stdout is the protocol: no bars
+		if cfg.Progress != "none" {
+			cfg.Progress = "plain"
+		}
+		logger := log.Setup(args.Debug, cfg.Verbose)
+		if args.Label != "" {
+			slog.SetDefault(logger.With("target", args.Label))
+		}
+	}
+	conninfo, err := postgres.ParseConninfo(args.Conninfo)
+	if err != nil {
+		return err
+	}
+	lk := lock.New(cfg.ReplicaPGData)
+	ok, err := lk.TryLock()
+	if err != nil {
+		return fmt.Errorf("acquire lock: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("another pgclone process is running for %s", cfg.ReplicaPGData)
+	}
+	a.lock = lk
+	a.o.cfg, a.o.conninfo, a.o.primaryHost = cfg, conninfo, args.PrimaryHost
+	a.o.label = args.Label
+	slog.Info("replica side ready", "pgdata", cfg.ReplicaPGData)
+	return nil
+}
+
+// finish prepares the copied replica and, with cfg.Start, starts it; a detached clone is
+// also waited for until promoted. Waiting for a standby to stream is up to the controller.
+func (a *agent) finish(ctx context.Context) error {
+	o := a.o
+	if err := o.prepareReplica(ctx); err != nil {
+		return err
+	}
+	if !o.cfg.Start {
+		return nil
+	}
+	if err := o.startReplica(ctx); err != nil {
+		return err
+	}
+	if o.cfg.Detach {
+		return o.waitPromoted(ctx)
+	}
+	return nil
+}
+
+// reply sends the outcome of request id.
+func (a *agent) reply(id int, res any, err error) {
+	m := agentReply{ID: id, Done: true}
+	if err != nil {
+		m.Error = err.Error()
+		for _, k := range agentErrorKinds {
+			if errors.Is(err, k) {
+				m.Kind = k.Error()
+			}
+		}
+	} else if res != nil {
+		data, merr := json.Marshal(res)
+		if merr != nil {
+			m.Error = merr.Error()
+		}
+		m.Result = data
+	}
+	a.send(m)
+}
+
+func (a *agent) send(m agentReply) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.enc.Encode(m); err != nil {
+		slog.Warn("agent: write reply", "err", err)
+	}
+}
+
+// forwardStdout sends what is printed to os.Stdout as stdout messages until restore is called.
+func (a *agent) forwardStdout() (restore func(), err error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	orig := os.Stdout
+	os.Stdout = w
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sc := bufio.NewScanner(r)
+		for sc.Scan() {
+			a.send(agentReply{Stdout: sc.Text()})
+		}
+	}()
+	return func() {
+		os.Stdout = orig
+		_ = w.Close()
+		<-done
+		_ = r.Close()
+	}, nil
+}
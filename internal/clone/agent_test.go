@@ -0,0 +1,69 @@
+package clone
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAgentProtocol(t *testing.T) {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	served := make(chan error, 1)
+	go func() {
+		served <- ServeAgent(context.Background(), inR, outW)
+		_ = outW.Close()
+	}()
+	var printed bytes.Buffer
+	r := &remoteReplica{host: "replica1"}
+	r.attach(inW, outR, &printed)
+	ctx := context.Background()
+
+	if err := r.call(ctx, agentRunning, nil, nil); err == nil {
+		t.Fatal("request before init succeeded")
+	}
+	dir := t.TempDir()
+	if err := r.call(ctx, agentInit, initArgs{Config: &Config{ReplicaPGData: dir}, Conninfo: "host=db1"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []bool{false, true} {
+		var running bool
+		if err := r.call(ctx, agentRunning, nil, &running); err != nil {
+			t.Fatal(err)
+		}
+		if running != want {
+			t.Fatalf("running = %v, want %v", running, want)
+		}
+		_ = os.WriteFile(filepath.Join(dir, "postmaster.pid"), []byte("1\n"), 0o600)
+	}
+	fmt.Println("summary line")
+	if err := r.call(ctx, "bogus", nil, nil); err == nil || !strings.Contains(err.Error(), "replica1") {
+		t.Fatalf("unknown request: %v", err)
+	}
+
+	_ = inW.Close()
+	if err := <-served; err != nil {
+		t.Fatal(err)
+	}
+	for range r.replies {
+	}
+	if got := printed.String(); got != "summary line\n" {
+		t.Fatalf("forwarded stdout %q", got)
+	}
+}
+
+func TestReplyError(t *testing.T) {
+	err := replyError("replica1", agentReply{Error: "replica unhealthy: replica stopped", Kind: ErrReplicaUnhealthy.Error()})
+	if !errors.Is(err, ErrReplicaUnhealthy) || err.Error() != "replica host replica1: replica unhealthy: replica stopped" {
+		t.Fatalf("got %v", err)
+	}
+	if err := replyError("replica1", agentReply{Error: "boom"}); errors.Is(err, ErrReplicaUnhealthy) {
+		t.Fatalf("unexpected category: %v", err)
+	}
+}
@@ -1,29 +1,124 @@
 package clone
 
+import (
+	"time"
+
+	"github.com/vbp1/pgclone/internal/pgconf"
+)
+
+// Copy methods.
+const (
+	MethodRsync      = "rsync"      // pg_backup_start + rsyncd on the primary via SSH
+	MethodBaseBackup = "basebackup" // BASE_BACKUP over the replication protocol, no SSH/rsync needed
+)
+
+// WAL receivers.
+const (
+	ReceiverNative       = "native"        // built-in replication protocol client (wal.NativeReceiver)
+	ReceiverPgReceivewal = "pg_receivewal" // external pg_receivewal process (wal.Receiver)
+)
+
+// WAL sources.
+const (
+	WALSourceStream  = "stream"  // WAL receiver streams from the primary during the copy
+	WALSourceArchive = "archive" // WAL is copied from the WAL archive after pg_backup_stop
+)
+
 // Config collects parameters required by the clone orchestrator.
 // It is a subset/superset of CLI flags but lives in a standalone package to avoid import cycles.
 type Config struct {
-	PGHost        string
-	PGPort        int
+	PGHost        string // overrides the host of PrimaryConninfo
+	PGPort        int    // 0 = from PrimaryConninfo / libpq default
 	PGUser        string
-	PrimaryPGData string
+	PrimaryPGData string // empty = data_directory reported by the primary
 	ReplicaPGData string
 	ReplicaWALDir string
+	DropExisting  bool // let a base backup empty non-empty replica directories
+
+	// libpq connection options, applied to the control connection, the WAL receiver and
+	// the replica primary_conninfo alike; the individual fields override PrimaryConninfo.
+	PrimaryConninfo   string // full DSN (keyword/value or URI)
+	PGDatabase        string
+	PGSSLMode         string
+	PGService         string
+	PGPassFile        string
+	WriteRecoveryConf bool // write standby.signal and primary_conninfo to the replica
+
+	Detach             bool   // independent copy: recovery.signal, promote at the recovery target
+	RecoveryTargetTime string // with Detach: recovery_target_time instead of the end of the backup
+	RecoveryTargetLSN  string // with Detach: recovery_target_lsn instead of the end of the backup
+
+	ConfRules []pgconf.Rule // applied to the replica postgresql.auto.conf after the copy, in order
+
+	FromStandby   bool          // copy from a hot standby instead of the primary
+	MaxStandbyLag time.Duration // refuse a standby whose replay is further behind; 0 = no limit
+
+	PatroniURLs []string // Patroni REST API endpoints; the member found there replaces host/port
+	PatroniRole string   // patroni.RoleLeader (default) or patroni.RoleReplica
 
 	SSHKey      string
 	SSHUser     string
 	InsecureSSH bool
 
-	TempWALDir string
-	UseSlot    bool
-	SlotName   string // optional preset; if empty and UseSlot, Orchestrator will generate
+	TempWALDir  string
+	WALReceiver string // ReceiverNative (default) or ReceiverPgReceivewal
+	WALCompress string // compression of WAL in TempWALDir: none|gzip|lz4|zstd
+	UseSlot     bool
+	SlotName    string // optional preset; if empty and UseSlot, Orchestrator will generate
+
+	WALSource           string // WALSourceStream (default) or WALSourceArchive
+	WALArchive          string // archive directory or restore_command-style template (%f, %p)
+	WriteRestoreCommand bool   // write restore_command for WALArchive to postgresql.auto.conf
 
+	Method   string // MethodRsync (default) or MethodBaseBackup
 	Parallel int
 	Paranoid bool
 	Verbose  bool
 
+	Incremental bool // refresh relation files of an existing replica from WAL summaries (PostgreSQL 17+)
+
+	DisableLocal      bool              // always use SSH + rsync, even for a primary on this host
+	TablespaceMapping map[string]string // primary tablespace directory -> replica directory
+
+	// controller mode: this process keeps the control session, rsyncd and backup start/stop,
+	// "pgclone agent" on ReplicaHost streams WAL and writes ReplicaPGData there
+	ReplicaHost    string
+	ReplicaSSHUser string // SSH user on ReplicaHost; empty = SSHUser
+	RemotePgclone  string // pgclone binary on ReplicaHost; empty = upload the running executable
+
+	// further replicas cloned from the same backup session and WAL; each target runs its own
+	// rsync workers (in an agent for targets on another host)
+	Targets []Target
+
 	KeepRunTmp bool
 
+	Handoff        bool          // keep streaming into the replica pg_wal until the replica connects
+	HandoffTimeout time.Duration // give up the handoff after this long
+	ReplicaAppName string        // application_name the replica uses in pg_stat_replication
+
+	Start         bool          // start the replica with pg_ctl after the copy and wait until it is healthy
+	PGCtl         string        // pg_ctl binary; empty = from PATH
+	StartLog      string        // server log of the started replica; empty = ReplicaPGData/pg_ctl.log
+	StartTimeout  time.Duration // bound for reaching consistency and for streaming with MaxReplicaLag
+	MaxReplicaLag int64         // replay lag in bytes the started replica must get below
+
+	ValidateBoot bool     // boot the clone once as a throwaway hot standby before handing it off
+	ValidateSQL  []string // checks run in the postgres database; empty = database count + amcheck sample
+
 	Progress    string
 	ProgressInt int
 }
+
+// Target is a replica data directory, on Host (reached through an agent) or on this host.
+type Target struct {
+	Host   string
+	PGData string
+}
+
+// String returns "HOST:PGDATA", or PGDATA for a target on this host.
+func (t Target) String() string {
+	if t.Host == "" {
+		return t.PGData
+	}
+	return t.Host + ":" + t.PGData
+}
@@ -0,0 +1,183 @@
+package clone
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/vbp1/pgclone/internal/patroni"
+	"github.com/vbp1/pgclone/internal/pgconf"
+	"github.com/vbp1/pgclone/internal/postgres"
+)
+
+// probeTimeout bounds connecting to one candidate host of a multi-host conninfo.
+const probeTimeout = 15 * time.Second
+
+// primaryConninfo merges cfg.PrimaryConninfo with the individual connection options (options win).
+func primaryConninfo(cfg *Config) (postgres.Conninfo, error) {
+	c, err := postgres.ParseConninfo(cfg.PrimaryConninfo)
+	if err != nil {
+		return nil, fmt.Errorf("--primary-conninfo: %w", err)
+	}
+	if cfg.PGPort != 0 {
+		c = c.With("port", strconv.Itoa(cfg.PGPort))
+	}
+	return c.With("host", cfg.PGHost).
+		With("user", cfg.PGUser).
+		With("dbname", cfg.PGDatabase).
+		With("sslmode", cfg.PGSSLMode).
+		With("service", cfg.PGService).
+		With("passfile", cfg.PGPassFile), nil
+}
+
+// resolvePrimary builds the primary conninfo and resolves the host it points at
+// (service file and PG* environment included); SSH and rsync go to that host.
+// With several hosts the current read-write node (a standby with FromStandby) is located and
+// the conninfo pinned to it, so every later connection, the receiver and SSH/rsyncd use the
+// same node.
+func (o *Orchestrator) resolvePrimary(ctx context.Context) error {
+	c, err := primaryConninfo(o.cfg)
+	if err != nil {
+		return err
+	}
+	if len(o.cfg.PatroniURLs) > 0 {
+		m, err := o.patroniMember(ctx)
+		if err != nil {
+			return err
+		}
+		c = c.With("host", m.Host).With("port", strconv.Itoa(m.Port))
+	}
+	pc, err := pgconn.ParseConfig(c.String())
+	if err != nil {
+		return fmt.Errorf("primary conninfo: %w", err)
+	}
+	if hosts := candidateHosts(pc); len(hosts) > 1 {
+		probe := func(h hostPort) (bool, error) { return probeHost(ctx, c.With("host", h.host).With("port", h.port)) }
+		h, err := pickHost(hosts, !o.cfg.FromStandby, probe)
+		if err != nil {
+			return err
+		}
+		c = c.With("host", h.host).With("port", h.port)
+		slog.Info("clone source located", "host", h.host, "port", h.port, "candidates", len(hosts))
+		pc.Host = h.host
+	}
+	o.conninfo = c
+	o.primaryHost = pc.Host
+	slog.Debug("primary", "host", pc.Host, "port", pc.Port, "user", pc.User, "database", pc.Database)
+	return nil
+}
+
+// patroniMember asks Patroni for the member to clone from.
+func (o *Orchestrator) patroniMember(ctx context.Context) (patroni.Member, error) {
+	cl, err := (&patroni.Client{URLs: o.cfg.PatroniURLs}).Cluster(ctx)
+	if err != nil {
+		return patroni.Member{}, err
+	}
+	var m patroni.Member
+	if o.cfg.PatroniRole == patroni.RoleReplica {
+		m, err = cl.Replica(-1)
+	} else {
+		m, err = cl.Leader()
+	}
+	if err != nil {
+		return m, err
+	}
+	if m.Role == patroni.RoleStandbyLeader && !o.cfg.FromStandby {
+		// standby cluster: the source is in recovery
+		slog.Info("Patroni standby cluster, cloning from the standby leader", "member", m.Name)
+		o.cfg.FromStandby = true
+	}
+	slog.Info("Patroni member selected", "cluster", cl.Scope, "member", m.Name, "role", m.Role, "host", m.Host, "port", m.Port)
+	return m, nil
+}
+
+type hostPort struct{ host, port string }
+
+// candidateHosts lists the distinct host:port pairs of a (multi-host) conninfo in order.
+func candidateHosts(pc *pgconn.Config) []hostPort {
+	hosts := []hostPort{{pc.Host, strconv.Itoa(int(pc.Port))}}
+	for _, fb := range pc.Fallbacks {
+		hosts = append(hosts, hostPort{fb.Host, strconv.Itoa(int(fb.Port))})
+	}
+	// sslmode=prefer/allow repeat each host with another TLS setting
+	out := hosts[:0]
+	seen := map[hostPort]bool{}
+	for _, h := range hosts {
+		if !seen[h] {
+			seen[h] = true
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+// pickHost returns the first host probe reports as read-write (writable) or in recovery.
+func pickHost(hosts []hostPort, writable bool, probe func(hostPort) (bool, error)) (hostPort, error) {
+	want := "read-write primary"
+	if !writable {
+		want = "standby"
+	}
+	var errs []error
+	for _, h := range hosts {
+		rw, err := probe(h)
+		switch {
+		case err != nil:
+			slog.Warn("candidate host unreachable", "host", h.host, "port", h.port, "err", err)
+			errs = append(errs, fmt.Errorf("%s:%s: %w", h.host, h.port, err))
+		case rw == writable:
+			return h, nil
+		case rw:
+			errs = append(errs, fmt.Errorf("%s:%s: not in recovery", h.host, h.port))
+		default:
+			slog.Info("candidate host is a standby", "host", h.host, "port", h.port)
+			errs = append(errs, fmt.Errorf("%s:%s: in recovery", h.host, h.port))
+		}
+	}
+	return hostPort{}, fmt.Errorf("no %s among %d hosts: %w", want, len(hosts), errors.Join(errs...))
+}
+
+// probeHost reports whether the server behind c accepts writes.
+func probeHost(ctx context.Context, c postgres.Conninfo) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	conn, err := pgx.Connect(ctx, c.String())
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = conn.Close(ctx) }()
+	id, err := postgres.QueryIdentity(ctx, conn)
+	if err != nil {
+		return false, err
+	}
+	return !id.InRecovery, nil
+}
+
+// writeRecoveryConf turns the clone into a standby of the primary: standby.signal plus
+// primary_conninfo (and primary_slot_name for a user-supplied slot) in postgresql.auto.conf.
+func (o *Orchestrator) writeRecoveryConf() error {
+	signal := filepath.Join(o.cfg.ReplicaPGData, "standby.signal")
+	if err := os.WriteFile(signal, nil, 0o600); err != nil {
+		return err
+	}
+	conf, err := pgconf.Load(filepath.Join(o.cfg.ReplicaPGData, pgconf.AutoConf))
+	if err != nil {
+		return err
+	}
+	conf.Set("primary_conninfo", o.conninfo.With("application_name", o.cfg.ReplicaAppName).String())
+	if o.cfg.SlotName != "" {
+		conf.Set("primary_slot_name", o.cfg.SlotName)
+	}
+	if err := conf.Save(); err != nil {
+		return err
+	}
+	slog.Info("recovery configuration written", "file", conf.Path, "signal", signal)
+	return nil
+}
@@ -0,0 +1,81 @@
+package clone
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/vbp1/pgclone/internal/pgconf"
+)
+
+func TestPrimaryConninfo(t *testing.T) {
+	c, err := primaryConninfo(&Config{
+		PrimaryConninfo: "postgres://rep@old:6000/app?sslmode=verify-full&sslrootcert=/ca.pem",
+		PGHost:          "db1",
+		PGSSLMode:       "require",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "dbname=app host=db1 port=6000 sslmode=require sslrootcert=/ca.pem user=rep"
+	if got := c.String(); got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestWriteRecoveryConf(t *testing.T) {
+	dir := t.TempDir()
+	o := &Orchestrator{cfg: &Config{ReplicaPGData: dir, ReplicaAppName: "replica1", SlotName: "s1"}}
+	o.conninfo, _ = primaryConninfo(&Config{PrimaryConninfo: "host=db1 user=rep password='a b'"})
+	if err := o.writeRecoveryConf(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "standby.signal")); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := pgconf.Load(filepath.Join(dir, pgconf.AutoConf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := conf.Get("primary_conninfo"); v != "application_name=replica1 host=db1 password='a b' user=rep" {
+		t.Fatalf("primary_conninfo = %q", v)
+	}
+	if v, _ := conf.Get("primary_slot_name"); v != "s1" {
+		t.Fatalf("primary_slot_name = %q", v)
+	}
+}
+
+func TestCandidateHosts(t *testing.T) {
+	pc, err := pgconn.ParseConfig("host=db1,db2,db3 port=5432,5433,5432 sslmode=prefer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := candidateHosts(pc)
+	want := []hostPort{{"db1", "5432"}, {"db2", "5433"}, {"db3", "5432"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestPickHost(t *testing.T) {
+	hosts := []hostPort{{"db1", "5432"}, {"db2", "5432"}, {"db3", "5432"}}
+	state := map[string]error{"db1": errors.New("connection refused"), "db2": nil, "db3": nil}
+	rw := map[string]bool{"db3": true}
+	probe := func(h hostPort) (bool, error) { return rw[h.host], state[h.host] }
+	h, err := pickHost(hosts, true, probe)
+	if err != nil || h.host != "db3" {
+		t.Fatalf("got %v, %v", h, err)
+	}
+	if h, err := pickHost(hosts, false, probe); err != nil || h.host != "db2" {
+		t.Fatalf("standby: got %v, %v", h, err)
+	}
+	rw["db3"] = false
+	if _, err := pickHost(hosts, true, probe); err == nil || !strings.Contains(err.Error(), "db1:5432: connection refused") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,231 @@
+package clone
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vbp1/pgclone/internal/ssh"
+)
+
+// remoteReplica is the controller's end of the agent on the replica host.
+type remoteReplica struct {
+	host   string
+	cfg    *Config // the agent's configuration
+	client *ssh.Client
+	dir    string // remote temp dir of the uploaded binary; empty with cfg.RemotePgclone
+
+	mu      sync.Mutex // one request at a time
+	seq     int
+	in      io.WriteCloser  // agent stdin
+	enc     *json.Encoder   // writes to in
+	replies chan agentReply // closed once the agent output ends
+	done    chan struct{}   // closed when the agent exited; err is its exit status
+	err     error
+	kill    context.CancelFunc
+}
+
+// startAgent connects to host, uploads this executable unless cfg.RemotePgclone names one
+// installed there, and starts "pgclone agent" for the target configuration tcfg.
+func startAgent(ctx context.Context, cfg *Config, host string, tcfg *Config) (*remoteReplica, error) {
+	user := cfg.ReplicaSSHUser
+	if user == "" {
+		user = cfg.SSHUser
+	}
+	if user == "" {
+		return nil, fmt.Errorf("--replica-ssh-user or --ssh-user required for replica host %s", host)
+	}
+	client, err := ssh.Dial(ctx, ssh.Config{
+		User:     user,
+		Host:     host,
+		KeyPath:  cfg.SSHKey,
+		Insecure: cfg.InsecureSSH,
+		Timeout:  10 * time.Second,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("replica host %s: %w", host, err)
+	}
+	r := &remoteReplica{host: host, cfg: tcfg, client: client}
+	bin := cfg.RemotePgclone
+	if bin == "" {
+		if bin, err = r.upload(ctx); err != nil {
+			r.removeDir(ctx)
+			_ = client.Close()
+			return nil, err
+		}
+	}
+	r.start(fmt.Sprintf("'%s' agent", bin), os.Stdout, os.Stderr)
+	slog.Info("agent started", "host", r.host, "binary", bin)
+	return r, nil
+}
+
+// upload copies the running executable into a new temp directory on the replica host.
+func (r *remoteReplica) upload(ctx context.Context) (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(exe)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+	out, err := r.client.Output(ctx, "mktemp -d /tmp/pgclone_agent_XXXXXX")
+	if err != nil {
+		return "", fmt.Errorf("replica host %s: mktemp: %w", r.host, err)
+	}
+	r.dir = strings.TrimSpace(string(out))
+	bin := r.dir + "/pgclone"
+	if err := r.client.Upload(ctx, f, bin, 0o700); err != nil {
+		return "", fmt.Errorf("replica host %s: %w", r.host, err)
+	}
+	return bin, nil
+}
+
+// start runs cmd on the replica host: printed lines go to stdout, the agent's stderr to stderr.
+func (r *remoteReplica) start(cmd string, stdout, stderr io.Writer) {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	sctx, kill := context.WithCancel(context.Background())
+	r.attach(inW, outR, stdout)
+	r.kill = kill
+	go func() {
+		r.err = r.client.RunInput(sctx, cmd, inR, outW, stderr)
+		close(r.done)
+		_ = outW.Close()
+		_ = inR.CloseWithError(fmt.Errorf("agent exited"))
+	}()
+}
+
+// attach sets up the request/reply streams of an agent whose stdin is in and stdout out.
+func (r *remoteReplica) attach(in io.WriteCloser, out io.Reader, stdout io.Writer) {
+	r.in, r.enc = in, json.NewEncoder(in)
+	r.replies = make(chan agentReply, 1)
+	r.done = make(chan struct{})
+	go r.read(out, stdout)
+}
+
+// read prints the agent output lines and passes replies on until out ends.
+func (r *remoteReplica) read(out io.Reader, stdout io.Writer) {
+	defer close(r.replies)
+	sc := bufio.NewScanner(out)
+	sc.Buffer(make([]byte, 64<<10), 16<<20)
+	for sc.Scan() {
+		var m agentReply
+		if err := json.Unmarshal(sc.Bytes(), &m); err != nil {
+			slog.Debug("agent output", "host", r.host, "line", sc.Text())
+			continue
+		}
+		if !m.Done {
+			_, _ = fmt.Fprintln(stdout, m.Stdout)
+			continue
+		}
+		r.replies <- m
+	}
+}
+
+// call sends one request and waits for its reply; result (if not nil) receives the reply value.
+func (r *remoteReplica) call(ctx context.Context, op string, args, result any) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seq++
+	req := agentRequest{ID: r.seq, Op: op}
+	if args != nil {
+		data, err := json.Marshal(args)
+		if err != nil {
+			return err
+		}
+		req.Args = data
+	}
+	if err := r.enc.Encode(req); err != nil {
+		return fmt.Errorf("replica host %s: send %s: %w", r.host, op, err)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			// the reply still comes and is skipped by the next call
+			_ = r.enc.Encode(agentRequest{Op: agentCancel})
+			return ctx.Err()
+		case m, ok := <-r.replies:
+			if !ok {
+				<-r.done
+				return fmt.Errorf("replica host %s: agent exited during %s: %v", r.host, op, r.err)
+			}
+			if m.ID != req.ID {
+				continue // late reply to a request given up on
+			}
+			if m.Error != "" {
+				return replyError(r.host, m)
+			}
+			if result != nil {
+				return json.Unmarshal(m.Result, result)
+			}
+			return nil
+		}
+	}
+}
+
+func (r *remoteReplica) config() *Config { return r.cfg }
+
+// readWAL writes a tar archive of the agent's WAL directory to w.
+func (r *remoteReplica) readWAL(ctx context.Context, w io.Writer) error {
+	var stderr strings.Builder
+	if err := r.client.Run(ctx, fmt.Sprintf("tar -C '%s' -cf - .", r.walDir()), w, &stderr); err != nil {
+		return fmt.Errorf("replica host %s: read WAL: %w: %s", r.host, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// writeWAL unpacks the tar archive read from rd into the agent's WAL directory.
+func (r *remoteReplica) writeWAL(ctx context.Context, rd io.Reader) error {
+	var stderr strings.Builder
+	dir := r.walDir()
+	if err := r.client.RunInput(ctx, fmt.Sprintf("mkdir -p '%s' && tar -C '%s' -xf -", dir, dir), rd, io.Discard, &stderr); err != nil {
+		return fmt.Errorf("replica host %s: write WAL: %w: %s", r.host, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func (r *remoteReplica) walDir() string {
+	if r.cfg.ReplicaWALDir != "" {
+		return r.cfg.ReplicaWALDir
+	}
+	return r.cfg.ReplicaPGData + "/pg_wal"
+}
+
+// close hangs up; the agent stops its receiver and exits, or is killed when ctx ends first.
+func (r *remoteReplica) close(ctx context.Context) {
+	_ = r.in.Close()
+	select {
+	case <-r.done:
+		if r.err != nil {
+			slog.Warn("agent exit", "host", r.host, "err", r.err)
+		}
+	case <-ctx.Done():
+		slog.Warn("agent did not exit, killing it", "host", r.host)
+		r.kill()
+		<-r.done
+	}
+	r.removeDir(ctx)
+	_ = r.client.Close()
+}
+
+// removeDir deletes the uploaded binary.
+func (r *remoteReplica) removeDir(ctx context.Context) {
+	if r.dir == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	if err := r.client.Run(ctx, fmt.Sprintf("rm -rf '%s'", r.dir), nil, nil); err != nil {
+		slog.Warn("remove uploaded pgclone", "host", r.host, "dir", r.dir, "err", err)
+	}
+	r.dir = ""
+}
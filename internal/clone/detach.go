@@ -0,0 +1,138 @@
+package clone
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/vbp1/pgclone/internal/pgconf"
+	"github.com/vbp1/pgclone/internal/pgctl"
+	"github.com/vbp1/pgclone/internal/postgres"
+)
+
+// standbyOnly are settings a detached clone must not inherit from the primary's auto.conf.
+var standbyOnly = []string{"primary_conninfo", "primary_slot_name"}
+
+// recoveryTargets are all recovery target settings; PostgreSQL refuses more than one.
+var recoveryTargets = []string{"recovery_target", "recovery_target_lsn", "recovery_target_name",
+	"recovery_target_time", "recovery_target_xid"}
+
+// writeDetachConf turns the clone into an independent cluster: recovery.signal with a
+// recovery target (the end of the backup unless a time or LSN is given) and promotion there.
+func (o *Orchestrator) writeDetachConf() error {
+	if o.cfg.RecoveryTargetLSN != "" {
+		lsn, err := postgres.ParseLSN(o.cfg.RecoveryTargetLSN)
+		if err != nil {
+			return fmt.Errorf("recovery target LSN: %w", err)
+		}
+		if lsn < o.stopLSN {
+			return fmt.Errorf("recovery target LSN %s is before the backup end %s", lsn, o.stopLSN)
+		}
+	}
+	// copied from a standby source
+	if err := os.Remove(filepath.Join(o.cfg.ReplicaPGData, "standby.signal")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	signal := filepath.Join(o.cfg.ReplicaPGData, "recovery.signal")
+	if err := os.WriteFile(signal, nil, 0o600); err != nil {
+		return err
+	}
+
+	conf, err := pgconf.Load(filepath.Join(o.cfg.ReplicaPGData, pgconf.AutoConf))
+	if err != nil {
+		return err
+	}
+	for _, name := range standbyOnly {
+		if conf.Unset(name) {
+			slog.Info("detach: removed standby setting", "name", name)
+		}
+	}
+	// after promotion the copy would push its new timeline into the primary's WAL archive
+	if !o.hasConfRule("archive_mode") {
+		conf.Set("archive_mode", "off")
+		slog.Info("detach: disabled archiving", "name", "archive_mode")
+	}
+	for _, name := range recoveryTargets {
+		conf.Unset(name)
+	}
+	switch {
+	case o.cfg.RecoveryTargetLSN != "":
+		conf.Set("recovery_target_lsn", o.cfg.RecoveryTargetLSN)
+	case o.cfg.RecoveryTargetTime != "":
+		conf.Set("recovery_target_time", o.cfg.RecoveryTargetTime)
+	default:
+		conf.Set("recovery_target", "immediate")
+	}
+	conf.Set("recovery_target_action", "promote")
+	if err := conf.Save(); err != nil {
+		return err
+	}
+	slog.Info("detach configuration written", "file", conf.Path, "signal", signal)
+	return nil
+}
+
+// hasConfRule reports whether a --set/--unset rule names setting name.
+func (o *Orchestrator) hasConfRule(name string) bool {
+	for _, r := range o.cfg.ConfRules {
+		if r.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// waitPromoted waits until the started detached clone reached its recovery target and
+// left recovery; the server stopping on the way (target not reached) fails the run.
+func (o *Orchestrator) waitPromoted(ctx context.Context) error {
+	deadline := time.Now().Add(o.cfg.StartTimeout)
+	var lastErr error
+	for {
+		pf, err := pgctl.ReadPidFile(o.cfg.ReplicaPGData)
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w: clone stopped during recovery, see %s", ErrReplicaUnhealthy, replicaCtl(o.cfg).Log())
+		}
+		if err == nil {
+			var inRecovery bool
+			if inRecovery, lastErr = localInRecovery(ctx, pf); lastErr == nil && !inRecovery {
+				slog.Info("detached clone promoted", "port", pf.Port)
+				fmt.Printf("Detached clone promoted: pid %d, port %d\n", pf.PID, pf.Port)
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			if lastErr != nil {
+				return fmt.Errorf("%w: not promoted within %s: %v", ErrReplicaUnhealthy, o.cfg.StartTimeout, lastErr)
+			}
+			return fmt.Errorf("%w: still in recovery after %s", ErrReplicaUnhealthy, o.cfg.StartTimeout)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(replicaPollInterval):
+		}
+	}
+}
+
+// localInRecovery asks the local server described by pf whether it is in recovery.
+func localInRecovery(ctx context.Context, pf pgctl.PidFile) (bool, error) {
+	host := pf.SocketDir
+	if host == "" {
+		host = pf.ListenAddr
+	}
+	if host == "" || host == "*" {
+		host = "localhost"
+	}
+	conn, err := connectDB(ctx, postgres.Conninfo{"host": host, "port": fmt.Sprint(pf.Port),
+		"sslmode": "prefer", "application_name": "pgclone"}.String(), "postgres")
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = conn.Close(context.WithoutCancel(ctx)) }()
+	var inRecovery bool
+	err = conn.QueryRow(ctx, "SELECT pg_is_in_recovery()").Scan(&inRecovery)
+	return inRecovery, err
+}
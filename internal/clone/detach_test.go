@@ -0,0 +1,71 @@
+package clone
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vbp1/pgclone/internal/pgconf"
+)
+
+func TestWriteDetachConf(t *testing.T) {
+	dir := t.TempDir()
+	auto := "primary_conninfo = 'host=upstream'\nprimary_slot_name = 'up'\nrecovery_target_xid = '1234'\nwork_mem = '64MB'\narchive_mode = 'on'\n"
+	if err := os.WriteFile(filepath.Join(dir, pgconf.AutoConf), []byte(auto), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_ = os.WriteFile(filepath.Join(dir, "standby.signal"), nil, 0o600)
+
+	o := &Orchestrator{cfg: &Config{ReplicaPGData: dir, Detach: true}, stopLSN: 0x3000000}
+	if err := o.writeDetachConf(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "recovery.signal")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "standby.signal")); !os.IsNotExist(err) {
+		t.Fatalf("standby.signal left behind: %v", err)
+	}
+	conf, err := pgconf.Load(filepath.Join(dir, pgconf.AutoConf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"work_mem": "64MB", "archive_mode": "off", "recovery_target": "immediate", "recovery_target_action": "promote"}
+	got := conf.Settings()
+	if len(got) != len(want) {
+		t.Fatalf("settings %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Fatalf("%s = %q, want %q", k, got[k], v)
+		}
+	}
+
+	o.cfg.RecoveryTargetLSN = "0/2000000"
+	if err := o.writeDetachConf(); err == nil {
+		t.Fatal("target before the backup end accepted")
+	}
+	o.cfg.RecoveryTargetLSN = "0/4000000"
+	if err := o.writeDetachConf(); err != nil {
+		t.Fatal(err)
+	}
+	conf, _ = pgconf.Load(filepath.Join(dir, pgconf.AutoConf))
+	if _, ok := conf.Get("recovery_target"); ok {
+		t.Fatal("recovery_target kept next to recovery_target_lsn")
+	}
+	if v, _ := conf.Get("recovery_target_lsn"); v != "0/4000000" {
+		t.Fatalf("recovery_target_lsn = %q", v)
+	}
+
+	// --set archive_mode=on is applied afterwards and wins; the value is left alone
+	conf.Set("archive_mode", "on")
+	_ = conf.Save()
+	o.cfg.ConfRules = []pgconf.Rule{{Name: "archive_mode", Value: "on"}}
+	if err := o.writeDetachConf(); err != nil {
+		t.Fatal(err)
+	}
+	conf, _ = pgconf.Load(filepath.Join(dir, pgconf.AutoConf))
+	if v, _ := conf.Get("archive_mode"); v != "on" {
+		t.Fatalf("archive_mode = %q with a --set rule", v)
+	}
+}
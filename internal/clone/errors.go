@@ -0,0 +1,23 @@
+package clone
+
+import "errors"
+
+// Error categories a clone is aborted with; test with errors.Is.
+var (
+	// ErrWALStreamLost: the WAL receiver died, the backup would miss WAL.
+	ErrWALStreamLost = errors.New("WAL streaming lost")
+	// ErrSlotInvalidated: the replication slot can no longer guarantee WAL retention.
+	ErrSlotInvalidated = errors.New("replication slot invalidated")
+	// ErrPrimaryRestarted: the primary postmaster restarted, the running backup is void.
+	ErrPrimaryRestarted = errors.New("primary restarted")
+	// ErrPrimaryChanged: failover or a different cluster behind the same address.
+	ErrPrimaryChanged = errors.New("primary changed")
+	// ErrControlConnLost: the control session running pg_backup_start/stop broke.
+	ErrControlConnLost = errors.New("control connection lost")
+	// ErrBackupAborted: the control session died between pg_backup_start and pg_backup_stop.
+	ErrBackupAborted = errors.New("backup aborted on the primary")
+	// ErrReplicaUnhealthy: the copy finished but the started replica did not come up or stream.
+	ErrReplicaUnhealthy = errors.New("replica unhealthy")
+	// ErrValidateBoot: the throwaway boot of the clone failed to reach consistency or a check failed.
+	ErrValidateBoot = errors.New("validation boot failed")
+)
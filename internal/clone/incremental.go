@@ -0,0 +1,491 @@
+package clone
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/vbp1/pgclone/internal/localcopy"
+	"github.com/vbp1/pgclone/internal/postgres"
+	"github.com/vbp1/pgclone/internal/progress"
+	"github.com/vbp1/pgclone/internal/rsync"
+)
+
+// incrementalWait bounds waiting for the WAL summarizer to reach the backup start LSN.
+const incrementalWait = 2 * time.Minute
+
+// incrementalChunk is the maximum number of contiguous blocks fetched per query.
+const incrementalChunk = 128
+
+// errIncrementalUnavailable: the replica cannot be refreshed from WAL summaries, copy everything.
+var errIncrementalUnavailable = errors.New("incremental refresh unavailable")
+
+// Tablespace OIDs with fixed locations.
+const (
+	defaultTablespace = 1663 // base/
+	globalTablespace  = 1664 // global/
+)
+
+var forkSuffix = map[int16]string{0: "", 1: "_fsm", 2: "_vm", 3: "_init"}
+
+// relFork identifies one fork of a relation file.
+type relFork struct {
+	spc, db, rel uint32
+	fork         int16
+}
+
+// relPath returns the path of segment seg of f relative to a data directory;
+// spcDir is the version directory inside tablespaces (PG_17_<catversion>).
+func relPath(f relFork, seg uint32, spcDir string) string {
+	name := strconv.FormatUint(uint64(f.rel), 10) + forkSuffix[f.fork]
+	if seg > 0 {
+		name += "." + strconv.FormatUint(uint64(seg), 10)
+	}
+	switch f.spc {
+	case globalTablespace:
+		return filepath.Join("global", name)
+	case defaultTablespace:
+		return filepath.Join("base", strconv.FormatUint(uint64(f.db), 10), name)
+	}
+	return filepath.Join("pg_tblspc", strconv.FormatUint(uint64(f.spc), 10), spcDir, strconv.FormatUint(uint64(f.db), 10), name)
+}
+
+// dbDir identifies the directory of one database in a tablespace.
+type dbDir struct{ spc, db uint32 }
+
+// relFileName matches relation segment files: relfilenode, fork suffix, segment number.
+var relFileName = regexp.MustCompile(`^[0-9]+(_fsm|_vm|_init)?(\.[0-9]+)?$`)
+
+// changeSet collects modified blocks and truncation limits per relation fork.
+type changeSet struct {
+	blocks map[relFork]map[uint32]struct{}
+	limits map[relFork]uint32
+	dbs    map[dbDir]struct{} // whole databases created or dropped (limit entries of relfilenode 0)
+}
+
+func newChangeSet() *changeSet {
+	return &changeSet{blocks: map[relFork]map[uint32]struct{}{}, limits: map[relFork]uint32{}, dbs: map[dbDir]struct{}{}}
+}
+
+func (c *changeSet) add(ref postgres.BlockRef) {
+	if ref.RelFileNode == 0 {
+		// CREATE DATABASE ... STRATEGY FILE_COPY, DROP DATABASE: no block references follow
+		c.dbs[dbDir{ref.Tablespace, ref.Database}] = struct{}{}
+		return
+	}
+	f := relFork{ref.Tablespace, ref.Database, ref.RelFileNode, ref.Fork}
+	if ref.Limit {
+		if l, ok := c.limits[f]; !ok || ref.Block < l {
+			c.limits[f] = ref.Block
+		}
+		return
+	}
+	if c.blocks[f] == nil {
+		c.blocks[f] = map[uint32]struct{}{}
+	}
+	c.blocks[f][ref.Block] = struct{}{}
+}
+
+// forks returns every fork with changes in a stable order.
+func (c *changeSet) forks() []relFork {
+	seen := map[relFork]bool{}
+	var out []relFork
+	for f := range c.blocks {
+		seen[f] = true
+		out = append(out, f)
+	}
+	for f := range c.limits {
+		if !seen[f] {
+			out = append(out, f)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.spc != b.spc {
+			return a.spc < b.spc
+		}
+		if a.db != b.db {
+			return a.db < b.db
+		}
+		if a.rel != b.rel {
+			return a.rel < b.rel
+		}
+		return a.fork < b.fork
+	})
+	return out
+}
+
+// blockRanges turns a block set into sorted [start, end) runs that neither cross a
+// segment boundary (perSeg blocks) nor exceed max blocks.
+func blockRanges(blocks map[uint32]struct{}, perSeg, max uint32) [][2]uint32 {
+	sorted := make([]uint32, 0, len(blocks))
+	for b := range blocks {
+		sorted = append(sorted, b)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var out [][2]uint32
+	for _, b := range sorted {
+		if n := len(out); n > 0 {
+			last := &out[n-1]
+			if last[1] == b && b%perSeg != 0 && last[1]-last[0] < max {
+				last[1]++
+				continue
+			}
+		}
+		out = append(out, [2]uint32{b, b + 1})
+	}
+	return out
+}
+
+// coverSummaries returns the summaries on tli that together cover [from, to) without gaps.
+func coverSummaries(all []postgres.WALSummary, tli uint32, from, to postgres.LSN) ([]postgres.WALSummary, error) {
+	var use []postgres.WALSummary
+	for _, s := range all {
+		if s.Timeline == tli && s.End > from && s.Start < to {
+			use = append(use, s)
+		}
+	}
+	sort.Slice(use, func(i, j int) bool { return use[i].Start < use[j].Start })
+	pos := from
+	for _, s := range use {
+		if s.Start > pos {
+			break
+		}
+		pos = max(pos, s.End)
+	}
+	if pos < to {
+		return nil, fmt.Errorf("%w: WAL summaries on timeline %d cover only up to %s of %s..%s", errIncrementalUnavailable, tli, pos, from, to)
+	}
+	return use, nil
+}
+
+// incrementalBase returns the REDO LSN of the replica's last checkpoint, or the start of
+// its copy if that is earlier and backup_label is still there, from where WAL summaries
+// tell which blocks changed; the replica must be a stopped copy of this cluster.
+func (o *Orchestrator) incrementalBase() (postgres.LSN, error) {
+	if v := o.backup.Version(); v < 170000 {
+		return 0, fmt.Errorf("%w: WAL summaries need PostgreSQL 17+, server reports %d", errIncrementalUnavailable, v)
+	}
+	if _, err := os.Stat(filepath.Join(o.cfg.ReplicaPGData, "postmaster.pid")); err == nil {
+		return 0, fmt.Errorf("replica %s is running (postmaster.pid exists); stop it before an incremental refresh", o.cfg.ReplicaPGData)
+	}
+	ctrl, err := postgres.ReadControlFile(o.cfg.ReplicaPGData)
+	if err != nil {
+		return 0, fmt.Errorf("%w: replica pg_control: %v", errIncrementalUnavailable, err)
+	}
+	switch {
+	case ctrl.SystemID != o.systemID:
+		return 0, fmt.Errorf("%w: replica system identifier %d, primary %d", errIncrementalUnavailable, ctrl.SystemID, o.systemID)
+	case ctrl.Timeline != o.timeline:
+		return 0, fmt.Errorf("%w: replica checkpoint on timeline %d, primary on %d", errIncrementalUnavailable, ctrl.Timeline, o.timeline)
+	case ctrl.Redo > o.startLSN:
+		return 0, fmt.Errorf("%w: replica checkpoint %s is ahead of the backup start %s", errIncrementalUnavailable, ctrl.Redo, o.startLSN)
+	}
+	// a clone that never started: its pg_control is from the end of that copy, blocks
+	// copied before a checkpoint in between may be stale back to the copy's start
+	since := ctrl.Redo
+	label, err := postgres.ReadBackupLabel(o.cfg.ReplicaPGData)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return 0, fmt.Errorf("%w: replica %v", errIncrementalUnavailable, err)
+	case label.StartTimeline != o.timeline:
+		return 0, fmt.Errorf("%w: replica backup_label on timeline %d, primary on %d", errIncrementalUnavailable, label.StartTimeline, o.timeline)
+	case label.StartWAL < since:
+		since = label.StartWAL
+	}
+	return since, nil
+}
+
+// refreshIncremental brings relation files of the replica up to date by fetching only the
+// blocks the primary's WAL summaries list as modified since the replica's checkpoint at since.
+// WAL replay from the backup start fixes blocks changed while they are read.
+func (o *Orchestrator) refreshIncremental(ctx context.Context, since postgres.LSN) error {
+	var enabled bool
+	if err := o.conn.QueryRow(ctx, `SELECT current_setting('summarize_wal')::bool`).Scan(&enabled); err != nil {
+		return fmt.Errorf("query summarize_wal: %w", err)
+	}
+	if !enabled {
+		return fmt.Errorf("%w: summarize_wal is off on the primary", errIncrementalUnavailable)
+	}
+	if err := o.waitSummarized(ctx, o.startLSN); err != nil {
+		return err
+	}
+
+	var summaries []postgres.WALSummary
+	changes := newChangeSet()
+	err := o.conn.Do(func(conn *pgx.Conn) error {
+		all, err := postgres.WALSummaries(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if summaries, err = coverSummaries(all, o.timeline, since, o.startLSN); err != nil {
+			return err
+		}
+		for _, s := range summaries {
+			if err := postgres.WALSummaryContents(ctx, conn, s, func(ref postgres.BlockRef) error {
+				changes.add(ref)
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var spcDir string
+	var blockSize, perSeg int64
+	if err := o.conn.QueryRow(ctx, `SELECT 'PG_' || current_setting('server_version_num')::int / 10000 || '_' || catalog_version_no,
+                                            current_setting('block_size')::bigint,
+                                            pg_size_bytes(current_setting('segment_size')) / current_setting('block_size')::bigint
+                                       FROM pg_control_system()`).Scan(&spcDir, &blockSize, &perSeg); err != nil {
+		return fmt.Errorf("query relation layout: %w", err)
+	}
+
+	start := time.Now()
+	var fetched, bytes int64
+	forks := changes.forks()
+	for _, f := range forks {
+		if limit, ok := changes.limits[f]; ok {
+			if err := truncateFork(o.cfg.ReplicaPGData, f, limit, uint32(perSeg), blockSize, spcDir); err != nil {
+				return err
+			}
+		}
+		for _, r := range blockRanges(changes.blocks[f], uint32(perSeg), incrementalChunk) {
+			seg := r[0] / uint32(perSeg)
+			rel := relPath(f, seg, spcDir)
+			off := int64(r[0]%uint32(perSeg)) * blockSize
+			data, ok, err := postgres.ReadBinaryFile(ctx, o.conn, rel, off, int64(r[1]-r[0])*blockSize)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue // dropped after the summary; replay removes it
+			}
+			if err := writeAt(filepath.Join(o.cfg.ReplicaPGData, rel), data, off); err != nil {
+				return err
+			}
+			fetched += int64(len(data)) / blockSize
+			bytes += int64(len(data))
+		}
+	}
+	st, err := o.syncNonRelation(ctx, changes.dbs, spcDir)
+	if err != nil {
+		return err
+	}
+	slog.Info("incremental refresh done", "since", since, "summaries", len(summaries), "relations", len(forks),
+		"blocks", fetched, "files", st.NumFiles, "removed", st.DeletedFiles, "elapsed_sec", time.Since(start).Seconds())
+	fmt.Printf("Incremental refresh: %d relation forks, %d blocks (%s) fetched, %d other files (%s) copied, %d removed in %s\n",
+		len(forks), fetched, progress.FormatBytes(bytes), st.NumFiles, progress.FormatBytes(st.TotalTransferredSize),
+		st.DeletedFiles, time.Since(start).Round(time.Second))
+	return nil
+}
+
+// incrementalDir is a directory holding database directories: base/ or a tablespace.
+type incrementalDir struct {
+	module   string // rsyncd module
+	spc      uint32
+	src, dst string // src: primary directory (local mode)
+	prefix   string // path of the database directories inside it: "" or PG_17_<catversion>/
+}
+
+// syncNonRelation copies what WAL summaries do not cover: files that are not relation
+// segments (pg_filenode.map, PG_VERSION, ...) and all files of the databases in full or
+// missing on the replica. Files and databases the primary no longer has are removed.
+func (o *Orchestrator) syncNonRelation(ctx context.Context, full map[dbDir]struct{}, spcDir string) (rsync.Stats, error) {
+	dirs := []incrementalDir{{module: "base", spc: defaultTablespace,
+		src: filepath.Join(o.cfg.PrimaryPGData, "base"), dst: filepath.Join(o.cfg.ReplicaPGData, "base")}}
+	for _, t := range o.tablespaces {
+		dirs = append(dirs, incrementalDir{module: fmt.Sprintf("spc_%d", t.Oid), spc: t.Oid,
+			src: t.Location, dst: o.tablespaceDir(t), prefix: spcDir + "/"})
+	}
+	var total rsync.Stats
+	for _, d := range dirs {
+		var primary []rsync.FileInfo
+		var err error
+		if o.local {
+			primary, err = localcopy.List(d.src, localExcludes)
+		} else {
+			primary, err = listModuleFiles(ctx, *o.rsyncCfg, d.module)
+		}
+		if err != nil {
+			return total, err
+		}
+		replica, err := localcopy.List(d.dst, localExcludes)
+		if err != nil && !os.IsNotExist(err) {
+			return total, err
+		}
+		fetch, remove := planNonRelation(primary, replica, d.spc, d.prefix, full)
+		for _, rel := range remove {
+			slog.Debug("incremental: removing", "path", filepath.Join(d.dst, rel))
+			if err := os.RemoveAll(filepath.Join(d.dst, rel)); err != nil {
+				return total, err
+			}
+		}
+		total.DeletedFiles += int64(len(remove))
+		if len(fetch) == 0 {
+			continue
+		}
+		var st rsync.Stats
+		if o.local {
+			st, err = localcopy.RunParallel(ctx, d.module, d.src, d.dst, o.cfg.Parallel, fetch, o.showBar(), o.cfg.Progress, o.cfg.ProgressInt)
+		} else {
+			st, err = rsync.RunParallel(ctx, *o.rsyncCfg, d.module, o.cfg.Parallel, fetch, d.dst, o.showBar(), o.cfg.Progress, o.cfg.ProgressInt)
+		}
+		if err != nil {
+			return total, err
+		}
+		total = total.Add(st)
+	}
+	return total, nil
+}
+
+// planNonRelation compares the file lists (relative to a base/ or tablespace directory) of
+// primary and replica. fetch: non-relation files, files missing on the replica and every
+// file of a database in full or not on the replica yet; remove: replica files the primary
+// does not have, whole database directories where the database is gone.
+func planNonRelation(primary, replica []rsync.FileInfo, spc uint32, prefix string, full map[dbDir]struct{}) (fetch []rsync.FileInfo, remove []string) {
+	onPrimary := map[string]bool{}
+	primaryDBs := map[string]bool{}
+	for _, f := range primary {
+		if skipPath(f.Path) {
+			continue
+		}
+		onPrimary[f.Path] = true
+		if db, ok := dbOf(f.Path, prefix); ok {
+			primaryDBs[db] = true
+		}
+	}
+	onReplica := map[string]bool{}
+	replicaDBs := map[string]bool{}
+	goneDBs := map[string]bool{}
+	for _, f := range replica {
+		onReplica[f.Path] = true
+		db, ok := dbOf(f.Path, prefix)
+		if ok {
+			replicaDBs[db] = true
+		}
+		switch {
+		case ok && !primaryDBs[db]:
+			if !goneDBs[db] {
+				goneDBs[db] = true
+				remove = append(remove, prefix+db)
+			}
+		case !onPrimary[f.Path]:
+			remove = append(remove, f.Path)
+		}
+	}
+	for _, f := range primary {
+		if skipPath(f.Path) {
+			continue
+		}
+		db, ok := dbOf(f.Path, prefix)
+		whole := false
+		if ok {
+			oid, _ := strconv.ParseUint(db, 10, 32)
+			_, whole = full[dbDir{spc, uint32(oid)}]
+			whole = whole || !replicaDBs[db]
+		}
+		if whole || !onReplica[f.Path] || !relFileName.MatchString(filepath.Base(f.Path)) {
+			fetch = append(fetch, f)
+		}
+	}
+	sort.Strings(remove)
+	return fetch, remove
+}
+
+// dbOf returns the database OID directory of a file path below prefix.
+func dbOf(path, prefix string) (string, bool) {
+	rest, ok := strings.CutPrefix(path, prefix)
+	if !ok {
+		return "", false
+	}
+	db, _, ok := strings.Cut(rest, "/")
+	if !ok {
+		return "", false
+	}
+	if _, err := strconv.ParseUint(db, 10, 32); err != nil {
+		return "", false
+	}
+	return db, true
+}
+
+// skipPath reports whether a listed path lies in something the copy excludes (pgsql_tmp, ...).
+func skipPath(path string) bool {
+	parts := strings.Split(path, "/")
+	for i := range parts {
+		if localcopy.Excluded(strings.Join(parts[:i+1], "/"), i < len(parts)-1, localExcludes) {
+			return true
+		}
+	}
+	return false
+}
+
+// waitSummarized waits until the WAL summarizer has processed WAL up to lsn.
+func (o *Orchestrator) waitSummarized(ctx context.Context, lsn postgres.LSN) error {
+	deadline := time.Now().Add(incrementalWait)
+	for {
+		got, err := postgres.SummarizedLSN(ctx, o.conn)
+		if err != nil {
+			return err
+		}
+		if got >= lsn {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%w: WAL summarizer at %s, backup started at %s", errIncrementalUnavailable, got, lsn)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// truncateFork shortens the local copy of fork f to limit blocks, removing later segments.
+func truncateFork(pgdata string, f relFork, limit, perSeg uint32, blockSize int64, spcDir string) error {
+	seg := limit / perSeg
+	path := filepath.Join(pgdata, relPath(f, seg, spcDir))
+	if err := os.Truncate(path, int64(limit%perSeg)*blockSize); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for s := seg + 1; ; s++ {
+		err := os.Remove(filepath.Join(pgdata, relPath(f, s, spcDir)))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// writeAt writes data into path at off, creating the file and its directory if needed.
+func writeAt(path string, data []byte, off int64) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(data, off); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
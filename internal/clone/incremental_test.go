@@ -0,0 +1,228 @@
+package clone
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/vbp1/pgclone/internal/localcopy"
+	"github.com/vbp1/pgclone/internal/postgres"
+)
+
+func TestRelPath(t *testing.T) {
+	cases := []struct {
+		f    relFork
+		seg  uint32
+		want string
+	}{
+		{relFork{defaultTablespace, 5, 16384, 0}, 0, "base/5/16384"},
+		{relFork{defaultTablespace, 5, 16384, 1}, 0, "base/5/16384_fsm"},
+		{relFork{defaultTablespace, 5, 16384, 0}, 3, "base/5/16384.3"},
+		{relFork{globalTablespace, 0, 1262, 2}, 0, "global/1262_vm"},
+		{relFork{16500, 16384, 16600, 0}, 1, "pg_tblspc/16500/PG_17_202406281/16384/16600.1"},
+	}
+	for _, c := range cases {
+		if got := relPath(c.f, c.seg, "PG_17_202406281"); got != c.want {
+			t.Fatalf("%+v seg %d: got %s, want %s", c.f, c.seg, got, c.want)
+		}
+	}
+}
+
+func TestChangeSet(t *testing.T) {
+	c := newChangeSet()
+	for _, ref := range []postgres.BlockRef{
+		{Tablespace: 1663, Database: 5, RelFileNode: 2, Block: 7},
+		{Tablespace: 1663, Database: 5, RelFileNode: 2, Block: 7},
+		{Tablespace: 1663, Database: 5, RelFileNode: 2, Block: 10, Limit: true},
+		{Tablespace: 1663, Database: 5, RelFileNode: 2, Block: 4, Limit: true},
+		{Tablespace: 1663, Database: 5, RelFileNode: 1, Block: 0, Limit: true},
+	} {
+		c.add(ref)
+	}
+	f1, f2 := relFork{1663, 5, 1, 0}, relFork{1663, 5, 2, 0}
+	if got := c.forks(); !reflect.DeepEqual(got, []relFork{f1, f2}) {
+		t.Fatalf("forks %v", got)
+	}
+	if c.limits[f2] != 4 || c.limits[f1] != 0 || len(c.blocks[f2]) != 1 {
+		t.Fatalf("unexpected change set %+v", c)
+	}
+}
+
+func TestBlockRanges(t *testing.T) {
+	blocks := map[uint32]struct{}{}
+	for _, b := range []uint32{1, 2, 3, 4, 5, 9, 15, 16, 17} {
+		blocks[b] = struct{}{}
+	}
+	// segments of 16 blocks, runs of at most 3
+	got := blockRanges(blocks, 16, 3)
+	want := [][2]uint32{{1, 4}, {4, 6}, {9, 10}, {15, 16}, {16, 18}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestCoverSummaries(t *testing.T) {
+	all := []postgres.WALSummary{
+		{Timeline: 1, Start: 0, End: 500},
+		{Timeline: 2, Start: 500, End: 800},
+		{Timeline: 2, Start: 100, End: 500},
+		{Timeline: 2, Start: 900, End: 1000},
+	}
+	use, err := coverSummaries(all, 2, 200, 700)
+	if err != nil || len(use) != 2 || use[0].Start != 100 {
+		t.Fatalf("got %v, %v", use, err)
+	}
+	if _, err := coverSummaries(all, 2, 200, 950); !errors.Is(err, errIncrementalUnavailable) {
+		t.Fatalf("gap not detected: %v", err)
+	}
+	if _, err := coverSummaries(all, 2, 50, 300); !errors.Is(err, errIncrementalUnavailable) {
+		t.Fatalf("missing start not detected: %v", err)
+	}
+}
+
+func TestTruncateFork(t *testing.T) {
+	dir := t.TempDir()
+	f := relFork{defaultTablespace, 5, 16384, 0}
+	for seg, size := range []int{16 * 8, 16 * 8, 5 * 8} {
+		if err := writeAt(filepath.Join(dir, relPath(f, uint32(seg), "")), make([]byte, size), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 16 blocks of 8 bytes per segment; keep 20 blocks
+	if err := truncateFork(dir, f, 20, 16, 8, ""); err != nil {
+		t.Fatal(err)
+	}
+	st, err := os.Stat(filepath.Join(dir, "base/5/16384.1"))
+	if err != nil || st.Size() != 4*8 {
+		t.Fatalf("segment 1: %v, %v", st, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "base/5/16384.2")); !os.IsNotExist(err) {
+		t.Fatalf("segment 2 not removed: %v", err)
+	}
+}
+
+func TestChangeSetDatabaseEntries(t *testing.T) {
+	c := newChangeSet()
+	// CREATE DATABASE ... STRATEGY FILE_COPY: a limit entry of relfilenode 0
+	c.add(postgres.BlockRef{Tablespace: 1663, Database: 16400, RelFileNode: 0, Block: 0, Limit: true})
+	if len(c.forks()) != 0 {
+		t.Fatalf("database entry taken for a relation: %v", c.forks())
+	}
+	if _, ok := c.dbs[dbDir{1663, 16400}]; !ok {
+		t.Fatalf("database not marked for a full copy: %v", c.dbs)
+	}
+}
+
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		if err := writeAt(filepath.Join(dir, name), []byte(data), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// The replica is a copy from before the checkpoint: since then a database was created
+// (FILE_COPY), another dropped, pg_class rewritten (VACUUM FULL maps it to a new
+// relfilenode) and a table dropped. Relation files the replica has are left to the
+// summary.
+func TestSyncNonRelation(t *testing.T) {
+	primary, replica := t.TempDir(), t.TempDir()
+	writeTree(t, filepath.Join(primary, "base"), map[string]string{
+		"1/PG_VERSION":          "17\n",
+		"1/pg_filenode.map":     "new map",
+		"1/16390":               "new pg_class",
+		"1/1259":                "old pg_class",
+		"1/16395_init":          "init fork",
+		"16400/PG_VERSION":      "17\n",
+		"16400/pg_filenode.map": "db map",
+		"16400/16401":           "table",
+		"16400/16401_vm":        "vm",
+		"1/pgsql_tmp/tmp1":      "temp",
+	})
+	writeTree(t, filepath.Join(replica, "base"), map[string]string{
+		"1/PG_VERSION":      "17\n",
+		"1/pg_filenode.map": "old map",
+		"1/1259":            "old pg_class",
+		"1/16390":           "summary blocks",
+		"1/16999":           "", // dropped table, truncated to 0 blocks from the summary
+		"5/PG_VERSION":      "17\n",
+		"5/16500":           "dropped database",
+	})
+	o := &Orchestrator{cfg: &Config{PrimaryPGData: primary, ReplicaPGData: replica, Progress: "none", Parallel: 2}, local: true}
+	full := map[dbDir]struct{}{{defaultTablespace, 16400}: {}}
+	st, err := o.syncNonRelation(context.Background(), full, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"1/PG_VERSION":          "17\n",
+		"1/pg_filenode.map":     "new map",
+		"1/1259":                "old pg_class",
+		"1/16390":               "summary blocks",
+		"1/16395_init":          "init fork",
+		"16400/PG_VERSION":      "17\n",
+		"16400/pg_filenode.map": "db map",
+		"16400/16401":           "table",
+		"16400/16401_vm":        "vm",
+	}
+	got, err := localcopy.List(filepath.Join(replica, "base"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("replica files %v, want %v", got, want)
+	}
+	for name, data := range want {
+		b, err := os.ReadFile(filepath.Join(replica, "base", name))
+		if err != nil || string(b) != data {
+			t.Fatalf("%s: %q, %v", name, b, err)
+		}
+	}
+	if st.DeletedFiles != 2 || st.NumFiles != 7 {
+		t.Fatalf("stats %+v", st)
+	}
+}
+
+// writeControl writes a global/pg_control with the fields ReadControlFile parses.
+func writeControl(t *testing.T, pgdata string, systemID uint64, redo postgres.LSN, tli uint32) {
+	t.Helper()
+	ctrl := make([]byte, 296)
+	le := binary.LittleEndian
+	le.PutUint64(ctrl[0:], systemID)
+	le.PutUint32(ctrl[8:], 1700)
+	le.PutUint64(ctrl[32:], uint64(redo)+0x38)
+	le.PutUint64(ctrl[40:], uint64(redo))
+	le.PutUint32(ctrl[48:], tli)
+	if err := writeAt(filepath.Join(pgdata, "global", "pg_control"), ctrl, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A clone that was never started: a checkpoint ran during its copy, so pg_control's REDO is
+// later than the START WAL LOCATION in backup_label.
+func TestIncrementalBaseBackupLabel(t *testing.T) {
+	dir := t.TempDir()
+	writeControl(t, dir, 7354221346001234567, 0x6000028, 1)
+	api, err := postgres.NewBackupAPI(170000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := &Orchestrator{cfg: &Config{ReplicaPGData: dir}, backup: api, systemID: 7354221346001234567, timeline: 1, startLSN: 0x9000028}
+
+	if since, err := o.incrementalBase(); err != nil || since != 0x6000028 {
+		t.Fatalf("without backup_label: %s, %v", since, err)
+	}
+	label := "START WAL LOCATION: 0/5000028 (file 000000010000000000000005)\nCHECKPOINT LOCATION: 0/5000060\nSTART TIMELINE: 1\n"
+	if err := os.WriteFile(filepath.Join(dir, "backup_label"), []byte(label), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if since, err := o.incrementalBase(); err != nil || since != 0x5000028 {
+		t.Fatalf("with backup_label: %s, %v", since, err)
+	}
+}
@@ -0,0 +1,204 @@
+package clone
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/vbp1/pgclone/internal/localcopy"
+	"github.com/vbp1/pgclone/internal/postgres"
+	"github.com/vbp1/pgclone/internal/rsync"
+	"github.com/vbp1/pgclone/internal/util/fs"
+)
+
+// localExcludes are skipped inside base/ and tablespaces, as rsync.Config.BuildCmd does.
+var localExcludes = []string{"pgsql_tmp*", "pg_internal.init"}
+
+// isLocalHost reports whether host is a unix socket directory or a loopback address.
+func isLocalHost(host string) bool {
+	if filepath.IsAbs(host) || host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// nested reports whether a and b are the same directory or one contains the other.
+func nested(a, b string) bool {
+	a, b = filepath.Clean(a), filepath.Clean(b)
+	return a == b || strings.HasPrefix(b, a+string(filepath.Separator)) || strings.HasPrefix(a, b+string(filepath.Separator))
+}
+
+// checkLocal decides whether the rsync method can copy directly on this host: the primary
+// is reached through a socket or loopback and its data directory is readable here and holds
+// the same cluster (a loopback address may be an SSH tunnel to another host).
+func (o *Orchestrator) checkLocal() error {
+	socket := filepath.IsAbs(o.primaryHost)
+	if o.cfg.DisableLocal || !isLocalHost(o.primaryHost) {
+		if socket {
+			return fmt.Errorf("primary host %q is a socket directory; --method %s without local copy needs a network host", o.primaryHost, MethodRsync)
+		}
+		return nil
+	}
+	if _, err := os.ReadFile(filepath.Join(o.cfg.PrimaryPGData, "PG_VERSION")); err != nil {
+		if socket {
+			return fmt.Errorf("primary reached through socket %s but its data directory is not readable: %w", o.primaryHost, err)
+		}
+		slog.Info("primary on loopback but its data directory is not readable here, using SSH + rsync", "err", err)
+		return nil
+	}
+	ctrl, err := postgres.ReadControlFile(o.cfg.PrimaryPGData)
+	if err == nil && ctrl.SystemID != o.systemID {
+		err = fmt.Errorf("%s holds system identifier %d, the primary reports %d", o.cfg.PrimaryPGData, ctrl.SystemID, o.systemID)
+	}
+	if err != nil {
+		if socket {
+			return fmt.Errorf("primary reached through socket %s: %w", o.primaryHost, err)
+		}
+		slog.Info("primary on loopback but its data directory here is not that cluster, using SSH + rsync", "err", err)
+		return nil
+	}
+	if err := o.checkOverlap(); err != nil {
+		return err
+	}
+	o.local = true
+	slog.Info("primary is local, copying directly without SSH/rsync", "pgdata", o.cfg.PrimaryPGData)
+	return nil
+}
+
+// checkOverlap refuses replica directories that are, contain or lie in the primary's own
+// PGDATA, pg_wal or tablespaces; the primary is on this host.
+func (o *Orchestrator) checkOverlap() error {
+	if o.cfg.PrimaryPGData != "" {
+		if nested(o.cfg.PrimaryPGData, o.cfg.ReplicaPGData) {
+			return fmt.Errorf("replica PGDATA %s overlaps the primary data directory %s", o.cfg.ReplicaPGData, o.cfg.PrimaryPGData)
+		}
+		if o.cfg.ReplicaWALDir != "" && nested(filepath.Join(o.cfg.PrimaryPGData, "pg_wal"), o.cfg.ReplicaWALDir) {
+			return fmt.Errorf("replica WAL directory %s overlaps the primary pg_wal", o.cfg.ReplicaWALDir)
+		}
+	}
+	for _, t := range o.tablespaces {
+		if dst := o.tablespaceDir(t); nested(t.Location, dst) {
+			return fmt.Errorf("tablespace %d at %s would be copied onto itself; map it with --tablespace-mapping %s=NEWDIR", t.Oid, t.Location, t.Location)
+		}
+	}
+	return nil
+}
+
+// emptyDir removes the contents of dir for a copy that starts from scratch; a non-empty
+// directory is only emptied with cfg.DropExisting.
+func (o *Orchestrator) emptyDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) == 0 {
+		return err
+	}
+	if !o.cfg.DropExisting {
+		return fmt.Errorf("%s is not empty; pass --drop-existing to remove its contents", dir)
+	}
+	return fs.CleanupDir(dir)
+}
+
+// tablespaceDir returns where tablespace t goes on the replica.
+func (o *Orchestrator) tablespaceDir(t postgres.Tablespace) string {
+	if dst, ok := o.cfg.TablespaceMapping[filepath.Clean(t.Location)]; ok {
+		return dst
+	}
+	return t.Location
+}
+
+// applyTablespaceMapping points pg_tblspc links and tablespace_map of the replica at the
+// mapped tablespace directories.
+func (o *Orchestrator) applyTablespaceMapping() error {
+	if len(o.cfg.TablespaceMapping) == 0 {
+		return nil
+	}
+	for _, t := range o.tablespaces {
+		dst := o.tablespaceDir(t)
+		if dst == t.Location {
+			continue
+		}
+		link := filepath.Join(o.cfg.ReplicaPGData, "pg_tblspc", fmt.Sprint(t.Oid))
+		if cur, err := os.Readlink(link); err == nil && cur == dst {
+			continue
+		}
+		if err := os.RemoveAll(link); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(link), 0o700); err != nil {
+			return err
+		}
+		if err := os.Symlink(dst, link); err != nil {
+			return err
+		}
+	}
+	path := filepath.Join(o.cfg.ReplicaPGData, "tablespace_map")
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, o.mapTablespaceFile(data), 0o600)
+}
+
+// mapTablespaceFile rewrites the "oid path" lines of a tablespace_map.
+func (o *Orchestrator) mapTablespaceFile(data []byte) []byte {
+	lines := strings.Split(string(data), "\n")
+	for i, l := range lines {
+		oid, path, ok := strings.Cut(l, " ")
+		if !ok {
+			continue
+		}
+		if dst, ok := o.cfg.TablespaceMapping[filepath.Clean(path)]; ok {
+			lines[i] = oid + " " + dst
+		}
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// copyLocal copies base/ and the tablespaces with parallel Go workers (local mode).
+func (o *Orchestrator) copyLocal(ctx context.Context) error {
+	start := time.Now()
+	showBar := o.showBar()
+	var total rsync.Stats
+	dirs := []struct{ name, src, dst string }{
+		{"base", filepath.Join(o.cfg.PrimaryPGData, "base"), filepath.Join(o.cfg.ReplicaPGData, "base")},
+	}
+	for _, t := range o.tablespaces {
+		dirs = append(dirs, struct{ name, src, dst string }{fmt.Sprintf("spc_%d", t.Oid), t.Location, o.tablespaceDir(t)})
+	}
+	for _, d := range dirs {
+		files, err := localcopy.List(d.src, localExcludes)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(d.dst, 0o700); err != nil {
+			return err
+		}
+		st, err := localcopy.RunParallel(ctx, d.name, d.src, d.dst, o.cfg.Parallel, files, showBar, o.cfg.Progress, o.cfg.ProgressInt)
+		if err != nil {
+			return err
+		}
+		slog.Info("local copy done", "module", d.name, "files", st.NumFiles, "bytes", st.TotalTransferredSize)
+		total = total.Add(st)
+	}
+	slog.Info("local copy aggregate stats", "elapsed_sec", time.Since(start).Seconds())
+	fmt.Println(total.Summary(time.Since(start)))
+	return nil
+}
+
+// fetchLocalWAL copies the named segments from the local primary pg_wal into dir.
+func (o *Orchestrator) fetchLocalWAL(dir string, names []string) error {
+	for _, name := range names {
+		if _, err := localcopy.CopyFile(filepath.Join(o.cfg.PrimaryPGData, "pg_wal", name), filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
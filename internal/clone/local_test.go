@@ -0,0 +1,134 @@
+package clone
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vbp1/pgclone/internal/postgres"
+)
+
+func TestIsLocalHost(t *testing.T) {
+	for host, want := range map[string]bool{
+		"/var/run/postgresql": true,
+		"localhost":           true,
+		"127.0.0.1":           true,
+		"::1":                 true,
+		"10.0.0.5":            false,
+		"db1.example.com":     false,
+	} {
+		if got := isLocalHost(host); got != want {
+			t.Errorf("isLocalHost(%q) = %v", host, got)
+		}
+	}
+}
+
+func TestCheckLocal(t *testing.T) {
+	primary := t.TempDir()
+	if err := os.WriteFile(filepath.Join(primary, "PG_VERSION"), []byte("17\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	writeControl(t, primary, 42, 0x3000028, 1)
+	spc := postgres.Tablespace{Oid: 16400, Location: "/srv/spc1"}
+
+	o := &Orchestrator{cfg: &Config{PrimaryPGData: primary, ReplicaPGData: filepath.Join(primary, "replica")}, primaryHost: "/tmp", systemID: 42}
+	if err := o.checkLocal(); err == nil || !strings.Contains(err.Error(), "overlaps") {
+		t.Fatalf("nested replica accepted: %v", err)
+	}
+
+	o = &Orchestrator{cfg: &Config{PrimaryPGData: primary, ReplicaPGData: t.TempDir()}, primaryHost: "127.0.0.1",
+		tablespaces: []postgres.Tablespace{spc}, systemID: 42}
+	if err := o.checkLocal(); err == nil || !strings.Contains(err.Error(), "--tablespace-mapping") {
+		t.Fatalf("unmapped tablespace accepted: %v", err)
+	}
+	o.cfg.TablespaceMapping = map[string]string{"/srv/spc1": "/srv/replica_spc1"}
+	if err := o.checkLocal(); err != nil || !o.local {
+		t.Fatalf("local = %v, err = %v", o.local, err)
+	}
+
+	// another cluster at that path, the primary behind an SSH tunnel
+	o.local, o.systemID = false, 43
+	if err := o.checkLocal(); err != nil || o.local {
+		t.Fatalf("other cluster on loopback: local = %v, err = %v", o.local, err)
+	}
+	o.primaryHost = "/var/run/postgresql"
+	if err := o.checkLocal(); err == nil || !strings.Contains(err.Error(), "system identifier") {
+		t.Fatalf("other cluster behind a socket: %v", err)
+	}
+
+	o = &Orchestrator{cfg: &Config{PrimaryPGData: "/nonexistent", ReplicaPGData: t.TempDir()}, primaryHost: "localhost"}
+	if err := o.checkLocal(); err != nil || o.local {
+		t.Fatalf("unreadable loopback primary: local = %v, err = %v", o.local, err)
+	}
+	o.primaryHost = "/var/run/postgresql"
+	if err := o.checkLocal(); err == nil {
+		t.Fatal("unreadable socket primary accepted")
+	}
+}
+
+func TestEmptyDir(t *testing.T) {
+	dir := t.TempDir()
+	o := &Orchestrator{cfg: &Config{}}
+	if err := o.emptyDir(dir); err != nil {
+		t.Fatalf("empty dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "PG_VERSION"), []byte("17\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.emptyDir(dir); err == nil || !strings.Contains(err.Error(), "--drop-existing") {
+		t.Fatalf("non-empty dir emptied without --drop-existing: %v", err)
+	}
+	o.cfg.DropExisting = true
+	if err := o.emptyDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("left %v", entries)
+	}
+}
+
+func TestStepBaseBackupOverlap(t *testing.T) {
+	primary := t.TempDir()
+	if err := os.WriteFile(filepath.Join(primary, "PG_VERSION"), []byte("17\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	o := &Orchestrator{cfg: &Config{PrimaryPGData: primary, ReplicaPGData: t.TempDir(), DropExisting: true}, primaryHost: "localhost",
+		tablespaces: []postgres.Tablespace{{Oid: 16400, Location: primary + "/spc"}}}
+	if err := o.stepBaseBackup(context.Background()); err == nil || !strings.Contains(err.Error(), "onto itself") {
+		t.Fatalf("primary tablespace as target accepted: %v", err)
+	}
+	o.tablespaces, o.cfg.ReplicaPGData = nil, primary
+	if err := o.stepBaseBackup(context.Background()); err == nil || !strings.Contains(err.Error(), "overlaps") {
+		t.Fatalf("primary PGDATA as target accepted: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(primary, "PG_VERSION")); err != nil {
+		t.Fatalf("primary touched: %v", err)
+	}
+}
+
+func TestApplyTablespaceMapping(t *testing.T) {
+	dir := t.TempDir()
+	link := filepath.Join(dir, "pg_tblspc", "16400")
+	_ = os.MkdirAll(filepath.Dir(link), 0o700)
+	if err := os.Symlink("/srv/spc1", link); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "tablespace_map"), []byte("16400 /srv/spc1\n16401 /srv/spc2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	o := &Orchestrator{
+		cfg:         &Config{ReplicaPGData: dir, TablespaceMapping: map[string]string{"/srv/spc1": "/data/spc1"}},
+		tablespaces: []postgres.Tablespace{{Oid: 16400, Location: "/srv/spc1"}, {Oid: 16401, Location: "/srv/spc2"}},
+	}
+	if err := o.applyTablespaceMapping(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.Readlink(link); got != "/data/spc1" {
+		t.Fatalf("link -> %q", got)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "tablespace_map")); string(data) != "16400 /data/spc1\n16401 /srv/spc2\n" {
+		t.Fatalf("tablespace_map:\n%s", data)
+	}
+}
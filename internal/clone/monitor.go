@@ -0,0 +1,220 @@
+package clone
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/vbp1/pgclone/internal/postgres"
+	"github.com/vbp1/pgclone/internal/progress"
+	"github.com/vbp1/pgclone/internal/wal"
+)
+
+// monitorInterval is how often the background monitors sample the primary.
+const monitorInterval = 10 * time.Second
+
+// monitorQueryTimeout is the statement_timeout of a single monitor query on the control session.
+const monitorQueryTimeout = 30 * time.Second
+
+// slotWarnThresholds are shares of max_slot_wal_keep_size retained by the slot that trigger a warning.
+var slotWarnThresholds = []float64{0.5, 0.8, 0.9}
+
+// slotWatch tracks which warnings about one slot have already been issued.
+type slotWatch struct {
+	slot     string
+	level    int  // number of slotWarnThresholds crossed
+	extended bool // wal_status "extended" reported
+}
+
+// eval returns a warning to log (if any) and a fatal error when the slot no longer protects WAL.
+func (w *slotWatch) eval(info postgres.SlotInfo) (string, error) {
+	if !info.Exists {
+		return "", fmt.Errorf("%w: slot %s disappeared", ErrSlotInvalidated, w.slot)
+	}
+	switch info.WALStatus {
+	case "unreserved", "lost":
+		return "", fmt.Errorf("%w: slot %s wal_status %s (retained %s)", ErrSlotInvalidated, w.slot, info.WALStatus, progress.FormatBytes(info.Retained))
+	}
+	if info.SafeWALSize >= 0 {
+		used := float64(info.Retained) / float64(info.Retained+info.SafeWALSize)
+		crossed := w.level
+		for crossed < len(slotWarnThresholds) && used >= slotWarnThresholds[crossed] {
+			crossed++
+		}
+		if crossed > w.level {
+			w.level = crossed
+			return fmt.Sprintf("slot %s retains %.0f%% of max_slot_wal_keep_size (%s, %s left before invalidation)",
+				w.slot, used*100, progress.FormatBytes(info.Retained), progress.FormatBytes(info.SafeWALSize)), nil
+		}
+	}
+	if info.WALStatus == "extended" && !w.extended {
+		w.extended = true
+		return fmt.Sprintf("slot %s retains more than max_wal_size (%s)", w.slot, progress.FormatBytes(info.Retained)), nil
+	}
+	return "", nil
+}
+
+// slotStatusText renders info for the progress output.
+func slotStatusText(info postgres.SlotInfo) string {
+	s := fmt.Sprintf("%s, retained %s", info.WALStatus, progress.FormatBytes(info.Retained))
+	if info.SafeWALSize >= 0 {
+		s += fmt.Sprintf(", %s left", progress.FormatBytes(info.SafeWALSize))
+	}
+	return s
+}
+
+// monitorSlot samples the slot on the control session until ctx ends, shows its retention
+// next to the progress output and aborts the run once the slot is unreserved or lost.
+func (o *Orchestrator) monitorSlot(ctx context.Context) {
+	w := &slotWatch{slot: o.slot}
+	go func() {
+		defer progress.SetStatus("slot", "")
+		ticker := time.NewTicker(monitorInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			info, err := postgres.SlotStatus(ctx, o.conn, o.slot)
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.Debug("slot monitor", "err", err)
+				}
+				continue
+			}
+			progress.SetStatus("slot", slotStatusText(info))
+			slog.Debug("slot status", "slot", o.slot, "wal_status", info.WALStatus, "retained", info.Retained, "safe_wal_size", info.SafeWALSize)
+			warn, err := w.eval(info)
+			if warn != "" {
+				slog.Warn(warn)
+			}
+			if err != nil {
+				slog.Error("aborting clone", "err", err)
+				o.cancel(err)
+				return
+			}
+		}
+	}()
+}
+
+// monitorPrimary re-reads the primary identity on the control session and aborts the run
+// as soon as the primary restarted, failed over or the session itself broke. The query
+// doubles as the session heartbeat, so a dead connection is noticed within monitorInterval.
+// A tick finding the session busy (pg_backup_start/stop) is skipped: that call notices a
+// broken session itself, and a heartbeat deadline must never cancel it.
+func (o *Orchestrator) monitorPrimary(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(monitorInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			var id postgres.Identity
+			ran, err := o.conn.TryDo(func(conn *pgx.Conn) error {
+				return postgres.WithStatementTimeout(ctx, conn, monitorQueryTimeout, func(tx pgx.Tx) error {
+					var err error
+					id, err = postgres.QueryIdentity(ctx, tx)
+					return err
+				})
+			})
+			if ctx.Err() != nil {
+				return
+			}
+			if !ran {
+				slog.Debug("primary monitor: control session busy, tick skipped")
+				continue
+			}
+			if err == nil {
+				err = o.compareIdentity(id)
+			} else if o.conn.IsClosed() {
+				err = o.diagnose(ctx, err)
+			} else {
+				slog.Debug("primary monitor", "err", err)
+				continue
+			}
+			if err != nil {
+				slog.Error("aborting clone", "err", err)
+				o.cancel(err)
+				return
+			}
+		}
+	}()
+}
+
+// compareIdentity reports how id differs from the primary the clone started on.
+func (o *Orchestrator) compareIdentity(id postgres.Identity) error {
+	base := o.identity
+	switch {
+	case id.SystemID != base.SystemID:
+		return fmt.Errorf("%w: system identifier %d, clone started on %d", ErrPrimaryChanged, id.SystemID, base.SystemID)
+	case id.InRecovery && !base.InRecovery:
+		return fmt.Errorf("%w: server is in recovery now (demoted)", ErrPrimaryChanged)
+	case !id.InRecovery && base.InRecovery:
+		return fmt.Errorf("%w: standby was promoted", ErrPrimaryChanged)
+	case id.Timeline != base.Timeline:
+		return fmt.Errorf("%w: %w: timeline %d, clone started on %d", ErrPrimaryChanged, wal.ErrTimelineSwitch, id.Timeline, base.Timeline)
+	case !id.StartTime.Equal(base.StartTime):
+		return fmt.Errorf("%w: postmaster started at %s, clone started against instance from %s",
+			ErrPrimaryRestarted, id.StartTime.Format(time.RFC3339), base.StartTime.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// diagnose classifies a broken control session by looking at the primary through a new connection.
+// While a backup is running the result also matches ErrBackupAborted: the server ends it with the session.
+func (o *Orchestrator) diagnose(ctx context.Context, sessionErr error) error {
+	err := o.probePrimary(ctx, sessionErr)
+	if o.inBackup.Load() {
+		return fmt.Errorf("%w: %w", ErrBackupAborted, err)
+	}
+	return err
+}
+
+func (o *Orchestrator) probePrimary(ctx context.Context, sessionErr error) error {
+	dctx, cancel := context.WithTimeout(ctx, monitorQueryTimeout)
+	defer cancel()
+	conn, err := pgx.Connect(dctx, o.connString())
+	if err != nil {
+		return fmt.Errorf("%w: %v; primary unreachable: %v", ErrControlConnLost, sessionErr, err)
+	}
+	defer func() { _ = conn.Close(dctx) }()
+	id, err := postgres.QueryIdentity(dctx, conn)
+	if err != nil {
+		return fmt.Errorf("%w: %v; %v", ErrControlConnLost, sessionErr, err)
+	}
+	if err := o.compareIdentity(id); err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ErrControlConnLost, sessionErr)
+}
+
+// checkStandby refuses a standby that does not stream from its upstream or replays too late.
+func (o *Orchestrator) checkStandby(ctx context.Context) error {
+	st, err := postgres.QueryStandbyStatus(ctx, o.conn)
+	if err != nil {
+		return err
+	}
+	slog.Info("standby status", "streaming", st.Streaming, "receive_lsn", st.ReceiveLSN, "replay_lsn", st.ReplayLSN,
+		"replay_lag", progress.FormatBytes(int64(st.ReplayLag())), "replay_delay", st.ReplayDelay)
+	return standbyUsable(st, o.cfg.MaxStandbyLag)
+}
+
+// standbyUsable checks st against the lag limit (0 = no limit).
+func standbyUsable(st postgres.StandbyStatus, maxLag time.Duration) error {
+	switch {
+	case !st.Streaming:
+		return errors.New("standby is not streaming from its upstream")
+	case maxLag > 0 && st.ReplayDelay > maxLag:
+		return fmt.Errorf("standby replay is %s behind (limit %s)", st.ReplayDelay.Round(time.Second), maxLag)
+	}
+	return nil
+}
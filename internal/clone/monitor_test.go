@@ -0,0 +1,116 @@
+package clone
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vbp1/pgclone/internal/postgres"
+	"github.com/vbp1/pgclone/internal/wal"
+)
+
+func TestSlotWatch(t *testing.T) {
+	w := &slotWatch{slot: "s"}
+	steps := []struct {
+		info postgres.SlotInfo
+		warn string
+	}{
+		{postgres.SlotInfo{Exists: true, WALStatus: "reserved", Retained: 10, SafeWALSize: 90}, ""},
+		{postgres.SlotInfo{Exists: true, WALStatus: "reserved", Retained: 55, SafeWALSize: 45}, "55%"},
+		{postgres.SlotInfo{Exists: true, WALStatus: "reserved", Retained: 60, SafeWALSize: 40}, ""},
+		{postgres.SlotInfo{Exists: true, WALStatus: "extended", Retained: 92, SafeWALSize: 8}, "92%"},
+		{postgres.SlotInfo{Exists: true, WALStatus: "extended", Retained: 93, SafeWALSize: 7}, "max_wal_size"},
+		{postgres.SlotInfo{Exists: true, WALStatus: "extended", Retained: 94, SafeWALSize: 6}, ""},
+	}
+	for i, s := range steps {
+		warn, err := w.eval(s.info)
+		if err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if (s.warn == "") != (warn == "") || !strings.Contains(warn, s.warn) {
+			t.Fatalf("step %d: warn %q, want %q", i, warn, s.warn)
+		}
+	}
+
+	for _, info := range []postgres.SlotInfo{
+		{Exists: true, WALStatus: "unreserved", Retained: 100, SafeWALSize: 0},
+		{Exists: true, WALStatus: "lost"},
+		{},
+	} {
+		if _, err := w.eval(info); !errors.Is(err, ErrSlotInvalidated) {
+			t.Fatalf("%+v: expected ErrSlotInvalidated, got %v", info, err)
+		}
+	}
+}
+
+func TestCompareIdentity(t *testing.T) {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	o := &Orchestrator{identity: postgres.Identity{SystemID: 42, Timeline: 3, StartTime: start}}
+	cases := []struct {
+		id   postgres.Identity
+		want error
+	}{
+		{postgres.Identity{SystemID: 42, Timeline: 3, StartTime: start}, nil},
+		{postgres.Identity{SystemID: 43, Timeline: 3, StartTime: start}, ErrPrimaryChanged},
+		{postgres.Identity{SystemID: 42, Timeline: 3, StartTime: start, InRecovery: true}, ErrPrimaryChanged},
+		{postgres.Identity{SystemID: 42, Timeline: 4, StartTime: start}, wal.ErrTimelineSwitch},
+		{postgres.Identity{SystemID: 42, Timeline: 3, StartTime: start.Add(time.Minute)}, ErrPrimaryRestarted},
+	}
+	for i, c := range cases {
+		err := o.compareIdentity(c.id)
+		if c.want == nil && err != nil || c.want != nil && !errors.Is(err, c.want) {
+			t.Fatalf("case %d: got %v, want %v", i, err, c.want)
+		}
+	}
+
+	// cloning from a standby: recovery is expected, promotion is a change
+	o.identity.InRecovery = true
+	if err := o.compareIdentity(postgres.Identity{SystemID: 42, Timeline: 3, StartTime: start, InRecovery: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.compareIdentity(postgres.Identity{SystemID: 42, Timeline: 3, StartTime: start}); !errors.Is(err, ErrPrimaryChanged) {
+		t.Fatalf("promotion: got %v", err)
+	}
+}
+
+func TestDiagnoseDuringBackup(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	_ = l.Close() // nothing listens: primary unreachable
+
+	o := &Orchestrator{cfg: &Config{}}
+	o.conninfo, _ = primaryConninfo(&Config{PGHost: "127.0.0.1", PGPort: port, PGUser: "postgres", PGSSLMode: "disable"})
+	sessionErr := errors.New("connection reset")
+	err = o.diagnose(context.Background(), sessionErr)
+	if !errors.Is(err, ErrControlConnLost) || errors.Is(err, ErrBackupAborted) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	o.inBackup.Store(true)
+	err = o.diagnose(context.Background(), sessionErr)
+	if !errors.Is(err, ErrControlConnLost) || !errors.Is(err, ErrBackupAborted) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestStandbyUsable(t *testing.T) {
+	st := postgres.StandbyStatus{Streaming: true, ReplayDelay: 90 * time.Second}
+	if err := standbyUsable(st, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := standbyUsable(st, 2*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := standbyUsable(st, time.Minute); err == nil || !strings.Contains(err.Error(), "1m30s behind") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	st.Streaming = false
+	if err := standbyUsable(st, 0); err == nil {
+		t.Fatal("expected error for a standby that does not stream")
+	}
+}
@@ -3,7 +3,7 @@ package clone
 import (
 	"bytes"
 	"context"
-	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -12,31 +12,70 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/vbp1/pgclone/internal/basebackup"
+	"github.com/vbp1/pgclone/internal/localcopy"
+	"github.com/vbp1/pgclone/internal/pgconf"
 	"github.com/vbp1/pgclone/internal/postgres"
+	"github.com/vbp1/pgclone/internal/progress"
 	"github.com/vbp1/pgclone/internal/rsync"
 	"github.com/vbp1/pgclone/internal/ssh"
 	"github.com/vbp1/pgclone/internal/wal"
 )
 
+// archiveTimeout bounds the wait for the archiver to deliver one WAL segment.
+const archiveTimeout = 10 * time.Minute
+
+// walModule is the rsyncd module exporting the primary pg_wal for missing-segment fallback.
+const walModule = "pg_wal"
+
+// walRestarts is how many times a receiver using a replication slot may reconnect.
+const walRestarts = 3
+
 // Orchestrator keeps state across clone steps.
 type Orchestrator struct {
 	cfg *Config
 
-	conn *pgx.Conn
-	recv *wal.Receiver
+	cancel context.CancelCauseFunc // aborts every running step with the given cause
+
+	conninfo    postgres.Conninfo // primary connection options shared by all connections
+	primaryHost string            // host the conninfo resolves to; target of SSH and rsync
+
+	conn     *postgres.Session  // control session: pg_backup_start/stop and monitors
+	backup   postgres.BackupAPI // backup functions of the server version
+	inBackup atomic.Bool        // between pg_backup_start and pg_backup_stop on conn
+	recv     wal.Streamer
+
+	appName string // application_name of our WAL receiver
+	slot    string // replication slot used by the receiver; empty = none
+	ownSlot bool   // slot was created by pgclone and is dropped in Close
 
 	rsyncPort   int
 	rsyncSecret string
+	secretFile  string // rsync password file of this process
 
 	rsyncDaemon *rsync.Daemon
+	rsyncCfg    *rsync.Config // set once rsync transfers start; enables WAL fallback from primary pg_wal
 
 	sshClient *ssh.Client
 
-	startLSN string
-	stopLSN  string
+	local bool // primary data directory is on this host: copy directly, no SSH/rsync
+
+	replicas []replica // controller mode and several targets: the replica side of each target
+	workers  []int     // rsync workers each of replicas copies with
+	label    string    // target name before rsync progress and summaries; set with several targets
+
+	startLSN postgres.LSN
+	stopLSN  postgres.LSN
+
+	identity postgres.Identity // primary as seen when the clone started
+	systemID uint64
+	timeline uint32
+	segSize  uint64
 
 	tablespaces []postgres.Tablespace
 
@@ -45,10 +84,27 @@ type Orchestrator struct {
 
 // Close releases external resources; safe to call multiple times.
 func (o *Orchestrator) Close(ctx context.Context) {
+	// first: the receiver of the first target may use the slot dropped below
+	for _, r := range o.replicas {
+		r.close(ctx)
+	}
+	o.replicas = nil
 	if o.recv != nil {
 		_ = o.recv.Stop()
 		o.recv = nil
 	}
+	if o.ownSlot {
+		if err := o.withReplication(ctx, func(rconn *pgconn.PgConn) error {
+			return postgres.DropSlot(ctx, rconn, o.slot)
+		}); err != nil {
+			slog.Warn("drop replication slot", "slot", o.slot, "err", err)
+		}
+		o.ownSlot = false
+	}
+	if o.conn != nil {
+		_ = o.conn.Close(ctx)
+		o.conn = nil
+	}
 	if o.rsyncDaemon != nil {
 		_ = o.rsyncDaemon.Stop(ctx)
 		o.rsyncDaemon = nil
@@ -57,42 +113,251 @@ func (o *Orchestrator) Close(ctx context.Context) {
 		_ = o.sshClient.Close()
 		o.sshClient = nil
 	}
+	if o.secretFile != "" {
+		_ = os.Remove(o.secretFile)
+		o.secretFile = ""
+	}
 	if o.tmpDir != "" && !o.cfg.KeepRunTmp {
 		_ = os.RemoveAll(o.tmpDir)
 		o.tmpDir = ""
 	}
 }
 
-// Run executes full clone pipeline (WAL receiver + rsyncd + backup start – partial).
+// Run executes full clone pipeline (WAL receiver + rsyncd or BASE_BACKUP + WAL finalize).
 func Run(ctx context.Context, cfg *Config) error {
-	o := &Orchestrator{cfg: cfg}
-	defer o.Close(ctx)
-	if err := o.stepWalAndRsyncd(ctx); err != nil {
+	ctx, cancel := context.WithCancelCause(ctx)
+	o := &Orchestrator{cfg: cfg, cancel: cancel}
+	defer func() {
+		// stop monitors first so closing the control session is not reported as a failure;
+		// cleanup must reach the primary even if the run was aborted
+		cancel(nil)
+		cctx, stop := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		defer stop()
+		o.Close(cctx)
+	}()
+	if err := o.run(ctx); err != nil {
+		if cause := abortCause(ctx, err); cause != err {
+			return cause
+		}
+		if o.conn != nil && o.conn.IsClosed() {
+			// a step hit the broken session before the monitor did
+			return o.diagnose(context.WithoutCancel(ctx), err)
+		}
 		return err
 	}
-	if err := o.stepBackupStart(ctx); err != nil {
+	slog.Info("clone pipeline completed – replica ready")
+	return nil
+}
+
+// abortCause prefers the reason the run was aborted over the error of the step that noticed it.
+func abortCause(ctx context.Context, err error) error {
+	cause := context.Cause(ctx)
+	if cause == nil || errors.Is(cause, context.Canceled) || errors.Is(cause, context.DeadlineExceeded) {
 		return err
 	}
+	return cause
+}
 
-	if err := o.stepBackupStop(ctx); err != nil {
+// run executes the clone steps in order.
+func (o *Orchestrator) run(ctx context.Context) error {
+	cfg := o.cfg
+	if cfg.ReplicaHost != "" || len(cfg.Targets) > 0 {
+		return o.runTargets(ctx)
+	}
+	if err := o.resolvePrimary(ctx); err != nil {
+		return err
+	}
+	if err := o.stepWal(ctx); err != nil {
 		return err
 	}
 
+	switch cfg.Method {
+	case MethodBaseBackup:
+		if err := o.stepBaseBackup(ctx); err != nil {
+			return err
+		}
+	default:
+		if err := o.checkLocal(); err != nil {
+			return err
+		}
+		if !o.local {
+			if err := o.stepRsyncd(ctx); err != nil {
+				return err
+			}
+		}
+		if err := o.stepBackupStart(ctx); err != nil {
+			return err
+		}
+		if err := o.stepBackupStop(ctx); err != nil {
+			return err
+		}
+	}
+
 	if err := o.stepWalFinalize(ctx); err != nil {
 		return err
 	}
 
+	if err := o.prepareReplica(ctx); err != nil {
+		return err
+	}
+
+	if cfg.Handoff {
+		if err := o.stepHandoff(ctx); err != nil {
+			return err
+		}
+	} else if cfg.Start {
+		if err := o.startReplica(ctx); err != nil {
+			return err
+		}
+	}
+	switch {
+	case cfg.Start && cfg.WriteRecoveryConf:
+		return o.waitReplicaStreaming(ctx, o.cfg, o.replicaGone)
+	case cfg.Start && cfg.Detach:
+		return o.waitPromoted(ctx)
+	}
+	return nil
+}
+
+// prepareReplica runs the final checks and configures the copy: recovery or detach
+// configuration, setting rules and the validation boot.
+func (o *Orchestrator) prepareReplica(ctx context.Context) error {
+	cfg := o.cfg
 	if err := o.stepFinalChecks(ctx); err != nil {
 		return err
 	}
 
-	slog.Info("clone pipeline completed – replica ready")
+	switch {
+	case cfg.WriteRecoveryConf:
+		if err := o.writeRecoveryConf(); err != nil {
+			return err
+		}
+	case cfg.Detach:
+		if err := o.writeDetachConf(); err != nil {
+			return err
+		}
+	}
+
+	if len(cfg.ConfRules) > 0 {
+		if err := o.stepRewriteConf(); err != nil {
+			return err
+		}
+	}
+
+	if cfg.ValidateBoot {
+		return o.validateBoot(ctx)
+	}
 	return nil
 }
 
-// stepWalAndRsyncd starts pg_receivewal, waits replication, then launches rsyncd on primary.
-func (o *Orchestrator) stepWalAndRsyncd(ctx context.Context) error {
-	// tmp dir for WAL
+// stepWal opens the control connection, starts the WAL receiver, waits replication and fetches tablespaces.
+func (o *Orchestrator) stepWal(ctx context.Context) error {
+	// single pgx connection for backup start/stop
+	var err error
+	if o.conn, err = postgres.ConnectSession(ctx, o.connString()); err != nil {
+		return err
+	}
+
+	if o.identity, err = postgres.QueryIdentity(ctx, o.conn); err != nil {
+		return err
+	}
+	switch {
+	case o.identity.InRecovery && !o.cfg.FromStandby:
+		return fmt.Errorf("%s is in recovery, not a primary (use --from-standby to clone a standby)", o.primaryHost)
+	case !o.identity.InRecovery && o.cfg.FromStandby:
+		return fmt.Errorf("%s is not in recovery, --from-standby needs a standby", o.primaryHost)
+	case o.cfg.FromStandby:
+		if err := o.checkStandby(ctx); err != nil {
+			return err
+		}
+		if o.cfg.WALSource == WALSourceArchive {
+			// a standby only archives what it receives with archive_mode = always
+			mode, err := postgres.ArchiveMode(ctx, o.conn)
+			if err != nil {
+				return err
+			}
+			if mode != "always" {
+				return fmt.Errorf("--from-standby --wal-source %s needs archive_mode = always on the standby, it is %s", WALSourceArchive, mode)
+			}
+		}
+	}
+	o.systemID, o.timeline = o.identity.SystemID, o.identity.Timeline
+	o.monitorPrimary(ctx)
+	version, err := postgres.ServerVersion(ctx, o.conn)
+	if err != nil {
+		return err
+	}
+	if o.backup, err = postgres.NewBackupAPI(version); err != nil {
+		return err
+	}
+	if o.cfg.Method == MethodBaseBackup && !o.backup.NativeBaseBackup() {
+		return fmt.Errorf("--method %s requires PostgreSQL 15+, server reports %d", MethodBaseBackup, version)
+	}
+	if o.segSize, err = postgres.WALSegmentSize(ctx, o.conn); err != nil {
+		return err
+	}
+	// a base backup needs it only for the overlap checks of a primary on this host
+	if o.cfg.PrimaryPGData == "" && (o.cfg.Method == MethodRsync || isLocalHost(o.primaryHost)) {
+		if o.cfg.PrimaryPGData, err = postgres.DataDirectory(ctx, o.conn); err != nil {
+			return fmt.Errorf("%w (or pass --primary-pgdata)", err)
+		}
+		slog.Info("primary data directory", "dir", o.cfg.PrimaryPGData)
+	}
+
+	o.appName = fmt.Sprintf("pgclone-%d", time.Now().UnixNano())
+	o.slot = o.cfg.SlotName
+	if o.cfg.UseSlot && o.slot == "" {
+		// owned by the orchestrator so it outlives receiver restarts (handoff) and is dropped in Close
+		slot := fmt.Sprintf("pgclone_%d", time.Now().UnixNano())
+		if err := o.withReplication(ctx, func(rconn *pgconn.PgConn) error {
+			return postgres.CreatePhysicalSlot(ctx, rconn, slot)
+		}); err != nil {
+			return err
+		}
+		o.slot, o.ownSlot = slot, true
+		slog.Info("replication slot created", "slot", slot)
+	}
+
+	switch {
+	case o.cfg.WALSource == WALSourceArchive:
+		slog.Info("WAL will be taken from the archive, streaming skipped", "archive", o.cfg.WALArchive)
+	case len(o.replicas) > 0:
+		if err := o.targetStream(ctx); err != nil {
+			return err
+		}
+	default:
+		if err := o.startTempReceiver(ctx); err != nil {
+			return err
+		}
+	}
+	if o.slot != "" && o.cfg.WALSource != WALSourceArchive {
+		o.monitorSlot(ctx)
+	}
+
+	// fetch tablespaces
+	return o.conn.Do(func(conn *pgx.Conn) error {
+		tsRows, err := conn.Query(ctx, `SELECT oid, pg_tablespace_location(oid)
+                                          FROM pg_tablespace
+                                          WHERE spcname NOT IN ('pg_default','pg_global')`)
+		if err != nil {
+			return err
+		}
+		defer tsRows.Close()
+		for tsRows.Next() {
+			var oid uint32
+			var loc string
+			if err := tsRows.Scan(&oid, &loc); err != nil {
+				return err
+			}
+			o.tablespaces = append(o.tablespaces, postgres.Tablespace{Oid: oid, Location: loc})
+		}
+		return tsRows.Err()
+	})
+}
+
+// startTempReceiver starts the WAL receiver in the temporary WAL directory (cfg.TempWALDir or a
+// new one removed in Close).
+func (o *Orchestrator) startTempReceiver(ctx context.Context) error {
 	walDir := o.cfg.TempWALDir
 	if walDir == "" {
 		d, err := os.MkdirTemp("", "pgclone_wal_*")
@@ -102,66 +367,112 @@ func (o *Orchestrator) stepWalAndRsyncd(ctx context.Context) error {
 		walDir = d
 		o.tmpDir = d
 	}
+	return o.startReceiver(ctx, walDir, true)
+}
 
-	appName := fmt.Sprintf("pgclone-%d", time.Now().UnixNano())
-
-	o.recv = &wal.Receiver{
-		Host:    o.cfg.PGHost,
-		Port:    o.cfg.PGPort,
-		User:    o.cfg.PGUser,
-		Dir:     walDir,
-		Slot:    o.cfg.SlotName,
-		Verbose: o.cfg.Verbose,
-		AppName: appName,
-	}
-	if err := o.recv.Start(ctx); err != nil {
-		return err
+// startReceiver starts the configured WAL receiver writing into dir and returns once it streams;
+// compress applies o.cfg.WALCompress to completed segments (used for the temporary directory only).
+func (o *Orchestrator) startReceiver(ctx context.Context, dir string, compress bool) error {
+	method := ""
+	if compress {
+		method = o.walCompress()
 	}
-	slog.Info("pg_receivewal started", "dir", walDir)
+	switch o.cfg.WALReceiver {
+	case ReceiverPgReceivewal:
+		o.recv = &wal.Receiver{
+			ConnString:  o.connString(),
+			Dir:         dir,
+			Slot:        o.slot,
+			SegmentSize: o.segSize,
+			Compress:    method,
+			Restarts:    walRestarts,
+			Verbose:     o.cfg.Verbose,
+			AppName:     o.appName,
+		}
+		if err := o.recv.Start(ctx); err != nil {
+			return err
+		}
+		slog.Info("pg_receivewal started", "dir", dir)
 
-	// single pgx connection for backup start/stop
-	conn, err := pgx.Connect(ctx, fmt.Sprintf("host=%s port=%d user=%s sslmode=disable", o.cfg.PGHost, o.cfg.PGPort, o.cfg.PGUser))
-	if err != nil {
-		_ = o.recv.Stop()
-		return err
+		// an agent has no control session, the controller waits instead
+		if o.conn != nil {
+			if err := postgres.WaitReplicationStarted(ctx, o.conn, o.appName, 60*time.Second); err != nil {
+				return err
+			}
+		}
+	default:
+		// START_REPLICATION has already succeeded when Start returns
+		o.recv = &wal.NativeReceiver{
+			ConnString: o.connString(),
+			Dir:        dir,
+			Slot:       o.slot,
+			Compress:   method,
+			Restarts:   walRestarts,
+			AppName:    o.appName,
+		}
+		if err := o.recv.Start(ctx); err != nil {
+			return err
+		}
 	}
-	o.conn = conn
+	slog.Info("replication started", "receiver", o.cfg.WALReceiver, "dir", dir)
+	o.watchReceiver(ctx)
+	return nil
+}
 
-	if err := postgres.WaitReplicationStarted(ctx, o.conn, appName, 60*time.Second); err != nil {
-		return err
+// walCompress returns the effective compression method for temporary WAL.
+func (o *Orchestrator) walCompress() string {
+	if o.cfg.WALCompress == "" {
+		return wal.CompressNone
 	}
-	slog.Info("replication started")
+	return o.cfg.WALCompress
+}
 
-	// fetch tablespaces
-	tsRows, err := o.conn.Query(ctx, `SELECT oid, pg_tablespace_location(oid)
-                                       FROM pg_tablespace
-                                       WHERE spcname NOT IN ('pg_default','pg_global')`)
+// withReplication runs fn on a short-lived replication connection to the primary.
+func (o *Orchestrator) withReplication(ctx context.Context, fn func(*pgconn.PgConn) error) error {
+	rconn, err := postgres.ConnectReplication(ctx, o.connString(), "")
 	if err != nil {
 		return err
 	}
-	for tsRows.Next() {
-		var oid uint32
-		var loc string
-		if err := tsRows.Scan(&oid, &loc); err != nil {
-			return err
+	defer func() { _ = rconn.Close(ctx) }()
+	return fn(rconn)
+}
+
+// watchReceiver aborts the whole run as soon as WAL streaming fails for good:
+// WAL missing in the middle of the backup cannot be recovered afterwards.
+func (o *Orchestrator) watchReceiver(ctx context.Context) {
+	errCh := o.recv.Err()
+	go func() {
+		select {
+		case err := <-errCh:
+			slog.Error("aborting clone: WAL streaming failed", "err", err)
+			o.cancel(fmt.Errorf("%w: %w", ErrWALStreamLost, err))
+		case <-ctx.Done():
 		}
-		o.tablespaces = append(o.tablespaces, postgres.Tablespace{Oid: oid, Location: loc})
-	}
-	_ = tsRows.Err()
+	}()
+}
+
+// connString returns libpq-style conninfo for the primary.
+func (o *Orchestrator) connString() string { return o.conninfo.String() }
 
+// stepRsyncd launches rsyncd on the primary via SSH.
+func (o *Orchestrator) stepRsyncd(ctx context.Context) error {
 	// build modules map
 	modules := map[string]string{
-		"pgdata": o.cfg.PrimaryPGData,
-		"base":   filepath.Join(o.cfg.PrimaryPGData, "base"),
+		"pgdata":  o.cfg.PrimaryPGData,
+		"base":    filepath.Join(o.cfg.PrimaryPGData, "base"),
+		walModule: filepath.Join(o.cfg.PrimaryPGData, "pg_wal"),
 	}
 	for _, t := range o.tablespaces {
 		modules[fmt.Sprintf("spc_%d", t.Oid)] = t.Location
 	}
 
+	if o.cfg.SSHUser == "" {
+		return fmt.Errorf("--ssh-user required for --method %s when the primary is not on this host", MethodRsync)
+	}
 	// ssh client
 	sshClient, err := ssh.Dial(ctx, ssh.Config{
 		User:     o.cfg.SSHUser,
-		Host:     o.cfg.PGHost,
+		Host:     o.primaryHost,
 		KeyPath:  o.cfg.SSHKey,
 		Insecure: o.cfg.InsecureSSH,
 		Timeout:  10 * time.Second,
@@ -172,7 +483,7 @@ func (o *Orchestrator) stepWalAndRsyncd(ctx context.Context) error {
 	// bootstrap
 	daemon, err := rsync.StartRemote(ctx, sshClient, rsync.BootstrapOptions{
 		Modules: modules,
-		MaxConn: o.cfg.Parallel * 4,
+		MaxConn: o.rsyncdMaxConn(),
 	})
 	if err != nil {
 		return err
@@ -184,6 +495,20 @@ func (o *Orchestrator) stepWalAndRsyncd(ctx context.Context) error {
 	return nil
 }
 
+// rsyncdMaxConn is the connection limit of rsyncd: the workers of every target plus the
+// initial rsync, list-only and dry-run connections next to them.
+func (o *Orchestrator) rsyncdMaxConn() int {
+	workers := o.workers
+	if len(workers) == 0 {
+		workers = []int{rsync.Workers(o.cfg.Parallel)}
+	}
+	n := 0
+	for _, w := range workers {
+		n += w + 4
+	}
+	return max(n, 16)
+}
+
 // listModuleFiles returns file listing for a module via rsync --list-only.
 func listModuleFiles(ctx context.Context, cfg rsync.Config, module string) ([]rsync.FileInfo, error) {
 	args := []string{"--recursive", "--list-only", "--password-file", cfg.SecretFile}
@@ -201,30 +526,116 @@ func listModuleFiles(ctx context.Context, cfg rsync.Config, module string) ([]rs
 	return files, nil
 }
 
-// stepBackupStart calls pg_backup_start and stores LSN.
+// stepBackupStart starts a non-exclusive backup and copies the data directory.
 func (o *Orchestrator) stepBackupStart(ctx context.Context) error {
-	if err := o.conn.QueryRow(ctx, `SELECT pg_backup_start('pgclone', true)`).Scan(&o.startLSN); err != nil {
-		return fmt.Errorf("pg_backup_start: %w", err)
+	if err := o.startBackup(ctx); err != nil {
+		return err
 	}
+	return o.copyData(ctx, o.copyExcludes())
+}
+
+// startBackup calls pg_backup_start on the control session and stores the START LSN.
+func (o *Orchestrator) startBackup(ctx context.Context) error {
+	lsn, err := o.backup.Start(ctx, o.conn, "pgclone", true)
+	if err != nil {
+		return err
+	}
+	o.startLSN = lsn
+	o.inBackup.Store(true)
 	slog.Info("backup started", "start_lsn", o.startLSN)
+	return nil
+}
+
+// copyExcludes are the paths the initial copy skips: what the backup API excludes and
+// base/, which is copied in parallel afterwards.
+func (o *Orchestrator) copyExcludes() []string {
+	return append(o.backup.Excludes(), "base/")
+}
+
+// copyData copies PGDATA (without excludes) and then base/ and the tablespaces into the
+// replica, from the local primary or the rsyncd modules.
+func (o *Orchestrator) copyData(ctx context.Context, excludes []string) error {
+	var since postgres.LSN
+	var err error
+	incremental := false
+	if o.cfg.Incremental {
+		// read before the initial rsync replaces the replica pg_control
+		since, err = o.incrementalBase()
+		switch {
+		case err == nil:
+			incremental = true
+		case errors.Is(err, errIncrementalUnavailable):
+			slog.Warn("copying all files", "reason", err)
+		default:
+			return err
+		}
+	}
 
-	// initial rsync PGDATA excluding base/pg_wal etc.
+	// initial copy of PGDATA excluding base/pg_wal etc.
 	// Ensure replica data directory exists (mkdir -p)
 	if err := os.MkdirAll(o.cfg.ReplicaPGData, 0o755); err != nil {
 		return fmt.Errorf("create replica data dir: %w", err)
 	}
-	secretFile := filepath.Join(os.TempDir(), "pgclone_rsync_pass")
-	if err := os.WriteFile(secretFile, []byte(o.rsyncSecret), 0o600); err != nil {
+	if o.local {
+		slog.Info("copying pgdata locally")
+		if err := localcopy.Tree(ctx, o.cfg.PrimaryPGData, o.cfg.ReplicaPGData, excludes); err != nil {
+			return fmt.Errorf("initial copy: %w", err)
+		}
+		slog.Info("initial copy done")
+	} else if err := o.rsyncInitial(ctx, excludes); err != nil {
+		return err
+	}
+	// before anything writes through pg_tblspc
+	if err := o.applyTablespaceMapping(); err != nil {
+		return err
+	}
+
+	// ensure required empty directories that were excluded from rsync
+	runtimeDirs := []string{"pg_replslot", "pg_dynshmem", "pg_notify", "pg_serial", "pg_snapshots", "pg_stat_tmp", "pg_subtrans"}
+	for _, d := range runtimeDirs {
+		path := filepath.Join(o.cfg.ReplicaPGData, d)
+		_ = os.MkdirAll(path, 0o700)
+	}
+
+	if incremental {
+		err := o.refreshIncremental(ctx, since)
+		if !errors.Is(err, errIncrementalUnavailable) {
+			return err
+		}
+		slog.Warn("copying all files", "reason", err)
+	}
+
+	if o.local {
+		return o.copyLocal(ctx)
+	}
+	return o.rsyncParallel(ctx)
+}
+
+// rsyncInitial copies PGDATA without excludes from the rsyncd pgdata module.
+func (o *Orchestrator) rsyncInitial(ctx context.Context, excludes []string) error {
+	f, err := os.CreateTemp("", "pgclone_rsync_pass_*")
+	if err != nil {
+		return err
+	}
+	secretFile := f.Name()
+	o.secretFile = secretFile
+	_, err = f.WriteString(o.rsyncSecret)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
 		return err
 	}
 
 	rcfg := rsync.Config{
-		Host:       o.cfg.PGHost,
+		Host:       o.primaryHost,
 		Port:       o.rsyncPort,
 		SecretFile: secretFile,
 		Checksum:   o.cfg.Paranoid,
 		Verbose:    o.cfg.Verbose,
+		Label:      o.label,
 	}
+	o.rsyncCfg = &rcfg
 
 	// Build command for initial copy of entire PGDATA (excluding pg_wal & base)
 	rsyncArgs := []string{"-a", "--delete", "--stats"}
@@ -234,10 +645,6 @@ func (o *Orchestrator) stepBackupStart(ctx context.Context) error {
 	if rcfg.Verbose {
 		rsyncArgs = append(rsyncArgs, "--human-readable")
 	}
-	// exclusions identical to Bash implementation
-	excludes := []string{
-		"pg_wal/", "base/", "postmaster.pid", "postmaster.opts", "pg_replslot/", "pg_dynshmem/", "pg_notify/", "pg_serial/", "pg_snapshots/", "pg_stat_tmp/", "pg_subtrans/", "pgsql_tmp*", "pg_internal.init",
-	}
 	for _, ex := range excludes {
 		rsyncArgs = append(rsyncArgs, "--exclude", ex)
 	}
@@ -254,34 +661,36 @@ func (o *Orchestrator) stepBackupStart(ctx context.Context) error {
 		return fmt.Errorf("initial rsync: %w\n%s", err, string(out))
 	}
 	slog.Info("initial rsync done")
+	return nil
+}
 
-	// ensure required empty directories that were excluded from rsync
-	runtimeDirs := []string{"pg_replslot", "pg_dynshmem", "pg_notify", "pg_serial", "pg_snapshots", "pg_stat_tmp", "pg_subtrans"}
-	for _, d := range runtimeDirs {
-		path := filepath.Join(o.cfg.ReplicaPGData, d)
-		_ = os.MkdirAll(path, 0o700)
+// rsyncParallel copies base/ and the tablespaces with parallel rsync workers.
+func (o *Orchestrator) rsyncParallel(ctx context.Context) error {
+	rcfg := *o.rsyncCfg
+	logger := slog.Default()
+	if o.label != "" {
+		// several targets copy at once in this process
+		logger = logger.With("target", o.label)
 	}
-
-	// --- parallel rsync of base ---
 	startTransfer := time.Now()
 	totalStats := rsync.Stats{}
 	baseFiles, err := listModuleFiles(ctx, rcfg, "base")
 	if err != nil {
 		return err
 	}
-	slog.Info("base file list", "count", len(baseFiles))
+	logger.Info("base file list", "count", len(baseFiles))
 
 	baseDst := filepath.Join(o.cfg.ReplicaPGData, "base")
 	if err := os.MkdirAll(baseDst, 0o755); err != nil {
 		return err
 	}
 
-	showBar := o.cfg.Progress == "bar" || (o.cfg.Progress == "auto" && o.cfg.Verbose)
+	showBar := o.showBar()
 	stats, err := rsync.RunParallel(ctx, rcfg, "base", o.cfg.Parallel, baseFiles, baseDst, showBar, o.cfg.Progress, o.cfg.ProgressInt)
 	if err != nil {
 		return err
 	}
-	slog.Info("base rsync done", "files", stats.NumFiles, "bytes", stats.TotalTransferredSize)
+	logger.Info("base rsync done", "files", stats.NumFiles, "bytes", stats.TotalTransferredSize)
 	totalStats = totalStats.Add(stats)
 
 	// --- tablespaces ---
@@ -291,83 +700,232 @@ func (o *Orchestrator) stepBackupStart(ctx context.Context) error {
 		if err != nil {
 			return err
 		}
-		slog.Info("tablespace list", "oid", t.Oid, "count", len(spcFiles))
+		logger.Info("tablespace list", "oid", t.Oid, "count", len(spcFiles))
 		if len(spcFiles) == 0 {
 			continue
 		}
-		if err := os.MkdirAll(t.Location, 0o755); err != nil {
+		if err := os.MkdirAll(o.tablespaceDir(t), 0o755); err != nil {
 			return err
 		}
-		st, err := rsync.RunParallel(ctx, rcfg, mod, o.cfg.Parallel, spcFiles, t.Location, showBar, o.cfg.Progress, o.cfg.ProgressInt)
+		st, err := rsync.RunParallel(ctx, rcfg, mod, o.cfg.Parallel, spcFiles, o.tablespaceDir(t), showBar, o.cfg.Progress, o.cfg.ProgressInt)
 		if err != nil {
 			return err
 		}
-		slog.Info("tablespace rsync done", "oid", t.Oid, "bytes", st.TotalTransferredSize)
+		logger.Info("tablespace rsync done", "oid", t.Oid, "bytes", st.TotalTransferredSize)
 		totalStats = totalStats.Add(st)
 	}
 
 	// Print aggregated stats similar to bash implementation
-	slog.Info("rsync aggregate stats", "elapsed_sec", time.Since(startTransfer).Seconds())
-	fmt.Println(totalStats.Summary(time.Since(startTransfer)))
+	logger.Info("rsync aggregate stats", "elapsed_sec", time.Since(startTransfer).Seconds())
+	summary := totalStats.Summary(time.Since(startTransfer))
+	if o.label != "" {
+		summary = "\n" + o.label + ":" + summary
+	}
+	fmt.Println(summary)
 
 	return nil
 }
 
-// stepBackupStop finishes backup, fetches control files and stop LSN.
-func (o *Orchestrator) stepBackupStop(ctx context.Context) error {
-	var stopLSN, labelB64, mapB64 string
-	if err := o.conn.QueryRow(ctx, `SELECT lsn,
-          translate(encode(labelfile::bytea,  'base64'), E'\n', ''),
-          translate(encode(spcmapfile::bytea, 'base64'), E'\n', '')
-          FROM pg_backup_stop(true)`).Scan(&stopLSN, &labelB64, &mapB64); err != nil {
-		return fmt.Errorf("pg_backup_stop: %w", err)
+// stepBaseBackup copies the cluster through the replication protocol (BASE_BACKUP) instead of
+// pg_backup_start + rsync. backup_label, tablespace_map and pg_control arrive inside the main archive.
+func (o *Orchestrator) stepBaseBackup(ctx context.Context) error {
+	if isLocalHost(o.primaryHost) {
+		if err := o.checkOverlap(); err != nil {
+			return err
+		}
+	}
+	// the tar stream is a complete image: start from empty directories
+	if err := os.MkdirAll(o.cfg.ReplicaPGData, 0o700); err != nil {
+		return fmt.Errorf("create replica data dir: %w", err)
+	}
+	if err := o.emptyDir(o.cfg.ReplicaPGData); err != nil {
+		return fmt.Errorf("cleanup replica data dir: %w", err)
+	}
+	for _, t := range o.tablespaces {
+		dir := o.tablespaceDir(t)
+		if _, err := os.Stat(dir); err == nil {
+			if err := o.emptyDir(dir); err != nil {
+				return fmt.Errorf("cleanup tablespace %d: %w", t.Oid, err)
+			}
+		}
 	}
-	o.stopLSN = stopLSN
-	slog.Info("backup stopped", "stop_lsn", stopLSN)
 
-	// write backup_label & tablespace_map
-	labelBytes, _ := base64.StdEncoding.DecodeString(labelB64)
-	if err := os.WriteFile(filepath.Join(o.cfg.ReplicaPGData, "backup_label"), labelBytes, 0o644); err != nil {
+	rconn, err := postgres.ConnectReplication(ctx, o.connString(), "")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rconn.Close(context.Background()) }()
+
+	spcDir := func(oid uint32, location string) string {
+		return o.tablespaceDir(postgres.Tablespace{Oid: oid, Location: location})
+	}
+	startTransfer := time.Now()
+	res, err := basebackup.Run(ctx, rconn, basebackup.Options{
+		Label:            "pgclone",
+		FastCheckpoint:   true,
+		DataDir:          o.cfg.ReplicaPGData,
+		TablespaceDir:    spcDir,
+		ShowBar:          o.showBar(),
+		ProgressMode:     o.cfg.Progress,
+		ProgressInterval: o.cfg.ProgressInt,
+	})
+	if err != nil {
+		return err
+	}
+	if res.StartTLI != o.timeline || res.StopTLI != o.timeline {
+		return fmt.Errorf("%w: backup ran on timelines %d..%d, WAL streamed from %d", wal.ErrTimelineSwitch, res.StartTLI, res.StopTLI, o.timeline)
+	}
+	o.startLSN, o.stopLSN = res.StartLSN, res.StopLSN
+	slog.Info("backup stopped", "start_lsn", o.startLSN, "stop_lsn", o.stopLSN)
+	if err := o.applyTablespaceMapping(); err != nil {
+		return err
+	}
+
+	slog.Info("base backup aggregate stats", "elapsed_sec", time.Since(startTransfer).Seconds())
+	fmt.Println(res.Summary(time.Since(startTransfer)))
+	return nil
+}
+
+// showBar reports whether progress should be rendered as an mpb bar.
+func (o *Orchestrator) showBar() bool {
+	return o.cfg.Progress == "bar" || (o.cfg.Progress == "auto" && o.cfg.Verbose)
+}
+
+// stepBackupStop finishes backup, fetches control files and stop LSN.
+func (o *Orchestrator) stepBackupStop(ctx context.Context) error {
+	res, ctrl, err := o.stopBackup(ctx)
+	if err != nil {
 		return err
 	}
-	if mapB64 != "" {
-		mapBytes, _ := base64.StdEncoding.DecodeString(mapB64)
-		_ = os.WriteFile(filepath.Join(o.cfg.ReplicaPGData, "tablespace_map"), mapBytes, 0o644)
+	return o.writeBackupFiles(res.Label, res.TablespaceMap, ctrl)
+}
+
+// stopBackup calls pg_backup_stop, stores the STOP LSN and fetches pg_control from the primary.
+func (o *Orchestrator) stopBackup(ctx context.Context) (postgres.BackupResult, []byte, error) {
+	res, err := o.backup.Stop(ctx, o.conn, true)
+	if err != nil {
+		return res, nil, err
 	}
+	o.inBackup.Store(false)
+	o.stopLSN = res.StopLSN
+	slog.Info("backup stopped", "stop_lsn", o.stopLSN)
 
 	// fetch pg_control via ssh
 	ctrlPath := filepath.Join(o.cfg.PrimaryPGData, "global", "pg_control")
-	destCtrl := filepath.Join(o.cfg.ReplicaPGData, "global", "pg_control")
-	// Ensure destination directory exists (mkdir -p semantics)
-	_ = os.MkdirAll(filepath.Dir(destCtrl), 0o755)
-	cmd := fmt.Sprintf("cat '%s'", ctrlPath)
-	data, err := o.sshClient.Output(ctx, cmd)
+	var data []byte
+	if o.local {
+		data, err = os.ReadFile(ctrlPath)
+	} else {
+		data, err = o.sshClient.Output(ctx, fmt.Sprintf("cat '%s'", ctrlPath))
+	}
 	if err != nil {
-		return fmt.Errorf("fetch pg_control: %w", err)
+		return res, nil, fmt.Errorf("fetch pg_control: %w", err)
 	}
-	if err := os.WriteFile(destCtrl, data, 0o600); err != nil {
+	return res, data, nil
+}
+
+// writeBackupFiles writes backup_label, tablespace_map (if any) and pg_control into the replica.
+func (o *Orchestrator) writeBackupFiles(label, spcMap, ctrl []byte) error {
+	if err := os.WriteFile(filepath.Join(o.cfg.ReplicaPGData, "backup_label"), label, 0o644); err != nil {
 		return err
 	}
-	return nil
+	if len(spcMap) > 0 {
+		_ = os.WriteFile(filepath.Join(o.cfg.ReplicaPGData, "tablespace_map"), spcMap, 0o644)
+		if err := o.applyTablespaceMapping(); err != nil {
+			return err
+		}
+	}
+
+	destCtrl := filepath.Join(o.cfg.ReplicaPGData, "global", "pg_control")
+	// Ensure destination directory exists (mkdir -p semantics)
+	_ = os.MkdirAll(filepath.Dir(destCtrl), 0o755)
+	return os.WriteFile(destCtrl, ctrl, 0o600)
 }
 
-// stepWalFinalize waits for WAL, stops receiver, moves files, renames partial.
+// stepWalFinalize waits for WAL up to stop LSN, stops receiver, moves files, renames partial.
 func (o *Orchestrator) stepWalFinalize(ctx context.Context) error {
-	// compute wal filename
-	var walFile string
-	if err := o.conn.QueryRow(ctx, `SELECT pg_walfile_name($1)`, o.stopLSN).Scan(&walFile); err != nil {
+	if err := o.checkTimeline(ctx); err != nil {
 		return err
 	}
-	walDir := o.recv.Dir
-	deadline := time.Now().Add(60 * time.Second)
-	for {
-		if _, err := os.Stat(filepath.Join(walDir, walFile)); err == nil {
-			break
+	return o.finalizeWAL(ctx)
+}
+
+// finalizeWAL puts the WAL from START to STOP LSN into the replica WAL directory and verifies it.
+func (o *Orchestrator) finalizeWAL(ctx context.Context) error {
+	dstWal := o.replicaWALDir()
+	_ = os.MkdirAll(dstWal, 0o700)
+	if o.cfg.WALSource == WALSourceArchive {
+		if err := o.fetchArchivedWAL(ctx, dstWal); err != nil {
+			return err
 		}
-		if time.Now().After(deadline) {
-			return fmt.Errorf("wal %s not received", walFile)
+	} else if err := o.collectStreamedWAL(ctx, dstWal); err != nil {
+		return err
+	}
+
+	if missing := o.missingSegments(dstWal); len(missing) > 0 {
+		slog.Warn("WAL segments missing, fetching from primary pg_wal", "segments", missing)
+		if err := o.fetchFromPrimary(ctx, dstWal, missing); err != nil {
+			return err
 		}
-		time.Sleep(2 * time.Second)
+	}
+
+	if err := o.ensureHistory(ctx, dstWal); err != nil {
+		return err
+	}
+
+	// rename last .partial of the backup timeline; in handoff mode the receiver continues it
+	if !o.cfg.Handoff {
+		o.completeLastPartial(dstWal)
+	}
+	return o.checkWAL(ctx, dstWal)
+}
+
+// checkWAL verifies the WAL from START to STOP LSN in dir.
+func (o *Orchestrator) checkWAL(ctx context.Context, dir string) error {
+	st, err := o.verifyWAL(ctx, dir)
+	if err != nil {
+		return fmt.Errorf("WAL verification failed: %w", err)
+	}
+	slog.Info("WAL verified", "start_lsn", o.startLSN, "stop_lsn", o.stopLSN, "tli", o.timeline,
+		"segments", st.Segments, "records", st.Records)
+	return nil
+}
+
+// stepFinalChecks validates resulting replica, fixes permissions and prints summary.
+func (o *Orchestrator) stepFinalChecks(ctx context.Context) error {
+	// essential files
+	need := []string{"PG_VERSION", "postgresql.conf", "pg_hba.conf"}
+	for _, f := range need {
+		if _, err := os.Stat(filepath.Join(o.cfg.ReplicaPGData, f)); err != nil {
+			return fmt.Errorf("missing %s: %w", f, err)
+		}
+	}
+
+	walDir := o.replicaWALDir()
+	files, _ := filepath.Glob(filepath.Join(walDir, fmt.Sprintf("%08X", o.timeline)+strings.Repeat("[0-9A-F]", 16)+"*"))
+	if len(files) == 0 {
+		return fmt.Errorf("no WAL files of timeline %d in %s", o.timeline, walDir)
+	}
+	if o.timeline > 1 {
+		if _, err := os.Stat(filepath.Join(walDir, wal.HistoryFileName(o.timeline))); err != nil {
+			return fmt.Errorf("missing timeline history: %w", err)
+		}
+	}
+
+	// chmod
+	_ = os.Chmod(o.cfg.ReplicaPGData, 0o700)
+	_ = os.Chmod(walDir, 0o700)
+
+	slog.Info("final validation ok", "wal_files", len(files))
+	return nil
+}
+
+// collectStreamedWAL waits for the receiver to pass the STOP LSN, stops it and moves
+// (decompressing if needed) the received WAL into dstWal.
+func (o *Orchestrator) collectStreamedWAL(ctx context.Context, dstWal string) error {
+	walDir := o.recv.Directory()
+	if err := o.recv.WaitFor(ctx, o.stopLSN, 60*time.Second); err != nil {
+		return err
 	}
 
 	// stop receiver
@@ -376,15 +934,25 @@ func (o *Orchestrator) stepWalFinalize(ctx context.Context) error {
 	}
 
 	// move files to replica WAL dir
-	dstWal := o.cfg.ReplicaWALDir
-	if dstWal == "" {
-		dstWal = filepath.Join(o.cfg.ReplicaPGData, "pg_wal")
-	}
-	_ = os.MkdirAll(dstWal, 0o700)
-
+	var raw, stored int64
 	entries, _ := os.ReadDir(walDir)
 	for _, e := range entries {
 		src := filepath.Join(walDir, e.Name())
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		stored += info.Size()
+		if name, method := wal.TrimCompressSuffix(e.Name()); method != "" {
+			n, err := wal.DecompressFile(src, filepath.Join(dstWal, name), o.segSize)
+			if err != nil {
+				return err
+			}
+			raw += n
+			_ = os.Remove(src)
+			continue
+		}
+		raw += info.Size()
 		dst := filepath.Join(dstWal, e.Name())
 		if err := os.Rename(src, dst); err != nil {
 			// likely cross-device link; fallback to copy
@@ -394,43 +962,235 @@ func (o *Orchestrator) stepWalFinalize(ctx context.Context) error {
 			_ = os.Remove(src)
 		}
 	}
+	fmt.Printf("WAL: %s received, %s stored in temp dir (compression %s)\n",
+		progress.FormatBytes(raw), progress.FormatBytes(stored), o.walCompress())
+	return nil
+}
+
+// fetchArchivedWAL forces a segment switch (primary only) and copies every segment from the START to the
+// STOP LSN out of the WAL archive into dstWal, waiting for the archiver where needed.
+func (o *Orchestrator) fetchArchivedWAL(ctx context.Context, dstWal string) error {
+	if o.cfg.FromStandby {
+		// a standby cannot switch segments: the STOP segment is archived (archive_mode = always)
+		// once the primary fills it
+		slog.Info("waiting for the standby to archive the STOP segment", "stop_lsn", o.stopLSN)
+	} else if _, err := o.conn.Exec(ctx, `SELECT pg_switch_wal()`); err != nil {
+		return fmt.Errorf("pg_switch_wal: %w", err)
+	}
+	arch := wal.Archive{Source: o.cfg.WALArchive}
+	first, last := wal.SegmentNo(o.startLSN, o.segSize), wal.SegmentNo(o.stopLSN-1, o.segSize)
+	for segNo := first; segNo <= last; segNo++ {
+		name := wal.SegmentName(o.timeline, segNo, o.segSize)
+		if err := arch.WaitFetch(ctx, name, filepath.Join(dstWal, name), o.segSize, archiveTimeout); err != nil {
+			return fmt.Errorf("fetch %s from WAL archive: %w", name, err)
+		}
+		slog.Debug("WAL segment fetched from archive", "segment", name)
+	}
+	if o.timeline > 1 {
+		name := wal.HistoryFileName(o.timeline)
+		if err := arch.Fetch(ctx, name, filepath.Join(dstWal, name), o.segSize); err != nil {
+			slog.Debug("history file not in archive, will fetch from primary", "file", name, "err", err)
+		}
+	}
+	slog.Info("WAL fetched from archive", "segments", last-first+1)
+
+	if o.cfg.WriteRestoreCommand {
+		conf, err := pgconf.Load(filepath.Join(o.cfg.ReplicaPGData, pgconf.AutoConf))
+		if err != nil {
+			return err
+		}
+		conf.Set("restore_command", arch.RestoreCommand())
+		if err := conf.Save(); err != nil {
+			return err
+		}
+		slog.Info("restore_command written", "file", conf.Path, "restore_command", arch.RestoreCommand())
+	}
+	return nil
+}
+
+// missingSegments lists WAL segments between START and STOP LSN absent from dir;
+// only the STOP segment may still be a .partial.
+func (o *Orchestrator) missingSegments(dir string) []string {
+	var missing []string
+	first, last := wal.SegmentNo(o.startLSN, o.segSize), wal.SegmentNo(o.stopLSN-1, o.segSize)
+	for segNo := first; segNo <= last; segNo++ {
+		name := wal.SegmentName(o.timeline, segNo, o.segSize)
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, name+".partial")); err == nil && segNo == last {
+			continue
+		}
+		missing = append(missing, name)
+	}
+	return missing
+}
+
+// fetchFromPrimary pulls the named segments from the primary pg_wal (through rsyncd, or
+// directly in local mode) and fails if any of them is gone there as well.
+func (o *Orchestrator) fetchFromPrimary(ctx context.Context, dir string, names []string) error {
+	if o.rsyncCfg == nil && !o.local {
+		return fmt.Errorf("WAL segments missing and primary pg_wal is not reachable via rsync: %s", strings.Join(names, ", "))
+	}
+	for _, name := range names {
+		_ = os.Remove(filepath.Join(dir, name))
+		_ = os.Remove(filepath.Join(dir, name+".partial"))
+	}
+	if o.local {
+		if err := o.fetchLocalWAL(dir, names); err != nil {
+			return err
+		}
+	} else if err := o.rsyncCfg.FetchFiles(ctx, walModule, names, dir); err != nil {
+		return err
+	}
+	var gone []string
+	for _, name := range names {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			gone = append(gone, name)
+		}
+	}
+	if len(gone) > 0 {
+		return fmt.Errorf("WAL segments missing and already removed from primary pg_wal: %s", strings.Join(gone, ", "))
+	}
+	slog.Info("WAL segments fetched from primary pg_wal", "segments", names)
+	return nil
+}
+
+// verifyWAL runs wal.Verifier over START..STOP; a missing or damaged segment is fetched
+// once from the primary pg_wal (when rsyncd or a local primary is available) before giving up.
+func (o *Orchestrator) verifyWAL(ctx context.Context, dir string) (wal.VerifyStats, error) {
+	refetched := map[string]bool{}
+	for {
+		v := &wal.Verifier{Dir: dir, Timeline: o.timeline, SegmentSize: o.segSize, SystemID: o.systemID}
+		st, err := v.Verify(o.startLSN, o.stopLSN)
+		var ve *wal.VerifyError
+		if err == nil || (o.rsyncCfg == nil && !o.local) || !errors.As(err, &ve) || refetched[ve.Segment] {
+			return st, err
+		}
+		refetched[ve.Segment] = true
+		slog.Warn("WAL segment failed verification, refetching from primary pg_wal", "err", err)
+		if ferr := o.fetchFromPrimary(ctx, dir, []string{ve.Segment}); ferr != nil {
+			return st, fmt.Errorf("%w (%v)", err, ferr)
+		}
+	}
+}
+
+// stepHandoff keeps streaming WAL into the replica pg_wal until the replica itself connects
+// to the primary (application_name cfg.ReplicaAppName) or cfg.HandoffTimeout passes, so a
+// standby started from the copy can catch up even without a slot.
+func (o *Orchestrator) stepHandoff(ctx context.Context) error {
+	dir := o.replicaWALDir()
+	if err := o.startReceiver(ctx, dir, false); err != nil {
+		return err
+	}
+	if o.cfg.Start {
+		if err := o.startReplica(ctx); err != nil {
+			if stopErr := o.recv.Stop(); stopErr != nil {
+				slog.Warn("receiver stop", "err", stopErr)
+			}
+			o.completeLastPartial(dir)
+			return err
+		}
+	}
+	msg := "handoff: streaming WAL into replica pg_wal, start the replica now"
+	if o.cfg.Start {
+		msg = "handoff: streaming WAL into replica pg_wal until the replica connects"
+	}
+	slog.Info(msg, "application_name", o.cfg.ReplicaAppName, "timeout", o.cfg.HandoffTimeout)
+	waitErr := postgres.WaitReplicationStarted(ctx, o.conn, o.cfg.ReplicaAppName, o.cfg.HandoffTimeout)
+	if err := o.recv.Stop(); err != nil {
+		slog.Warn("receiver stop", "err", err)
+	}
+	if waitErr != nil && ctx.Err() != nil {
+		return waitErr
+	}
 
-	// rename last .partial
-	partials, _ := filepath.Glob(filepath.Join(dstWal, "*.partial"))
+	if waitErr != nil {
+		slog.Warn("handoff deadline passed, replica did not connect", "err", waitErr)
+		o.completeLastPartial(dir)
+		return nil
+	}
+	// the standby streams the current segment itself; PostgreSQL ignores .partial files anyway
+	partials, _ := filepath.Glob(filepath.Join(dir, "*.partial"))
+	for _, p := range partials {
+		_ = os.Remove(p)
+	}
+	slog.Info("handoff complete: replica is streaming from the primary", "application_name", o.cfg.ReplicaAppName)
+	return nil
+}
+
+// completeLastPartial renames the newest .partial segment of the backup timeline to its final name.
+func (o *Orchestrator) completeLastPartial(dir string) {
+	partials, _ := filepath.Glob(filepath.Join(dir, fmt.Sprintf("%08X*.partial", o.timeline)))
 	if len(partials) > 0 {
 		sort.Strings(partials)
 		last := partials[len(partials)-1]
 		_ = os.Rename(last, strings.TrimSuffix(last, ".partial"))
 	}
+}
 
-	return nil
+// checkTimeline aborts if the primary changed timeline (failover) or the copied
+// backup_label/pg_control do not belong to the timeline WAL was streamed from.
+func (o *Orchestrator) checkTimeline(ctx context.Context) error {
+	if err := o.checkBackupFiles(); err != nil {
+		return err
+	}
+	return o.checkPrimaryTimeline(ctx)
 }
 
-// stepFinalChecks validates resulting replica, fixes permissions and prints summary.
-func (o *Orchestrator) stepFinalChecks(ctx context.Context) error {
-	// essential files
-	need := []string{"PG_VERSION", "postgresql.conf", "pg_hba.conf"}
-	for _, f := range need {
-		if _, err := os.Stat(filepath.Join(o.cfg.ReplicaPGData, f)); err != nil {
-			return fmt.Errorf("missing %s: %w", f, err)
-		}
+// checkBackupFiles compares backup_label and pg_control of the replica with the streamed timeline.
+func (o *Orchestrator) checkBackupFiles() error {
+	label, err := postgres.ReadBackupLabel(o.cfg.ReplicaPGData)
+	if err != nil {
+		return err
 	}
+	if label.StartTimeline != o.timeline {
+		return fmt.Errorf("%w: backup_label START TIMELINE %d, WAL streamed from timeline %d", wal.ErrTimelineSwitch, label.StartTimeline, o.timeline)
+	}
+	ctrl, err := postgres.ReadControlFile(o.cfg.ReplicaPGData)
+	if err != nil {
+		return err
+	}
+	if ctrl.SystemID != o.systemID {
+		return fmt.Errorf("pg_control system identifier %d does not match primary %d", ctrl.SystemID, o.systemID)
+	}
+	if ctrl.Timeline > o.timeline {
+		return fmt.Errorf("%w: pg_control timeline %d, WAL streamed from timeline %d", wal.ErrTimelineSwitch, ctrl.Timeline, o.timeline)
+	}
+	return nil
+}
 
-	walDir := o.cfg.ReplicaWALDir
-	if walDir == "" {
-		walDir = filepath.Join(o.cfg.ReplicaPGData, "pg_wal")
+// checkPrimaryTimeline aborts if the primary left the timeline the backup was taken on.
+func (o *Orchestrator) checkPrimaryTimeline(ctx context.Context) error {
+	id, err := postgres.QueryIdentity(ctx, o.conn)
+	if err != nil {
+		return err
 	}
-	files, _ := filepath.Glob(filepath.Join(walDir, "[0-9A-F]*"))
-	if len(files) == 0 {
-		return fmt.Errorf("no WAL files in %s", walDir)
+	if id.Timeline != o.timeline {
+		return fmt.Errorf("%w: primary is on timeline %d, backup taken on %d", wal.ErrTimelineSwitch, id.Timeline, o.timeline)
 	}
+	return nil
+}
 
-	// chmod
-	_ = os.Chmod(o.cfg.ReplicaPGData, 0o700)
-	_ = os.Chmod(walDir, 0o700)
+// ensureHistory makes sure the history file of the backup timeline is in dir (needed when timeline > 1).
+func (o *Orchestrator) ensureHistory(ctx context.Context, dir string) error {
+	if o.timeline <= 1 {
+		return nil
+	}
+	if _, err := os.Stat(filepath.Join(dir, wal.HistoryFileName(o.timeline))); err == nil {
+		return nil
+	}
+	return o.withReplication(ctx, func(rconn *pgconn.PgConn) error {
+		return wal.FetchHistory(ctx, rconn, dir, o.timeline)
+	})
+}
 
-	slog.Info("final validation ok", "wal_files", len(files))
-	return nil
+// replicaWALDir returns the final WAL directory of the replica.
+func (o *Orchestrator) replicaWALDir() string {
+	if o.cfg.ReplicaWALDir != "" {
+		return o.cfg.ReplicaWALDir
+	}
+	return filepath.Join(o.cfg.ReplicaPGData, "pg_wal")
 }
 
 // ### helper: copyFile src->dst preserving perms, used when os.Rename crosses fs boundary.
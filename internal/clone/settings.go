@@ -0,0 +1,57 @@
+package clone
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+
+	"github.com/vbp1/pgclone/internal/pgconf"
+	"github.com/vbp1/pgclone/internal/postgres"
+)
+
+// stepRewriteConf applies cfg.ConfRules to the replica postgresql.auto.conf and prints
+// what changed.
+func (o *Orchestrator) stepRewriteConf() error {
+	conf, err := pgconf.Load(filepath.Join(o.cfg.ReplicaPGData, pgconf.AutoConf))
+	if err != nil {
+		return err
+	}
+	before := conf.Settings()
+	for _, name := range conf.Apply(o.cfg.ConfRules) {
+		slog.Warn("unset: parameter not in postgresql.auto.conf (postgresql.conf is not edited, override it with --set)", "name", name)
+	}
+	changes := pgconf.Diff(before, conf.Settings())
+	if len(changes) == 0 {
+		fmt.Printf("%s: no changes\n", pgconf.AutoConf)
+		return nil
+	}
+	if err := conf.Save(); err != nil {
+		return err
+	}
+	fmt.Printf("%s changes:\n", pgconf.AutoConf)
+	for _, c := range changes {
+		switch {
+		case c.Added:
+			fmt.Printf("  + %s = %s\n", c.Name, displaySetting(c.Name, c.New))
+		case c.Removed:
+			fmt.Printf("  - %s = %s\n", c.Name, displaySetting(c.Name, c.Old))
+		default:
+			fmt.Printf("  ~ %s = %s -> %s\n", c.Name, displaySetting(c.Name, c.Old), displaySetting(c.Name, c.New))
+		}
+	}
+	slog.Info("replica settings rewritten", "file", conf.Path, "changes", len(changes))
+	return nil
+}
+
+// displaySetting quotes a value for the summary, masking passwords in conninfo strings.
+func displaySetting(name, value string) string {
+	if strings.HasSuffix(name, "conninfo") {
+		if ci, err := postgres.ParseConninfo(value); err == nil {
+			if _, ok := ci["password"]; ok {
+				value = ci.With("password", "********").String()
+			}
+		}
+	}
+	return pgconf.Quote(value)
+}
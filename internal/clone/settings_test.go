@@ -0,0 +1,42 @@
+package clone
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vbp1/pgclone/internal/pgconf"
+)
+
+func TestStepRewriteConf(t *testing.T) {
+	dir := t.TempDir()
+	auto := "archive_command = 'cp %p /arch/%f'\nport = '5432'\n"
+	if err := os.WriteFile(filepath.Join(dir, pgconf.AutoConf), []byte(auto), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	o := &Orchestrator{cfg: &Config{ReplicaPGData: dir, ConfRules: []pgconf.Rule{
+		{Name: "port", Value: "5433"},
+		{Name: "archive_command", Unset: true},
+		{Name: "synchronous_standby_names", Unset: true},
+	}}}
+	if err := o.stepRewriteConf(); err != nil {
+		t.Fatal(err)
+	}
+	conf, _ := pgconf.Load(filepath.Join(dir, pgconf.AutoConf))
+	if v, _ := conf.Get("port"); v != "5433" {
+		t.Fatalf("port = %q", v)
+	}
+	if _, ok := conf.Get("archive_command"); ok {
+		t.Fatal("archive_command kept")
+	}
+}
+
+func TestDisplaySettingMasksPassword(t *testing.T) {
+	got := displaySetting("primary_conninfo", "host=db1 user=rep password=secret")
+	if got != "'host=db1 password=******** user=rep'" {
+		t.Fatalf("got %s", got)
+	}
+	if got := displaySetting("work_mem", "64MB"); got != "'64MB'" {
+		t.Fatalf("got %s", got)
+	}
+}
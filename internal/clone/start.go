@@ -0,0 +1,89 @@
+package clone
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/vbp1/pgclone/internal/pgctl"
+	"github.com/vbp1/pgclone/internal/postgres"
+	"github.com/vbp1/pgclone/internal/progress"
+)
+
+// replicaPollInterval is how often the primary is asked about the started replica.
+const replicaPollInterval = 2 * time.Second
+
+// replicaCtl returns the pg_ctl wrapper for the replica data directory of cfg.
+func replicaCtl(cfg *Config) *pgctl.Ctl {
+	return &pgctl.Ctl{Bin: cfg.PGCtl, PGData: cfg.ReplicaPGData, LogFile: cfg.StartLog}
+}
+
+// startReplica starts the replica with pg_ctl and waits until recovery reached a consistent
+// state (pg_ctl -w returns once a hot standby accepts connections or postmaster.pid says standby).
+func (o *Orchestrator) startReplica(ctx context.Context) error {
+	slog.Info("starting replica", "pgdata", o.cfg.ReplicaPGData, "timeout", o.cfg.StartTimeout)
+	if err := replicaCtl(o.cfg).Start(ctx, o.cfg.StartTimeout); err != nil {
+		return fmt.Errorf("%w: %v", ErrReplicaUnhealthy, err)
+	}
+	pf, err := pgctl.ReadPidFile(o.cfg.ReplicaPGData)
+	if err != nil {
+		return fmt.Errorf("%w: read postmaster.pid: %v", ErrReplicaUnhealthy, err)
+	}
+	switch pf.Status {
+	case pgctl.StatusReady, pgctl.StatusStandby:
+	default:
+		return fmt.Errorf("%w: postmaster status %q after start", ErrReplicaUnhealthy, pf.Status)
+	}
+	slog.Info("replica started", "pid", pf.PID, "port", pf.Port, "status", pf.Status)
+	fmt.Printf("Replica started: pid %d, port %d (%s)\n", pf.PID, pf.Port, pf.Status)
+	return nil
+}
+
+// replicaGone reports whether the started replica postmaster has exited (no postmaster.pid).
+func (o *Orchestrator) replicaGone(context.Context) bool {
+	_, err := pgctl.ReadPidFile(o.cfg.ReplicaPGData)
+	return errors.Is(err, os.ErrNotExist)
+}
+
+// waitReplicaStreaming waits until the replica started with cfg streams from the primary
+// (pg_stat_replication, application_name cfg.ReplicaAppName) with at most cfg.MaxReplicaLag
+// bytes left to replay; gone reports that its postmaster exited.
+func (o *Orchestrator) waitReplicaStreaming(ctx context.Context, cfg *Config, gone func(context.Context) bool) error {
+	deadline := time.Now().Add(cfg.StartTimeout)
+	seen := false
+	var lag int64
+	for {
+		if gone(ctx) {
+			return fmt.Errorf("%w: replica stopped, see %s", ErrReplicaUnhealthy, replicaCtl(cfg).Log())
+		}
+		l, ok, err := postgres.ReplicaLag(ctx, o.conn, cfg.ReplicaAppName)
+		if err != nil {
+			return err
+		}
+		if ok {
+			if !seen {
+				slog.Info("replica is streaming", "application_name", cfg.ReplicaAppName, "lag", l)
+			}
+			seen, lag = true, l
+			if lag <= cfg.MaxReplicaLag {
+				fmt.Printf("Replica is streaming, replay lag %s\n", progress.FormatBytes(lag))
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			if !seen {
+				return fmt.Errorf("%w: %s did not appear in pg_stat_replication within %s", ErrReplicaUnhealthy, cfg.ReplicaAppName, cfg.StartTimeout)
+			}
+			return fmt.Errorf("%w: replay lag %s still above %s after %s", ErrReplicaUnhealthy,
+				progress.FormatBytes(lag), progress.FormatBytes(cfg.MaxReplicaLag), cfg.StartTimeout)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(replicaPollInterval):
+		}
+	}
+}
@@ -0,0 +1,393 @@
+package clone
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vbp1/pgclone/internal/postgres"
+)
+
+// replica is the replica side of one clone target: an agent on another host or the same
+// handlers in this process.
+type replica interface {
+	call(ctx context.Context, op string, args, result any) error
+	config() *Config
+	readWAL(ctx context.Context, w io.Writer) error  // tar of the WAL directory
+	writeWAL(ctx context.Context, r io.Reader) error // unpack a tar into the WAL directory
+	close(ctx context.Context)
+}
+
+// localReplica is a target on this host.
+type localReplica struct {
+	a *agent
+}
+
+// newLocalReplica returns a target served in-process; cancel aborts the whole run, as a lost
+// WAL receiver must.
+func newLocalReplica(cancel context.CancelCauseFunc) *localReplica {
+	return &localReplica{a: &agent{o: &Orchestrator{cancel: cancel}}}
+}
+
+func (l *localReplica) call(ctx context.Context, op string, args, result any) error {
+	req := agentRequest{Op: op}
+	if args != nil {
+		data, err := json.Marshal(args)
+		if err != nil {
+			return err
+		}
+		req.Args = data
+	}
+	res, err := l.a.handle(ctx, req)
+	if err != nil {
+		if l.a.o.cfg == nil {
+			return err
+		}
+		return fmt.Errorf("%s: %w", l.a.o.cfg.ReplicaPGData, err)
+	}
+	if result == nil || res == nil {
+		return nil
+	}
+	data, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, result)
+}
+
+func (l *localReplica) config() *Config { return l.a.o.cfg }
+
+func (l *localReplica) readWAL(_ context.Context, w io.Writer) error {
+	return tarDir(l.a.o.replicaWALDir(), w)
+}
+
+func (l *localReplica) writeWAL(_ context.Context, r io.Reader) error {
+	return untarDir(r, l.a.o.replicaWALDir())
+}
+
+func (l *localReplica) close(ctx context.Context) { l.a.close(ctx) }
+
+// targetList returns the clone targets, the one of ReplicaHost/ReplicaPGData first.
+func (o *Orchestrator) targetList() []Target {
+	return append([]Target{{Host: o.cfg.ReplicaHost, PGData: o.cfg.ReplicaPGData}}, o.cfg.Targets...)
+}
+
+// targetConfig is the configuration the replica side of target i works with.
+func targetConfig(cfg *Config, t Target, i int, multi bool) *Config {
+	c := *cfg
+	c.ReplicaHost, c.ReplicaSSHUser, c.RemotePgclone, c.Targets = "", "", "", nil
+	c.ReplicaPGData = t.PGData
+	if multi {
+		c.ReplicaAppName = fmt.Sprintf("%s_%d", cfg.ReplicaAppName, i+1)
+	}
+	return &c
+}
+
+// runTargets is the pipeline of controller mode and of several targets: the primary side
+// (control session, rsyncd, backup start/stop) runs here once, each target copies from
+// rsyncd with its own workers. The first target streams and verifies the WAL, the others
+// get a copy of it and verify it again.
+func (o *Orchestrator) runTargets(ctx context.Context) error {
+	cfg := o.cfg
+	targets := o.targetList()
+	multi := len(targets) > 1
+	if multi && cfg.SlotName != "" && cfg.WriteRecoveryConf {
+		// a slot serves one standby; the others would fail to stream from it
+		return fmt.Errorf("slot %q cannot be primary_slot_name of %d targets", cfg.SlotName, len(targets))
+	}
+	if err := o.resolvePrimary(ctx); err != nil {
+		return err
+	}
+	for i, t := range targets {
+		if t.Host != "" && isLocalHost(o.primaryHost) {
+			return fmt.Errorf("primary host %q is local to the controller; replica host %s needs an address it can reach", o.primaryHost, t.Host)
+		}
+		if t.Host == "" && filepath.IsAbs(o.primaryHost) {
+			return fmt.Errorf("primary host %q is a socket directory; several targets need a network host", o.primaryHost)
+		}
+		tcfg := targetConfig(cfg, t, i, multi)
+		var r replica
+		if t.Host == "" {
+			r = newLocalReplica(o.cancel)
+		} else {
+			rr, err := startAgent(ctx, cfg, t.Host, tcfg)
+			if err != nil {
+				return err
+			}
+			r = rr
+		}
+		o.replicas = append(o.replicas, r)
+		args := initArgs{
+			Config:      tcfg,
+			Conninfo:    o.connString(),
+			PrimaryHost: o.primaryHost,
+			Debug:       slog.Default().Enabled(ctx, slog.LevelDebug),
+		}
+		if multi {
+			args.Label = t.String()
+		}
+		var workers int
+		if err := r.call(ctx, agentInit, args, &workers); err != nil {
+			return err
+		}
+		o.workers = append(o.workers, workers)
+	}
+
+	if err := o.stepWal(ctx); err != nil {
+		return err
+	}
+	if err := o.checkTargetTablespaces(targets); err != nil {
+		return err
+	}
+	if err := o.stepRsyncd(ctx); err != nil {
+		return err
+	}
+	if err := o.startBackup(ctx); err != nil {
+		return err
+	}
+	copyReq := copyArgs{
+		RsyncPort:   o.rsyncPort,
+		RsyncSecret: o.rsyncSecret,
+		Excludes:    o.copyExcludes(),
+		Tablespaces: o.tablespaces,
+	}
+	if err := eachReplica(ctx, o.replicas, func(ctx context.Context, r replica) error {
+		return r.call(ctx, agentCopy, copyReq, nil)
+	}); err != nil {
+		return err
+	}
+	res, ctrl, err := o.stopBackup(ctx)
+	if err != nil {
+		return err
+	}
+	backupReq := backupArgs{
+		Label:         res.Label,
+		TablespaceMap: res.TablespaceMap,
+		Control:       ctrl,
+		StartLSN:      o.startLSN,
+		StopLSN:       o.stopLSN,
+		SystemID:      o.systemID,
+		Timeline:      o.timeline,
+		SegSize:       o.segSize,
+	}
+	if err := eachReplica(ctx, o.replicas, func(ctx context.Context, r replica) error {
+		return r.call(ctx, agentBackup, backupReq, nil)
+	}); err != nil {
+		return err
+	}
+	if err := o.checkPrimaryTimeline(ctx); err != nil {
+		return err
+	}
+	first := o.replicas[0]
+	if err := first.call(ctx, agentWAL, nil, nil); err != nil {
+		return err
+	}
+	for i, r := range o.replicas[1:] {
+		slog.Info("copying WAL to target", "target", targets[i+1])
+		if err := copyWAL(ctx, first, r); err != nil {
+			return fmt.Errorf("copy WAL to %s: %w", targets[i+1], err)
+		}
+		if err := r.call(ctx, agentVerify, nil, nil); err != nil {
+			return err
+		}
+	}
+
+	// one target after the other: what they print stays together
+	for i, r := range o.replicas {
+		if multi {
+			fmt.Printf("\n%s:\n", targets[i])
+		}
+		if err := r.call(ctx, agentFinish, nil, nil); err != nil {
+			return err
+		}
+		if cfg.Start && cfg.WriteRecoveryConf {
+			if err := o.waitReplicaStreaming(ctx, r.config(), targetGone(r)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// targetStream starts the WAL receiver of the first target.
+func (o *Orchestrator) targetStream(ctx context.Context) error {
+	if err := o.replicas[0].call(ctx, agentStream, streamArgs{Slot: o.slot, AppName: o.appName, SegSize: o.segSize}, nil); err != nil {
+		return err
+	}
+	if o.cfg.WALReceiver == ReceiverPgReceivewal {
+		return postgres.WaitReplicationStarted(ctx, o.conn, o.appName, 60*time.Second)
+	}
+	return nil
+}
+
+// checkTargetTablespaces rejects targets on one host when the primary has tablespaces: the
+// copies would share the tablespace directories.
+func (o *Orchestrator) checkTargetTablespaces(targets []Target) error {
+	if len(o.tablespaces) == 0 {
+		return nil
+	}
+	seen := map[string]Target{}
+	for _, t := range targets {
+		if prev, ok := seen[t.Host]; ok {
+			return fmt.Errorf("targets %s and %s would share the directories of %d tablespace(s); put them on different hosts", prev, t, len(o.tablespaces))
+		}
+		seen[t.Host] = t
+	}
+	return nil
+}
+
+// targetGone reports whether the postmaster started on target r has exited.
+func targetGone(r replica) func(context.Context) bool {
+	return func(ctx context.Context) bool {
+		var running bool
+		return r.call(ctx, agentRunning, nil, &running) == nil && !running
+	}
+}
+
+// eachReplica runs fn for all replicas at once; the first failure cancels the others and
+// is returned.
+func eachReplica(ctx context.Context, rs []replica, fn func(context.Context, replica) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	for _, r := range rs {
+		wg.Add(1)
+		go func(r replica) {
+			defer wg.Done()
+			if err := fn(ctx, r); err != nil {
+				once.Do(func() { firstErr = err; cancel() })
+			}
+		}(r)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// copyWAL copies the WAL directory of src into the one of dst.
+func copyWAL(ctx context.Context, src, dst replica) error {
+	pr, pw := io.Pipe()
+	errc := make(chan error, 1)
+	go func() {
+		err := src.readWAL(ctx, pw)
+		_ = pw.CloseWithError(err)
+		errc <- err
+	}()
+	err := dst.writeWAL(ctx, pr)
+	if err == nil {
+		// tar readers may stop before the padding at the end of the archive
+		_, _ = io.Copy(io.Discard, pr)
+	}
+	_ = pr.CloseWithError(errors.New("WAL copy aborted"))
+	if rerr := <-errc; err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// tarDir writes the directories and regular files below dir to w as a tar archive.
+func tarDir(dir string, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dir || !(d.IsDir() || d.Type().IsRegular()) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		hdr.Name = filepath.ToSlash(rel)
+		if d.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// untarDir unpacks the directories and regular files of a tar archive into dir.
+func untarDir(r io.Reader, dir string) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.Clean(filepath.FromSlash(hdr.Name))
+		if name == "." {
+			continue
+		}
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("tar entry %q outside the WAL directory", hdr.Name)
+		}
+		path := filepath.Join(dir, name)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0o700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeFileFrom(path, tr, hdr.FileInfo().Mode().Perm()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// writeFileFrom creates path with the contents read from r.
+func writeFileFrom(path string, r io.Reader, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
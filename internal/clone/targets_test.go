@@ -0,0 +1,116 @@
+package clone
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCopyWALLocal(t *testing.T) {
+	ctx := context.Background()
+	var rs []*localReplica
+	for range 2 {
+		r := newLocalReplica(func(error) {})
+		if err := r.call(ctx, agentInit, initArgs{Config: &Config{ReplicaPGData: t.TempDir()}, Conninfo: "host=db1"}, nil); err != nil {
+			t.Fatal(err)
+		}
+		defer r.close(ctx)
+		rs = append(rs, r)
+	}
+	src := filepath.Join(rs[0].config().ReplicaPGData, "pg_wal")
+	seg := "000000010000000000000003"
+	if err := os.MkdirAll(filepath.Join(src, "archive_status"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, seg), []byte("wal"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "archive_status", seg+".done"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := copyWAL(ctx, rs[0], rs[1]); err != nil {
+		t.Fatal(err)
+	}
+	dst := filepath.Join(rs[1].config().ReplicaPGData, "pg_wal")
+	if data, err := os.ReadFile(filepath.Join(dst, seg)); err != nil || string(data) != "wal" {
+		t.Fatalf("copied segment: %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "archive_status", seg+".done")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLocalReplicaLocked(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	args := initArgs{Config: &Config{ReplicaPGData: dir}, Conninfo: "host=db1"}
+	r1, r2 := newLocalReplica(func(error) {}), newLocalReplica(func(error) {})
+	if err := r1.call(ctx, agentInit, args, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer r1.close(ctx)
+	if err := r2.call(ctx, agentInit, args, nil); err == nil {
+		t.Fatal("second target on the same PGDATA was not refused")
+	}
+}
+
+func TestTargetConfig(t *testing.T) {
+	cfg := &Config{ReplicaHost: "r1", ReplicaPGData: "/data", ReplicaAppName: "pgclone_replica",
+		Targets: []Target{{Host: "r2", PGData: "/data2"}}}
+	c := targetConfig(cfg, cfg.Targets[0], 1, true)
+	if c.ReplicaHost != "" || c.Targets != nil || c.ReplicaPGData != "/data2" || c.ReplicaAppName != "pgclone_replica_2" {
+		t.Fatalf("got %+v", c)
+	}
+	if c := targetConfig(cfg, Target{Host: "r1", PGData: "/data"}, 0, false); c.ReplicaAppName != "pgclone_replica" {
+		t.Fatalf("single target renamed: %s", c.ReplicaAppName)
+	}
+	cfg.SlotName, cfg.WriteRecoveryConf = "replica_slot", true
+	if c := targetConfig(cfg, cfg.Targets[0], 1, true); c.SlotName != "replica_slot" {
+		t.Fatalf("slot not passed on: %q", c.SlotName)
+	}
+	if err := (&Orchestrator{cfg: cfg}).runTargets(context.Background()); err == nil || !strings.Contains(err.Error(), "replica_slot") {
+		t.Fatalf("shared primary_slot_name not refused: %v", err)
+	}
+	if s := (Target{Host: "r2", PGData: "/data2"}).String(); s != "r2:/data2" {
+		t.Fatalf("String = %q", s)
+	}
+}
+
+func TestRsyncdMaxConn(t *testing.T) {
+	ctx := context.Background()
+	o := &Orchestrator{cfg: &Config{}}
+	for range 3 {
+		r := newLocalReplica(func(error) {})
+		var workers int
+		if err := r.call(ctx, agentInit, initArgs{Config: &Config{ReplicaPGData: t.TempDir(), Parallel: 8}, Conninfo: "host=db1"}, &workers); err != nil {
+			t.Fatal(err)
+		}
+		defer r.close(ctx)
+		o.workers = append(o.workers, workers)
+	}
+	if n := o.rsyncdMaxConn(); n != 3*(8+4) {
+		t.Fatalf("max connections %d for three targets of 8 workers", n)
+	}
+	if n := (&Orchestrator{cfg: &Config{Parallel: 2}}).rsyncdMaxConn(); n != 16 {
+		t.Fatalf("max connections %d below the floor", n)
+	}
+}
+
+func TestEachReplicaFirstError(t *testing.T) {
+	boom := errors.New("boom")
+	rs := []replica{&localReplica{}, &localReplica{}, &localReplica{}}
+	err := eachReplica(context.Background(), rs, func(ctx context.Context, r replica) error {
+		if r == rs[1] {
+			return boom
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if !errors.Is(err, boom) {
+		t.Fatalf("got %v", err)
+	}
+}
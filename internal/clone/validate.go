@@ -0,0 +1,239 @@
+package clone
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/vbp1/pgclone/internal/pgctl"
+)
+
+// validateIndexSample is how many btree indexes per database bt_index_check looks at.
+const validateIndexSample = 20
+
+// validateStopTimeout bounds the shutdown of the validation boot.
+const validateStopTimeout = 5 * time.Minute
+
+// bootOptions are the postgres options of the validation boot: a hot standby on a private
+// socket that neither connects upstream, fetches nor archives WAL. Replay pauses at the
+// recovery target (name = value) the clone is started with later, so the boot does not run
+// past it; shutdown as the target action would stop the server before the checks run.
+func bootOptions(port int, sockDir, target, value string) []string {
+	opts := []string{"-p " + strconv.Itoa(port), "-k '" + sockDir + "'", "-c listen_addresses=''",
+		"-c hot_standby=on", "-c archive_mode=off", "-c ssl=off"}
+	for _, name := range standbyOnly {
+		opts = append(opts, "-c "+name+"=''")
+	}
+	opts = append(opts, "-c restore_command=''")
+	for _, name := range recoveryTargets {
+		if name != target {
+			opts = append(opts, "-c "+name+"=''")
+		}
+	}
+	return append(opts, "-c "+target+"='"+value+"'", "-c recovery_target_action=pause")
+}
+
+// bootTarget is the recovery target of the validation boot: the one writeDetachConf writes
+// for a detached clone, otherwise the backup end.
+func (o *Orchestrator) bootTarget() (name, value string) {
+	switch {
+	case !o.cfg.Detach:
+		return "recovery_target_lsn", o.stopLSN.String()
+	case o.cfg.RecoveryTargetLSN != "":
+		return "recovery_target_lsn", o.cfg.RecoveryTargetLSN
+	case o.cfg.RecoveryTargetTime != "":
+		return "recovery_target_time", o.cfg.RecoveryTargetTime
+	default:
+		return "recovery_target", "immediate"
+	}
+}
+
+// bootSignal puts the data directory into standby mode for the validation boot; restore
+// removes standby.signal again unless it was there before. recovery.signal is left alone,
+// standby.signal takes precedence over it.
+func bootSignal(pgdata string) (restore func() error, err error) {
+	signal := filepath.Join(pgdata, "standby.signal")
+	if _, err := os.Stat(signal); err == nil {
+		return func() error { return nil }, nil
+	}
+	if err := os.WriteFile(signal, nil, 0o600); err != nil {
+		return nil, err
+	}
+	return func() error { return os.Remove(signal) }, nil
+}
+
+// freePort returns a TCP port nothing listens on right now.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = l.Close() }()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// validateBoot starts the clone once as a throwaway hot standby, waits for a consistent
+// state, runs the SQL checks and shuts it down cleanly again.
+func (o *Orchestrator) validateBoot(ctx context.Context) (err error) {
+	port, err := freePort()
+	if err != nil {
+		return fmt.Errorf("validation boot: %w", err)
+	}
+	dir, err := os.MkdirTemp("", "pgclone_boot_*")
+	if err != nil {
+		return fmt.Errorf("validation boot: %w", err)
+	}
+	ctl := &pgctl.Ctl{Bin: o.cfg.PGCtl, PGData: o.cfg.ReplicaPGData, LogFile: filepath.Join(dir, "postgres.log")}
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("%w: %v (server log kept in %s)", ErrValidateBoot, err, ctl.Log())
+			return
+		}
+		_ = os.RemoveAll(dir)
+	}()
+
+	restore, err := bootSignal(o.cfg.ReplicaPGData)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rerr := restore(); rerr != nil && err == nil {
+			err = rerr
+		}
+	}()
+
+	start := time.Now()
+	target, value := o.bootTarget()
+	slog.Info("validation boot", "port", port, "socket_dir", dir, target, value)
+	if err := ctl.Start(ctx, o.cfg.StartTimeout, bootOptions(port, dir, target, value)...); err != nil {
+		// pg_ctl -w gives up waiting but leaves a slow postmaster running
+		if _, perr := pgctl.ReadPidFile(o.cfg.ReplicaPGData); perr == nil {
+			_ = ctl.Stop(context.WithoutCancel(ctx), "immediate", validateStopTimeout)
+		}
+		return err
+	}
+	defer func() {
+		if serr := ctl.Stop(context.WithoutCancel(ctx), "fast", validateStopTimeout); serr != nil && err == nil {
+			err = serr
+		}
+	}()
+
+	connString := fmt.Sprintf("host='%s' port=%d sslmode=disable application_name=pgclone_validate", dir, port)
+	if len(o.cfg.ValidateSQL) > 0 {
+		err = runChecks(ctx, connString, o.cfg.ValidateSQL)
+	} else {
+		err = defaultChecks(ctx, connString)
+	}
+	if err != nil {
+		return err
+	}
+	slog.Info("validation boot passed", "elapsed_sec", time.Since(start).Seconds())
+	fmt.Printf("Validation boot passed in %s\n", time.Since(start).Round(time.Second))
+	return nil
+}
+
+// connectDB connects to database db of the validation boot.
+func connectDB(ctx context.Context, connString, db string) (*pgx.Conn, error) {
+	cfg, err := pgx.ParseConfig(connString)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Database = db
+	return pgx.ConnectConfig(ctx, cfg)
+}
+
+// runChecks executes every statement in the postgres database; any error fails the check.
+func runChecks(ctx context.Context, connString string, checks []string) error {
+	conn, err := connectDB(ctx, connString, "postgres")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close(context.WithoutCancel(ctx)) }()
+	for _, sql := range checks {
+		if _, err := conn.Exec(ctx, sql); err != nil {
+			return fmt.Errorf("check %q: %w", sql, err)
+		}
+		slog.Info("validation check passed", "sql", sql)
+	}
+	return nil
+}
+
+// defaultChecks counts the databases and, in every database with amcheck installed,
+// runs bt_index_check on a random sample of btree indexes.
+func defaultChecks(ctx context.Context, connString string) error {
+	conn, err := connectDB(ctx, connString, "postgres")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close(context.WithoutCancel(ctx)) }()
+	rows, err := conn.Query(ctx, `SELECT datname FROM pg_database WHERE datallowconn ORDER BY datname`)
+	if err != nil {
+		return fmt.Errorf("list databases: %w", err)
+	}
+	dbs, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return fmt.Errorf("list databases: %w", err)
+	}
+	fmt.Printf("Validation boot: %d databases\n", len(dbs))
+
+	checked := 0
+	for _, db := range dbs {
+		n, err := amcheckSample(ctx, connString, db)
+		if err != nil {
+			return err
+		}
+		checked += n
+	}
+	if checked > 0 {
+		fmt.Printf("Validation boot: amcheck verified %d indexes\n", checked)
+	}
+	return nil
+}
+
+// amcheckSample runs bt_index_check on up to validateIndexSample indexes of db;
+// it returns 0 without error when amcheck is not installed there.
+func amcheckSample(ctx context.Context, connString, db string) (int, error) {
+	conn, err := connectDB(ctx, connString, db)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = conn.Close(context.WithoutCancel(ctx)) }()
+
+	var schema *string
+	if err := conn.QueryRow(ctx, `SELECT n.nspname FROM pg_extension e JOIN pg_namespace n ON n.oid = e.extnamespace
+                                   WHERE e.extname = 'amcheck'`).Scan(&schema); err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return 0, fmt.Errorf("%s: look up amcheck: %w", db, err)
+	}
+	if schema == nil {
+		slog.Debug("amcheck not installed, skipping index checks", "db", db)
+		return 0, nil
+	}
+	rows, err := conn.Query(ctx, `SELECT c.oid::regclass::text
+                                    FROM pg_index i JOIN pg_class c ON c.oid = i.indexrelid
+                                    JOIN pg_am a ON a.oid = c.relam
+                                   WHERE a.amname = 'btree' AND i.indisvalid AND c.relpersistence = 'p'
+                                   ORDER BY random() LIMIT $1`, validateIndexSample)
+	if err != nil {
+		return 0, fmt.Errorf("%s: sample indexes: %w", db, err)
+	}
+	indexes, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return 0, fmt.Errorf("%s: sample indexes: %w", db, err)
+	}
+	check := "SELECT " + pgx.Identifier{*schema, "bt_index_check"}.Sanitize() + "($1::regclass)"
+	for _, idx := range indexes {
+		if _, err := conn.Exec(ctx, check, idx); err != nil {
+			return 0, fmt.Errorf("%s: bt_index_check(%s): %w", db, idx, err)
+		}
+	}
+	slog.Info("amcheck passed", "db", db, "indexes", len(indexes))
+	return len(indexes), nil
+}
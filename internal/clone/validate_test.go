@@ -0,0 +1,71 @@
+package clone
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestBootSignal(t *testing.T) {
+	dir := t.TempDir()
+	signal := filepath.Join(dir, "standby.signal")
+	if err := os.WriteFile(filepath.Join(dir, "recovery.signal"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	restore, err := bootSignal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(signal); err != nil {
+		t.Fatalf("standby.signal not created: %v", err)
+	}
+	if err := restore(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(signal); !os.IsNotExist(err) {
+		t.Fatalf("standby.signal not removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "recovery.signal")); err != nil {
+		t.Fatalf("recovery.signal touched: %v", err)
+	}
+
+	// an existing standby.signal survives
+	_ = os.WriteFile(signal, nil, 0o600)
+	restore, _ = bootSignal(dir)
+	_ = restore()
+	if _, err := os.Stat(signal); err != nil {
+		t.Fatalf("existing standby.signal removed: %v", err)
+	}
+}
+
+func TestBootOptions(t *testing.T) {
+	opts := bootOptions(6543, "/tmp/pgclone_boot_1", "recovery_target_lsn", "0/3000100")
+	for _, want := range []string{"-p 6543", "-k '/tmp/pgclone_boot_1'", "-c listen_addresses=''", "-c primary_conninfo=''",
+		"-c recovery_target_time=''", "-c recovery_target_lsn='0/3000100'", "-c recovery_target_action=pause"} {
+		if !slices.Contains(opts, want) {
+			t.Errorf("missing %q in %v", want, opts)
+		}
+	}
+	if slices.Contains(opts, "-c recovery_target_lsn=''") {
+		t.Errorf("target cleared: %v", opts)
+	}
+}
+
+func TestBootTarget(t *testing.T) {
+	o := &Orchestrator{cfg: &Config{}, stopLSN: 0x3000100}
+	for _, tc := range []struct {
+		cfg         Config
+		name, value string
+	}{
+		{Config{WriteRecoveryConf: true}, "recovery_target_lsn", "0/3000100"},
+		{Config{Detach: true}, "recovery_target", "immediate"},
+		{Config{Detach: true, RecoveryTargetLSN: "0/5000000"}, "recovery_target_lsn", "0/5000000"},
+		{Config{Detach: true, RecoveryTargetTime: "2026-10-18 12:00:00+00"}, "recovery_target_time", "2026-10-18 12:00:00+00"},
+	} {
+		*o.cfg = tc.cfg
+		if name, value := o.bootTarget(); name != tc.name || value != tc.value {
+			t.Errorf("%+v: got %s = %s, want %s = %s", tc.cfg, name, value, tc.name, tc.value)
+		}
+	}
+}
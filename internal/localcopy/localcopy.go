@@ -0,0 +1,308 @@
+// Package localcopy copies PostgreSQL files between directories of the same host with
+// parallel Go workers; it replaces rsync when primary and replica share a machine.
+// File data is cloned (FICLONE reflink) or copied in the kernel (copy_file_range) where
+// the filesystem supports it.
+package localcopy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/vbp1/pgclone/internal/progress"
+	"github.com/vbp1/pgclone/internal/rsync"
+)
+
+// Excluded reports whether rel (slash-separated, relative to the copy root) matches one of
+// the rsync-style patterns: "/x" is anchored at the root, "x/" only matches directories
+// (and symlinks, so a symlinked pg_wal is not followed into the primary), anything else
+// is a glob on the base name or, if it contains a slash, on the whole path.
+func Excluded(rel string, isDir bool, patterns []string) bool {
+	base := filepath.Base(rel)
+	for _, p := range patterns {
+		if strings.HasSuffix(p, "/") {
+			if !isDir {
+				continue
+			}
+			p = strings.TrimSuffix(p, "/")
+		}
+		var ok bool
+		switch {
+		case strings.HasPrefix(p, "/"):
+			ok, _ = filepath.Match(p[1:], rel)
+		case strings.Contains(p, "/"):
+			ok, _ = filepath.Match(p, rel)
+		default:
+			ok, _ = filepath.Match(p, base)
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// List returns the regular files below dir that are not excluded, like rsync --list-only.
+func List(dir string, excludes []string) ([]rsync.FileInfo, error) {
+	var out []rsync.FileInfo
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && path != dir {
+				return nil // dropped while listing
+			}
+			return err
+		}
+		if path == dir {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, path)
+		if Excluded(rel, d.IsDir() || d.Type()&fs.ModeSymlink != 0, excludes) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		out = append(out, rsync.FileInfo{Size: info.Size(), Path: rel})
+		return nil
+	})
+	return out, err
+}
+
+// Tree mirrors src into dst like rsync -a --delete: directories, symlinks and regular files
+// are recreated, entries of dst missing in src are removed; excluded paths are neither
+// copied nor deleted.
+func Tree(ctx context.Context, src, dst string, excludes []string) error {
+	seen := map[string]bool{}
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && path != src {
+				return nil
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		isLink := d.Type()&fs.ModeSymlink != 0
+		if rel != "." && Excluded(rel, d.IsDir() || isLink, excludes) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		seen[rel] = true
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			if err := os.MkdirAll(target, info.Mode().Perm()); err != nil {
+				return err
+			}
+			return os.Chmod(target, info.Mode().Perm())
+		case isLink:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if cur, err := os.Readlink(target); err == nil && cur == link {
+				return nil
+			}
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			_, err := CopyFile(path, target)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return prune(dst, seen, excludes)
+}
+
+// prune removes entries of dst that are not in seen.
+func prune(dst string, seen map[string]bool, excludes []string) error {
+	return filepath.WalkDir(dst, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dst, path)
+		if rel == "." {
+			return nil
+		}
+		if Excluded(rel, d.IsDir() || d.Type()&fs.ModeSymlink != 0, excludes) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if seen[rel] {
+			return nil
+		}
+		slog.Debug("deleting extraneous", "path", path)
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
+
+// CopyFile copies the regular file src to dst (replacing it) and keeps mode and mtime.
+// It returns the number of bytes copied; a src that vanished copies nothing and is not
+// an error, WAL replay recreates or removes it.
+func CopyFile(src, dst string) (int64, error) {
+	in, err := os.Open(src)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = in.Close() }()
+	info, err := in.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o700); err != nil {
+		return 0, err
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return 0, err
+	}
+	n, err := copyData(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return n, err
+	}
+	return n, os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
+
+// copyData tries a reflink, then copy_file_range, then a plain read/write copy.
+func copyData(out, in *os.File) (int64, error) {
+	if err := unix.IoctlFileClone(int(out.Fd()), int(in.Fd())); err == nil {
+		info, err := out.Stat()
+		if err != nil {
+			return 0, err
+		}
+		return info.Size(), nil
+	}
+	var n int64
+	for {
+		c, err := unix.CopyFileRange(int(in.Fd()), nil, int(out.Fd()), nil, 1<<30, 0)
+		if err != nil {
+			if n == 0 && (errors.Is(err, unix.EXDEV) || errors.Is(err, unix.ENOSYS) ||
+				errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.EINVAL)) {
+				return io.Copy(out, in)
+			}
+			return n, err
+		}
+		if c == 0 {
+			return n, nil
+		}
+		n += int64(c)
+	}
+}
+
+// RunParallel copies files (relative to srcDir) into dstDir with workers goroutines, using
+// the same size buckets as rsync.RunParallel. The returned Stats carry file and byte counts.
+func RunParallel(ctx context.Context, name, srcDir, dstDir string, workers int, files []rsync.FileInfo, showBar bool, progressMode string, progressInterval int) (rsync.Stats, error) {
+	if workers <= 0 {
+		workers = runtime.NumCPU() / 2
+		if workers == 0 {
+			workers = 1
+		}
+	}
+	var total int64
+	for _, f := range files {
+		total += f.Size
+	}
+	slog.Info("copying module", "module", name, "files", len(files))
+	tracker := progress.New(name, total, showBar, progressMode, progressInterval)
+	defer tracker.Abort()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+		copied   atomic.Int64
+		bytes    atomic.Int64
+	)
+	start := time.Now()
+	for _, bucket := range rsync.Distribute(files, workers) {
+		if len(bucket) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(bucket []rsync.FileInfo) {
+			defer wg.Done()
+			for _, f := range bucket {
+				if ctx.Err() != nil {
+					return
+				}
+				n, err := CopyFile(filepath.Join(srcDir, f.Path), filepath.Join(dstDir, f.Path))
+				if err != nil {
+					once.Do(func() { firstErr = err; cancel() })
+					return
+				}
+				copied.Add(1)
+				bytes.Add(n)
+				tracker.Add(n)
+			}
+		}(bucket)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return rsync.Stats{}, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return rsync.Stats{}, err
+	}
+	tracker.Finish()
+	slog.Debug("module copied", "module", name, "elapsed_sec", time.Since(start).Seconds())
+	return rsync.Stats{
+		NumFiles:             int64(len(files)),
+		RegFiles:             int64(len(files)),
+		RegTransferred:       copied.Load(),
+		TotalFileSize:        total,
+		TotalTransferredSize: bytes.Load(),
+	}, nil
+}
@@ -0,0 +1,103 @@
+package localcopy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/vbp1/pgclone/internal/rsync"
+)
+
+func write(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExcluded(t *testing.T) {
+	patterns := []string{"pg_wal/", "/backup_label", "pgsql_tmp*", "postmaster.pid", "base/"}
+	for _, c := range []struct {
+		rel   string
+		isDir bool
+		want  bool
+	}{
+		{"pg_wal", true, true},
+		{"pg_wal", false, false},
+		{"backup_label", false, true},
+		{"sub/backup_label", false, false},
+		{"base/1/pgsql_tmp123", false, true},
+		{"postmaster.pid", false, true},
+		{"base", true, true},
+		{"global/pg_control", false, false},
+	} {
+		if got := Excluded(c.rel, c.isDir, patterns); got != c.want {
+			t.Errorf("Excluded(%q, %v) = %v, want %v", c.rel, c.isDir, got, c.want)
+		}
+	}
+}
+
+func TestTreeAndRunParallel(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	write(t, filepath.Join(src, "PG_VERSION"), "17\n")
+	write(t, filepath.Join(src, "global", "pg_control"), "control")
+	write(t, filepath.Join(src, "pg_wal", "000000010000000000000001"), "wal")
+	write(t, filepath.Join(src, "base", "1", "1259"), "pg_class")
+	write(t, filepath.Join(src, "base", "1", "pgsql_tmp1"), "tmp")
+	write(t, filepath.Join(src, "base", "5", "16384"), "table data")
+	if err := os.Symlink("/spc/one", filepath.Join(src, "pg_tblspc_16400")); err != nil {
+		t.Fatal(err)
+	}
+	// stale replica content
+	write(t, filepath.Join(dst, "old_file"), "stale")
+	write(t, filepath.Join(dst, "pg_wal", "keep"), "replica wal")
+
+	excludes := []string{"pg_wal/", "base/"}
+	if err := Tree(context.Background(), src, dst, excludes); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dst, "global", "pg_control")); string(data) != "control" {
+		t.Fatalf("pg_control = %q", data)
+	}
+	if link, _ := os.Readlink(filepath.Join(dst, "pg_tblspc_16400")); link != "/spc/one" {
+		t.Fatalf("symlink = %q", link)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "old_file")); !os.IsNotExist(err) {
+		t.Fatal("extraneous file not deleted")
+	}
+	if _, err := os.Stat(filepath.Join(dst, "pg_wal", "keep")); err != nil {
+		t.Fatal("excluded directory touched")
+	}
+	if _, err := os.Stat(filepath.Join(dst, "base")); !os.IsNotExist(err) {
+		t.Fatal("excluded base copied")
+	}
+
+	files, err := List(filepath.Join(src, "base"), []string{"pgsql_tmp*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, f := range files {
+		paths = append(paths, f.Path)
+	}
+	sort.Strings(paths)
+	if len(paths) != 2 || paths[0] != "1/1259" || paths[1] != "5/16384" {
+		t.Fatalf("list = %v", paths)
+	}
+	files = append(files, rsync.FileInfo{Path: "9/gone", Size: 10}) // dropped meanwhile
+	st, err := RunParallel(context.Background(), "base", filepath.Join(src, "base"), filepath.Join(dst, "base"), 2, files, false, "none", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.RegTransferred != 3 || st.TotalTransferredSize != int64(len("pg_class")+len("table data")) {
+		t.Fatalf("stats %+v", st)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dst, "base", "5", "16384")); string(data) != "table data" {
+		t.Fatalf("copied %q", data)
+	}
+}
@@ -0,0 +1,156 @@
+// Package patroni reads cluster topology from the Patroni REST API.
+package patroni
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Member roles and states reported by GET /cluster.
+const (
+	RoleLeader        = "leader"
+	RoleStandbyLeader = "standby_leader"
+	RoleReplica       = "replica"
+	RoleSyncStandby   = "sync_standby"
+	RoleQuorum        = "quorum_standby"
+
+	StateRunning   = "running"
+	StateStreaming = "streaming"
+)
+
+// ErrNoMember is returned when no cluster member fits the request.
+var ErrNoMember = errors.New("no suitable Patroni member")
+
+// Member is one node of the cluster as listed by GET /cluster.
+type Member struct {
+	Name     string          `json:"name"`
+	Role     string          `json:"role"`
+	State    string          `json:"state"`
+	APIURL   string          `json:"api_url"`
+	Host     string          `json:"host"`
+	Port     int             `json:"port"`
+	Timeline int             `json:"timeline"`
+	RawLag   json.RawMessage `json:"lag"` // bytes, or "unknown"
+}
+
+// Lag returns the replication lag in bytes; ok is false when Patroni does not know it.
+func (m Member) Lag() (lag int64, ok bool) {
+	if err := json.Unmarshal(m.RawLag, &lag); err != nil {
+		return 0, false
+	}
+	return lag, true
+}
+
+// Healthy reports whether PostgreSQL on the member is up (replicas: streaming or replaying).
+func (m Member) Healthy() bool { return m.State == StateRunning || m.State == StateStreaming }
+
+// Cluster is the GET /cluster response.
+type Cluster struct {
+	Scope   string   `json:"scope"`
+	Members []Member `json:"members"`
+}
+
+// Leader returns the running leader (primary) of the cluster; in a standby cluster, which
+// has no leader, the standby leader (in recovery, replicating from the upstream cluster).
+func (c *Cluster) Leader() (Member, error) {
+	for _, role := range []string{RoleLeader, RoleStandbyLeader} {
+		for _, m := range c.Members {
+			if m.Role == role {
+				if !m.Healthy() {
+					return m, fmt.Errorf("%w: %s %s is %s", ErrNoMember, role, m.Name, m.State)
+				}
+				return m, nil
+			}
+		}
+	}
+	return Member{}, fmt.Errorf("%w: cluster %q has no leader", ErrNoMember, c.Scope)
+}
+
+// Replica returns the healthy replica with the smallest known lag not above maxLag bytes
+// (maxLag < 0 = no limit); replicas on another timeline than the leader are skipped.
+func (c *Cluster) Replica(maxLag int64) (Member, error) {
+	leaderTLI := 0
+	if l, err := c.Leader(); err == nil {
+		leaderTLI = l.Timeline
+	}
+	var best Member
+	bestLag := int64(-1)
+	for _, m := range c.Members {
+		switch m.Role {
+		case RoleReplica, RoleSyncStandby, RoleQuorum:
+		default:
+			continue
+		}
+		lag, ok := m.Lag()
+		if !m.Healthy() || !ok || (maxLag >= 0 && lag > maxLag) || (leaderTLI != 0 && m.Timeline != leaderTLI) {
+			continue
+		}
+		if bestLag < 0 || lag < bestLag {
+			best, bestLag = m, lag
+		}
+	}
+	if bestLag < 0 {
+		return Member{}, fmt.Errorf("%w: cluster %q has no healthy replica within lag limit", ErrNoMember, c.Scope)
+	}
+	return best, nil
+}
+
+// Client queries the REST API of any of the given Patroni nodes.
+type Client struct {
+	URLs []string     // base URLs, e.g. http://node1:8008
+	HTTP *http.Client // nil = client with Timeout
+}
+
+// Timeout is the per-request timeout of the default HTTP client.
+const Timeout = 10 * time.Second
+
+// Cluster fetches GET /cluster from the first URL that answers.
+func (c *Client) Cluster(ctx context.Context) (*Cluster, error) {
+	if len(c.URLs) == 0 {
+		return nil, errors.New("patroni: no URL")
+	}
+	var errs []error
+	for _, base := range c.URLs {
+		cl, err := c.cluster(ctx, strings.TrimRight(base, "/")+"/cluster")
+		if err == nil {
+			return cl, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		errs = append(errs, err)
+	}
+	return nil, fmt.Errorf("patroni: %w", errors.Join(errs...))
+}
+
+func (c *Client) cluster(ctx context.Context, url string) (*Cluster, error) {
+	hc := c.HTTP
+	if hc == nil {
+		hc = &http.Client{Timeout: Timeout}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	var cl Cluster
+	if err := json.NewDecoder(resp.Body).Decode(&cl); err != nil {
+		return nil, fmt.Errorf("GET %s: %w", url, err)
+	}
+	if len(cl.Members) == 0 {
+		return nil, fmt.Errorf("GET %s: no members", url)
+	}
+	return &cl, nil
+}
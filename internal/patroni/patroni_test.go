@@ -0,0 +1,110 @@
+package patroni
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const clusterJSON = `{
+  "scope": "main",
+  "members": [
+    {"name": "pg1", "role": "replica", "state": "streaming", "api_url": "http://10.0.0.1:8008/patroni",
+     "host": "10.0.0.1", "port": 5432, "timeline": 4, "lag": 4096},
+    {"name": "pg2", "role": "leader", "state": "running", "api_url": "http://10.0.0.2:8008/patroni",
+     "host": "10.0.0.2", "port": 5433, "timeline": 4},
+    {"name": "pg3", "role": "sync_standby", "state": "streaming", "api_url": "http://10.0.0.3:8008/patroni",
+     "host": "10.0.0.3", "port": 5432, "timeline": 4, "lag": 0},
+    {"name": "pg4", "role": "replica", "state": "stopped", "host": "10.0.0.4", "port": 5432, "lag": "unknown"}
+  ]
+}`
+
+func TestClusterFallsBackToNextURL(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no", http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/cluster" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(clusterJSON))
+	}))
+	defer up.Close()
+
+	c := &Client{URLs: []string{down.URL, up.URL + "/"}}
+	cl, err := c.Cluster(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	leader, err := cl.Leader()
+	if err != nil || leader.Host != "10.0.0.2" || leader.Port != 5433 {
+		t.Fatalf("leader %+v, %v", leader, err)
+	}
+	replica, err := cl.Replica(-1)
+	if err != nil || replica.Name != "pg3" {
+		t.Fatalf("replica %+v, %v", replica, err)
+	}
+	if _, ok := cl.Members[3].Lag(); ok {
+		t.Fatal("unknown lag reported as known")
+	}
+}
+
+func TestReplicaLagLimit(t *testing.T) {
+	cl := &Cluster{Scope: "main", Members: []Member{
+		{Name: "pg1", Role: RoleLeader, State: StateRunning, Timeline: 2},
+		{Name: "pg2", Role: RoleReplica, State: StateStreaming, Timeline: 2, RawLag: []byte("9000")},
+		{Name: "pg3", Role: RoleReplica, State: StateStreaming, Timeline: 1, RawLag: []byte("0")},
+	}}
+	if m, err := cl.Replica(10000); err != nil || m.Name != "pg2" {
+		t.Fatalf("got %+v, %v", m, err)
+	}
+	if _, err := cl.Replica(1000); !errors.Is(err, ErrNoMember) {
+		t.Fatalf("expected ErrNoMember, got %v", err)
+	}
+}
+
+func TestClusterAllDown(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	if _, err := (&Client{URLs: []string{srv.URL}}).Cluster(context.Background()); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+const standbyClusterJSON = `{
+  "scope": "dr",
+  "members": [
+    {"name": "dr1", "role": "standby_leader", "state": "streaming", "api_url": "http://10.1.0.1:8008/patroni",
+     "host": "10.1.0.1", "port": 5432, "timeline": 4},
+    {"name": "dr2", "role": "replica", "state": "streaming", "api_url": "http://10.1.0.2:8008/patroni",
+     "host": "10.1.0.2", "port": 5432, "timeline": 4, "lag": 0}
+  ]
+}`
+
+func TestStandbyClusterLeader(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(standbyClusterJSON))
+	}))
+	defer srv.Close()
+	cl, err := (&Client{URLs: []string{srv.URL}}).Cluster(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	leader, err := cl.Leader()
+	if err != nil || leader.Name != "dr1" || leader.Role != RoleStandbyLeader {
+		t.Fatalf("leader %+v, %v", leader, err)
+	}
+	if replica, err := cl.Replica(-1); err != nil || replica.Name != "dr2" {
+		t.Fatalf("replica %+v, %v", replica, err)
+	}
+
+	// a leader, if there is one, wins over a standby leader
+	cl.Members = append(cl.Members, Member{Name: "pg1", Role: RoleLeader, State: StateRunning})
+	if leader, err := cl.Leader(); err != nil || leader.Name != "pg1" {
+		t.Fatalf("leader %+v, %v", leader, err)
+	}
+}
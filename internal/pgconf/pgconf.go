@@ -0,0 +1,180 @@
+// Package pgconf edits PostgreSQL configuration files such as postgresql.auto.conf.
+package pgconf
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// AutoConf is the file name ALTER SYSTEM writes to inside PGDATA.
+const AutoConf = "postgresql.auto.conf"
+
+// File is a configuration file in "name = 'value'" format. Comments, unknown lines and
+// the order of settings are preserved; Set and Unset only touch the affected lines.
+type File struct {
+	Path  string
+	lines []line
+}
+
+type line struct {
+	raw   string
+	key   string // lower-case parameter name; empty for comments/blank lines
+	value string
+}
+
+// Load reads path; a missing file yields an empty File that Save will create.
+func Load(path string) (*File, error) {
+	f := &File{Path: path}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		f.lines = append(f.lines, parseLine(sc.Text()))
+	}
+	return f, sc.Err()
+}
+
+// Get returns the value of name (the last occurrence wins, as in PostgreSQL).
+func (f *File) Get(name string) (string, bool) {
+	key := strings.ToLower(name)
+	for i := len(f.lines) - 1; i >= 0; i-- {
+		if f.lines[i].key == key {
+			return f.lines[i].value, true
+		}
+	}
+	return "", false
+}
+
+// Settings returns all parameters with their effective values.
+func (f *File) Settings() map[string]string {
+	m := make(map[string]string)
+	for _, l := range f.lines {
+		if l.key != "" {
+			m[l.key] = l.value
+		}
+	}
+	return m
+}
+
+// Set assigns value to name, replacing earlier assignments in place.
+func (f *File) Set(name, value string) {
+	key := strings.ToLower(name)
+	l := line{raw: fmt.Sprintf("%s = %s", name, Quote(value)), key: key, value: value}
+	out := f.lines[:0]
+	replaced := false
+	for _, old := range f.lines {
+		if old.key == key {
+			if !replaced {
+				out = append(out, l)
+				replaced = true
+			}
+			continue
+		}
+		out = append(out, old)
+	}
+	if !replaced {
+		out = append(out, l)
+	}
+	f.lines = out
+}
+
+// Unset removes every assignment of name and reports whether there was one.
+func (f *File) Unset(name string) bool {
+	key := strings.ToLower(name)
+	out := f.lines[:0]
+	found := false
+	for _, l := range f.lines {
+		if l.key == key {
+			found = true
+			continue
+		}
+		out = append(out, l)
+	}
+	f.lines = out
+	return found
+}
+
+// Save atomically writes the file with mode 0600.
+func (f *File) Save() error {
+	var buf bytes.Buffer
+	if len(f.lines) == 0 || !strings.HasPrefix(f.lines[0].raw, "#") {
+		if filepath.Base(f.Path) == AutoConf {
+			buf.WriteString("# Do not edit this file manually!\n# It will be overwritten by the ALTER SYSTEM command.\n")
+		}
+	}
+	for _, l := range f.lines {
+		buf.WriteString(l.raw)
+		buf.WriteByte('\n')
+	}
+	tmp := f.Path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.Path)
+}
+
+// Quote renders value as a configuration file string literal.
+func Quote(value string) string {
+	r := strings.NewReplacer(`\`, `\\`, `'`, `''`)
+	return "'" + r.Replace(value) + "'"
+}
+
+// parseLine splits "name = value # comment"; anything unparsable is kept verbatim.
+func parseLine(raw string) line {
+	s := strings.TrimSpace(raw)
+	if s == "" || s[0] == '#' {
+		return line{raw: raw}
+	}
+	i := 0
+	for i < len(s) && isNameChar(s[i]) {
+		i++
+	}
+	if i == 0 {
+		return line{raw: raw}
+	}
+	name := s[:i]
+	rest := strings.TrimSpace(s[i:])
+	rest = strings.TrimSpace(strings.TrimPrefix(rest, "="))
+	value, ok := parseValue(rest)
+	if !ok {
+		return line{raw: raw}
+	}
+	return line{raw: raw, key: strings.ToLower(name), value: value}
+}
+
+// parseValue decodes a quoted or bare value, dropping a trailing comment.
+func parseValue(s string) (string, bool) {
+	if s == "" || s[0] != '\'' {
+		v, _, _ := strings.Cut(s, "#")
+		return strings.TrimSpace(v), true
+	}
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s):
+			i++
+			b.WriteByte(s[i])
+		case c == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			i++
+			b.WriteByte('\'')
+		case c == '\'':
+			return b.String(), true
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", false
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || c == '.' || c == '-' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,75 @@
+package pgconf
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadSetUnsetSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), AutoConf)
+	content := `# Do not edit this file manually!
+# It will be overwritten by the ALTER SYSTEM command.
+work_mem = '64MB'
+primary_conninfo = 'host=old user=rep password=''x'''
+Max_Connections=200 # comment
+work_mem = '128MB'
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := f.Get("work_mem"); v != "128MB" {
+		t.Fatalf("work_mem = %q", v)
+	}
+	if v, _ := f.Get("primary_conninfo"); v != "host=old user=rep password='x'" {
+		t.Fatalf("primary_conninfo = %q", v)
+	}
+	if v, _ := f.Get("max_connections"); v != "200" {
+		t.Fatalf("max_connections = %q", v)
+	}
+
+	f.Set("work_mem", "1GB")
+	f.Set("restore_command", `cp /arch/%f "%p"`)
+	if !f.Unset("primary_conninfo") || f.Unset("missing") {
+		t.Fatal("unexpected Unset result")
+	}
+	if err := f.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := os.ReadFile(path)
+	got := string(data)
+	if strings.Count(got, "work_mem") != 1 || strings.Contains(got, "primary_conninfo") {
+		t.Fatalf("unexpected file:\n%s", got)
+	}
+	if !strings.HasPrefix(got, "# Do not edit") || strings.Count(got, "# Do not edit") != 1 {
+		t.Fatalf("header not preserved:\n%s", got)
+	}
+	g, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := g.Get("restore_command"); v != `cp /arch/%f "%p"` {
+		t.Fatalf("restore_command = %q", v)
+	}
+}
+
+func TestLoadMissing(t *testing.T) {
+	f, err := Load(filepath.Join(t.TempDir(), AutoConf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Set("a", "it's")
+	if err := f.Save(); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(f.Path)
+	if !strings.Contains(string(data), "a = 'it''s'") {
+		t.Fatalf("unexpected file:\n%s", data)
+	}
+}
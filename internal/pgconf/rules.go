@@ -0,0 +1,132 @@
+package pgconf
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Rule changes one parameter: assigns Value, or removes every assignment when Unset.
+type Rule struct {
+	Name  string
+	Value string
+	Unset bool
+}
+
+// ParseSet parses a "name=value" flag; a value in single quotes is decoded as in postgresql.conf.
+func ParseSet(s string) (Rule, error) {
+	name, value, ok := strings.Cut(s, "=")
+	name = strings.TrimSpace(name)
+	if !ok || !validName(name) {
+		return Rule{}, fmt.Errorf("invalid setting %q (want name=value)", s)
+	}
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "'") {
+		v, ok := parseValue(value)
+		if !ok {
+			return Rule{}, fmt.Errorf("invalid setting %q: unterminated quote", s)
+		}
+		value = v
+	}
+	return Rule{Name: name, Value: value}, nil
+}
+
+// ParseUnset validates a parameter name for removal.
+func ParseUnset(name string) (Rule, error) {
+	name = strings.TrimSpace(name)
+	if !validName(name) {
+		return Rule{}, fmt.Errorf("invalid parameter name %q", name)
+	}
+	return Rule{Name: name, Unset: true}, nil
+}
+
+// LoadRules reads a rules file: "name = value" lines in postgresql.conf syntax set a
+// parameter, "unset name" lines remove it; blank lines and # comments are ignored.
+func LoadRules(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		s := strings.TrimSpace(sc.Text())
+		if s == "" || s[0] == '#' {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(s, "unset "); ok {
+			name, _, _ := strings.Cut(rest, "#")
+			r, err := ParseUnset(name)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+			}
+			rules = append(rules, r)
+			continue
+		}
+		l := parseLine(s)
+		if l.key == "" {
+			return nil, fmt.Errorf("%s:%d: cannot parse %q", path, n, s)
+		}
+		name := s[:len(l.key)]
+		rules = append(rules, Rule{Name: name, Value: l.value})
+	}
+	return rules, sc.Err()
+}
+
+// Apply applies rules in order and returns the names of unset rules that matched nothing.
+func (f *File) Apply(rules []Rule) (missing []string) {
+	for _, r := range rules {
+		if !r.Unset {
+			f.Set(r.Name, r.Value)
+			continue
+		}
+		if !f.Unset(r.Name) {
+			missing = append(missing, r.Name)
+		}
+	}
+	return missing
+}
+
+// Change is the difference of one parameter between two Settings snapshots.
+type Change struct {
+	Name     string
+	Old, New string
+	Added    bool // not set before
+	Removed  bool // not set after
+}
+
+// Diff compares two Settings snapshots; the result is sorted by name.
+func Diff(before, after map[string]string) []Change {
+	var out []Change
+	for name, old := range before {
+		v, ok := after[name]
+		switch {
+		case !ok:
+			out = append(out, Change{Name: name, Old: old, Removed: true})
+		case v != old:
+			out = append(out, Change{Name: name, Old: old, New: v})
+		}
+	}
+	for name, v := range after {
+		if _, ok := before[name]; !ok {
+			out = append(out, Change{Name: name, New: v, Added: true})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isNameChar(name[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,75 @@
+package pgconf
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseSet(t *testing.T) {
+	for in, want := range map[string]Rule{
+		"port=5433":                          {Name: "port", Value: "5433"},
+		"archive_command = ''":               {Name: "archive_command", Value: ""},
+		"restore_command='cp ''%f'' \"%p\"'": {Name: "restore_command", Value: `cp '%f' "%p"`},
+		"archive_command=/bin/true # x":      {Name: "archive_command", Value: "/bin/true # x"},
+	} {
+		got, err := ParseSet(in)
+		if err != nil || got != want {
+			t.Errorf("ParseSet(%q) = %+v, %v; want %+v", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"port", "=5", "bad name=1", "x='open"} {
+		if _, err := ParseSet(in); err == nil {
+			t.Errorf("ParseSet(%q): expected error", in)
+		}
+	}
+}
+
+func TestRulesApplyDiff(t *testing.T) {
+	dir := t.TempDir()
+	rulesPath := filepath.Join(dir, "replica.rules")
+	rules := "# replica overrides\nshared_buffers = '8GB'\nunset synchronous_standby_names # primary only\nunset missing\nPort 5433\n"
+	if err := os.WriteFile(rulesPath, []byte(rules), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadRules(rulesPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Rule{
+		{Name: "shared_buffers", Value: "8GB"},
+		{Name: "synchronous_standby_names", Unset: true},
+		{Name: "missing", Unset: true},
+		{Name: "Port", Value: "5433"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("rules %+v, want %+v", got, want)
+	}
+
+	f, err := Load(filepath.Join(dir, AutoConf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Set("shared_buffers", "32GB")
+	f.Set("synchronous_standby_names", "*")
+	f.Set("work_mem", "64MB")
+	before := f.Settings()
+	if missing := f.Apply(got); !reflect.DeepEqual(missing, []string{"missing"}) {
+		t.Fatalf("missing = %v", missing)
+	}
+	diff := Diff(before, f.Settings())
+	wantDiff := []Change{
+		{Name: "port", New: "5433", Added: true},
+		{Name: "shared_buffers", Old: "32GB", New: "8GB"},
+		{Name: "synchronous_standby_names", Old: "*", Removed: true},
+	}
+	if !reflect.DeepEqual(diff, wantDiff) {
+		t.Fatalf("diff %+v, want %+v", diff, wantDiff)
+	}
+
+	_ = os.WriteFile(rulesPath, []byte("= oops\n"), 0o600)
+	if _, err := LoadRules(rulesPath); err == nil {
+		t.Fatal("expected parse error")
+	}
+}
@@ -0,0 +1,123 @@
+// Package pgctl starts and stops a local PostgreSQL data directory through pg_ctl.
+package pgctl
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Postmaster states written to line 8 of postmaster.pid.
+const (
+	StatusStarting = "starting"
+	StatusStopping = "stopping"
+	StatusReady    = "ready"
+	StatusStandby  = "standby"
+)
+
+// Ctl runs pg_ctl for one data directory.
+type Ctl struct {
+	Bin     string // pg_ctl binary; empty = "pg_ctl" from PATH
+	PGData  string
+	LogFile string // server log (pg_ctl -l); empty = PGData/pg_ctl.log
+}
+
+// Start runs pg_ctl start and waits up to timeout until the server accepts connections
+// (or, in a standby without hot_standby, reached a consistent state). options are passed
+// to postgres through -o.
+func (c *Ctl) Start(ctx context.Context, timeout time.Duration, options ...string) error {
+	logFile := c.Log()
+	args := []string{"start", "-D", c.PGData, "-l", logFile, "-w", "-t", strconv.Itoa(int(timeout.Seconds()))}
+	if len(options) > 0 {
+		args = append(args, "-o", strings.Join(options, " "))
+	}
+	if err := c.run(ctx, args...); err != nil {
+		return fmt.Errorf("%w (server log: %s)", err, logFile)
+	}
+	return nil
+}
+
+// Log returns the server log file Start writes to.
+func (c *Ctl) Log() string {
+	if c.LogFile != "" {
+		return c.LogFile
+	}
+	return filepath.Join(c.PGData, "pg_ctl.log")
+}
+
+// Stop runs pg_ctl stop with mode smart|fast|immediate and waits up to timeout.
+func (c *Ctl) Stop(ctx context.Context, mode string, timeout time.Duration) error {
+	return c.run(ctx, "stop", "-D", c.PGData, "-m", mode, "-w", "-t", strconv.Itoa(int(timeout.Seconds())))
+}
+
+// Promote runs pg_ctl promote and waits until the server left recovery.
+func (c *Ctl) Promote(ctx context.Context, timeout time.Duration) error {
+	return c.run(ctx, "promote", "-D", c.PGData, "-w", "-t", strconv.Itoa(int(timeout.Seconds())))
+}
+
+func (c *Ctl) run(ctx context.Context, args ...string) error {
+	bin := c.Bin
+	if bin == "" {
+		bin = "pg_ctl"
+	}
+	cmd := exec.CommandContext(ctx, bin, args...)
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	slog.Debug("pg_ctl", "args", args)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("pg_ctl %s: %w: %s", args[0], err, strings.TrimSpace(out.String()))
+	}
+	return nil
+}
+
+// PidFile holds the fields of postmaster.pid pgclone uses.
+type PidFile struct {
+	PID        int
+	Port       int
+	SocketDir  string
+	ListenAddr string // first listen_addresses entry; empty = no TCP
+	Status     string // StatusReady, StatusStandby, ... (empty on old servers)
+}
+
+// ReadPidFile reads PGData/postmaster.pid; os.ErrNotExist means the server is not running.
+func ReadPidFile(pgdata string) (PidFile, error) {
+	data, err := os.ReadFile(filepath.Join(pgdata, "postmaster.pid"))
+	if err != nil {
+		return PidFile{}, err
+	}
+	return ParsePidFile(data)
+}
+
+// ParsePidFile decodes postmaster.pid (pid, datadir, start time, port, socket dir,
+// listen address, shmem key, status).
+func ParsePidFile(data []byte) (PidFile, error) {
+	lines := strings.Split(string(data), "\n")
+	if len(lines) < 4 {
+		return PidFile{}, fmt.Errorf("postmaster.pid: %d lines", len(lines))
+	}
+	var pf PidFile
+	var err error
+	if pf.PID, err = strconv.Atoi(strings.TrimSpace(lines[0])); err != nil {
+		return PidFile{}, fmt.Errorf("postmaster.pid: bad pid %q", lines[0])
+	}
+	if pf.Port, err = strconv.Atoi(strings.TrimSpace(lines[3])); err != nil {
+		return PidFile{}, fmt.Errorf("postmaster.pid: bad port %q", lines[3])
+	}
+	if len(lines) > 4 {
+		pf.SocketDir = strings.TrimSpace(lines[4])
+	}
+	if len(lines) > 5 {
+		pf.ListenAddr = strings.TrimSpace(lines[5])
+	}
+	if len(lines) > 7 {
+		pf.Status = strings.TrimSpace(lines[7])
+	}
+	return pf, nil
+}
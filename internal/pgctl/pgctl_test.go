@@ -0,0 +1,50 @@
+package pgctl
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePidFile(t *testing.T) {
+	data := "4242\n/data/replica\n1760000000\n5433\n/var/run/postgresql\n*\n  5433001     32768\nstandby  \n"
+	pf, err := ParsePidFile([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pf.PID != 4242 || pf.Port != 5433 || pf.SocketDir != "/var/run/postgresql" || pf.ListenAddr != "*" || pf.Status != StatusStandby {
+		t.Fatalf("unexpected %+v", pf)
+	}
+	if _, err := ParsePidFile([]byte("x\n")); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestStartArgs(t *testing.T) {
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "args")
+	bin := filepath.Join(dir, "pg_ctl")
+	script := "#!/bin/sh\nprintf '%s\\n' \"$@\" > " + argsFile + "\n"
+	if err := os.WriteFile(bin, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	c := &Ctl{Bin: bin, PGData: "/data/replica"}
+	if err := c.Start(context.Background(), 90*time.Second, "-p 6000", "-c hot_standby=on"); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(argsFile)
+	want := "start\n-D\n/data/replica\n-l\n/data/replica/pg_ctl.log\n-w\n-t\n90\n-o\n-p 6000 -c hot_standby=on\n"
+	if string(got) != want {
+		t.Fatalf("args:\n%s\nwant:\n%s", got, want)
+	}
+
+	fail := filepath.Join(dir, "fail")
+	_ = os.WriteFile(fail, []byte("#!/bin/sh\necho 'could not start server' >&2\nexit 1\n"), 0o755)
+	err := (&Ctl{Bin: fail, PGData: dir, LogFile: "/tmp/x.log"}).Start(context.Background(), time.Second)
+	if err == nil || !strings.Contains(err.Error(), "could not start server") || !strings.Contains(err.Error(), "/tmp/x.log") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+package postgres
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+)
+
+// MinVersion is the oldest server_version_num pgclone can clone.
+const MinVersion = 120000
+
+// BackupResult is what stopping a non-exclusive backup returns.
+type BackupResult struct {
+	StopLSN       LSN
+	Label         []byte // backup_label contents
+	TablespaceMap []byte // tablespace_map contents; empty without tablespaces
+}
+
+// BackupAPI hides the differences of the non-exclusive backup functions and data directory
+// layout between server versions. Start and Stop must run on the same session.
+type BackupAPI interface {
+	Version() int
+	Start(ctx context.Context, q queryer, label string, fast bool) (LSN, error)
+	Stop(ctx context.Context, q queryer, waitArchive bool) (BackupResult, error)
+	// Excludes lists rsync patterns (relative to PGDATA) that must not be copied.
+	Excludes() []string
+	// NativeBaseBackup reports whether BASE_BACKUP understands the option-list syntax.
+	NativeBaseBackup() bool
+}
+
+// ServerVersion returns server_version_num.
+func ServerVersion(ctx context.Context, q queryer) (int, error) {
+	var s string
+	if err := q.QueryRow(ctx, "SHOW server_version_num").Scan(&s); err != nil {
+		return 0, fmt.Errorf("query version: %w", err)
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("parse version_num %s: %w", s, err)
+	}
+	return v, nil
+}
+
+// NewBackupAPI returns the BackupAPI for server_version_num version.
+func NewBackupAPI(version int) (BackupAPI, error) {
+	switch {
+	case version < MinVersion:
+		return nil, fmt.Errorf("PostgreSQL >= 12 required, server reports %d", version)
+	case version < 150000:
+		return backupAPI12{version}, nil
+	default:
+		return backupAPI15{version}, nil
+	}
+}
+
+// commonExcludes are skipped by pg_basebackup on every supported version (basebackup.c).
+var commonExcludes = []string{
+	"pg_wal/", "postmaster.pid", "postmaster.opts", "pg_replslot/", "pg_dynshmem/", "pg_notify/",
+	"pg_serial/", "pg_snapshots/", "pg_stat_tmp/", "pg_subtrans/", "pgsql_tmp*", "pg_internal.init",
+}
+
+// backupAPI15 uses pg_backup_start/pg_backup_stop (PostgreSQL 15+).
+type backupAPI15 struct{ version int }
+
+func (a backupAPI15) Version() int { return a.version }
+
+func (a backupAPI15) Start(ctx context.Context, q queryer, label string, fast bool) (LSN, error) {
+	return startBackup(ctx, q, "pg_backup_start", `SELECT pg_backup_start($1, $2)::text`, label, fast)
+}
+
+func (a backupAPI15) Stop(ctx context.Context, q queryer, waitArchive bool) (BackupResult, error) {
+	return stopBackup(ctx, q, "pg_backup_stop", `FROM pg_backup_stop($1)`, waitArchive)
+}
+
+func (a backupAPI15) Excludes() []string { return commonExcludes }
+
+func (a backupAPI15) NativeBaseBackup() bool { return true }
+
+// backupAPI12 uses the non-exclusive pg_start_backup/pg_stop_backup (PostgreSQL 12-14).
+type backupAPI12 struct{ version int }
+
+func (a backupAPI12) Version() int { return a.version }
+
+func (a backupAPI12) Start(ctx context.Context, q queryer, label string, fast bool) (LSN, error) {
+	return startBackup(ctx, q, "pg_start_backup", `SELECT pg_start_backup($1, $2, false)::text`, label, fast)
+}
+
+func (a backupAPI12) Stop(ctx context.Context, q queryer, waitArchive bool) (BackupResult, error) {
+	return stopBackup(ctx, q, "pg_stop_backup", `FROM pg_stop_backup(false, $1)`, waitArchive)
+}
+
+// Excludes also skips backup_label/tablespace_map a concurrent exclusive backup may have left
+// in PGDATA; the replica gets the files returned by pg_stop_backup instead.
+func (a backupAPI12) Excludes() []string {
+	return append(append([]string(nil), commonExcludes...), "/backup_label", "/tablespace_map")
+}
+
+func (a backupAPI12) NativeBaseBackup() bool { return false }
+
+func startBackup(ctx context.Context, q queryer, fn, sql, label string, fast bool) (LSN, error) {
+	var s string
+	if err := q.QueryRow(ctx, sql, label, fast).Scan(&s); err != nil {
+		return 0, fmt.Errorf("%s: %w", fn, err)
+	}
+	lsn, err := ParseLSN(s)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", fn, err)
+	}
+	return lsn, nil
+}
+
+func stopBackup(ctx context.Context, q queryer, fn, from string, waitArchive bool) (BackupResult, error) {
+	var lsn, label, spcmap string
+	err := q.QueryRow(ctx, `SELECT lsn::text,
+          translate(encode(labelfile::bytea,  'base64'), E'\n', ''),
+          coalesce(translate(encode(spcmapfile::bytea, 'base64'), E'\n', ''), '')
+          `+from, waitArchive).Scan(&lsn, &label, &spcmap)
+	if err != nil {
+		return BackupResult{}, fmt.Errorf("%s: %w", fn, err)
+	}
+	var res BackupResult
+	if res.StopLSN, err = ParseLSN(lsn); err != nil {
+		return BackupResult{}, fmt.Errorf("%s: %w", fn, err)
+	}
+	if res.Label, err = base64.StdEncoding.DecodeString(label); err != nil {
+		return BackupResult{}, fmt.Errorf("%s: decode labelfile: %w", fn, err)
+	}
+	if res.TablespaceMap, err = base64.StdEncoding.DecodeString(spcmap); err != nil {
+		return BackupResult{}, fmt.Errorf("%s: decode spcmapfile: %w", fn, err)
+	}
+	return res, nil
+}
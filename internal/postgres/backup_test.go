@@ -0,0 +1,59 @@
+package postgres
+
+import (
+	"context"
+	"encoding/base64"
+	"slices"
+	"testing"
+
+	pgxmock "github.com/pashagolub/pgxmock/v3"
+)
+
+func TestNewBackupAPI(t *testing.T) {
+	if _, err := NewBackupAPI(110022); err == nil {
+		t.Fatal("expected error for PostgreSQL 11")
+	}
+	old, err := NewBackupAPI(140010)
+	if err != nil || old.NativeBaseBackup() || !slices.Contains(old.Excludes(), "/backup_label") {
+		t.Fatalf("14: %+v, %v", old, err)
+	}
+	cur, err := NewBackupAPI(170002)
+	if err != nil || !cur.NativeBaseBackup() || slices.Contains(cur.Excludes(), "/backup_label") {
+		t.Fatalf("17: %+v, %v", cur, err)
+	}
+}
+
+func TestBackupAPIStartStop(t *testing.T) {
+	label := "START WAL LOCATION: 0/2000028 (file 000000010000000000000002)\n"
+	for _, c := range []struct {
+		version     int
+		start, stop string
+	}{
+		{130005, `pg_start_backup\(\$1, \$2, false\)`, `pg_stop_backup\(false, \$1\)`},
+		{160001, `pg_backup_start\(\$1, \$2\)`, `pg_backup_stop\(\$1\)`},
+	} {
+		mock, err := pgxmock.NewPool()
+		if err != nil {
+			t.Fatalf("mock: %v", err)
+		}
+		api, _ := NewBackupAPI(c.version)
+		mock.ExpectQuery(c.start).WithArgs("pgclone", true).
+			WillReturnRows(pgxmock.NewRows([]string{"lsn"}).AddRow("0/2000028"))
+		mock.ExpectQuery(c.stop).WithArgs(true).
+			WillReturnRows(pgxmock.NewRows([]string{"lsn", "label", "map"}).
+				AddRow("0/2000100", base64.StdEncoding.EncodeToString([]byte(label)), ""))
+
+		start, err := api.Start(context.Background(), mock, "pgclone", true)
+		if err != nil || start != 0x2000028 {
+			t.Fatalf("%d start: %s, %v", c.version, start, err)
+		}
+		res, err := api.Stop(context.Background(), mock, true)
+		if err != nil || res.StopLSN != 0x2000100 || string(res.Label) != label || len(res.TablespaceMap) != 0 {
+			t.Fatalf("%d stop: %+v, %v", c.version, res, err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+		mock.Close()
+	}
+}
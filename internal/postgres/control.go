@@ -0,0 +1,102 @@
+package postgres
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ControlData holds the leading, version-stable fields of global/pg_control.
+type ControlData struct {
+	SystemID     uint64
+	Version      uint32 // pg_control_version
+	Checkpoint   LSN    // latest checkpoint location
+	Redo         LSN    // REDO location of that checkpoint
+	Timeline     uint32 // ThisTimeLineID of the checkpoint
+	PrevTimeline uint32
+}
+
+// ParseControlFile decodes pg_control (native little-endian layout of ControlFileData).
+func ParseControlFile(data []byte) (ControlData, error) {
+	if len(data) < 56 {
+		return ControlData{}, fmt.Errorf("pg_control too short (%d bytes)", len(data))
+	}
+	le := binary.LittleEndian
+	c := ControlData{
+		SystemID:     le.Uint64(data[0:8]),
+		Version:      le.Uint32(data[8:12]),
+		Checkpoint:   LSN(le.Uint64(data[32:40])),
+		Redo:         LSN(le.Uint64(data[40:48])),
+		Timeline:     le.Uint32(data[48:52]),
+		PrevTimeline: le.Uint32(data[52:56]),
+	}
+	if c.Version < 1000 || c.Version > 9999 {
+		return ControlData{}, fmt.Errorf("unsupported pg_control version %d", c.Version)
+	}
+	return c, nil
+}
+
+// ReadControlFile reads and decodes pgdata/global/pg_control.
+func ReadControlFile(pgdata string) (ControlData, error) {
+	data, err := os.ReadFile(filepath.Join(pgdata, "global", "pg_control"))
+	if err != nil {
+		return ControlData{}, err
+	}
+	return ParseControlFile(data)
+}
+
+// BackupLabel holds the fields of backup_label pgclone relies on.
+type BackupLabel struct {
+	StartWAL      LSN
+	Checkpoint    LSN
+	StartTimeline uint32
+	Label         string
+}
+
+// ParseBackupLabel parses the contents of backup_label as returned by pg_backup_stop.
+func ParseBackupLabel(data []byte) (BackupLabel, error) {
+	var b BackupLabel
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		key, val, ok := strings.Cut(sc.Text(), ": ")
+		if !ok {
+			continue
+		}
+		var err error
+		switch key {
+		case "START WAL LOCATION":
+			// "0/2000028 (file 000000010000000000000002)"
+			lsn, _, _ := strings.Cut(val, " ")
+			b.StartWAL, err = ParseLSN(lsn)
+		case "CHECKPOINT LOCATION":
+			b.Checkpoint, err = ParseLSN(val)
+		case "START TIMELINE":
+			var t uint64
+			t, err = strconv.ParseUint(strings.TrimSpace(val), 10, 32)
+			b.StartTimeline = uint32(t)
+		case "LABEL":
+			b.Label = val
+		}
+		if err != nil {
+			return BackupLabel{}, fmt.Errorf("backup_label %s: %w", key, err)
+		}
+	}
+	if b.StartWAL == 0 || b.StartTimeline == 0 {
+		return BackupLabel{}, fmt.Errorf("backup_label: START WAL LOCATION or START TIMELINE missing")
+	}
+	return b, nil
+}
+
+// ReadBackupLabel reads and parses pgdata/backup_label.
+func ReadBackupLabel(pgdata string) (BackupLabel, error) {
+	data, err := os.ReadFile(filepath.Join(pgdata, "backup_label"))
+	if err != nil {
+		return BackupLabel{}, err
+	}
+	return ParseBackupLabel(data)
+}
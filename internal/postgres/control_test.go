@@ -0,0 +1,48 @@
+package postgres
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestParseBackupLabel(t *testing.T) {
+	label := `START WAL LOCATION: 0/5000028 (file 000000030000000000000005)
+CHECKPOINT LOCATION: 0/5000060
+BACKUP METHOD: streamed
+BACKUP FROM: primary
+START TIME: 2024-05-01 10:00:00 UTC
+LABEL: pgclone
+START TIMELINE: 3
+`
+	b, err := ParseBackupLabel([]byte(label))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.StartWAL != 0x5000028 || b.Checkpoint != 0x5000060 || b.StartTimeline != 3 || b.Label != "pgclone" {
+		t.Fatalf("unexpected label %+v", b)
+	}
+	if _, err := ParseBackupLabel([]byte("LABEL: x\n")); err == nil {
+		t.Fatal("expected error for incomplete label")
+	}
+}
+
+func TestParseControlFile(t *testing.T) {
+	data := make([]byte, 296)
+	le := binary.LittleEndian
+	le.PutUint64(data[0:], 7354221346001234567)
+	le.PutUint32(data[8:], 1300)
+	le.PutUint64(data[32:], 0x5000060)
+	le.PutUint64(data[40:], 0x5000028)
+	le.PutUint32(data[48:], 3)
+	le.PutUint32(data[52:], 2)
+	c, err := ParseControlFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.SystemID != 7354221346001234567 || c.Checkpoint != 0x5000060 || c.Redo != 0x5000028 || c.Timeline != 3 || c.PrevTimeline != 2 {
+		t.Fatalf("unexpected control data %+v", c)
+	}
+	if _, err := ParseControlFile(data[:20]); err == nil {
+		t.Fatal("expected error for short file")
+	}
+}
@@ -0,0 +1,18 @@
+package postgres
+
+import "testing"
+
+func TestParseLSN(t *testing.T) {
+	lsn, err := ParseLSN("16/B374D848")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if uint64(lsn) != 0x16B374D848 || lsn.String() != "16/B374D848" {
+		t.Fatalf("unexpected lsn %d (%s)", uint64(lsn), lsn)
+	}
+	for _, bad := range []string{"", "16", "G/0", "1/2/3"} {
+		if _, err := ParseLSN(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
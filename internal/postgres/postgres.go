@@ -115,3 +115,68 @@ func PrettyBytes(b int64) string {
 	suffix := []string{"kB", "MB", "GB", "TB", "PB", "EB"}[exp]
 	return fmt.Sprintf("%.2f %s", value, suffix)
 }
+
+// WALSegmentSize returns wal_segment_size in bytes.
+func WALSegmentSize(ctx context.Context, q queryer) (uint64, error) {
+	var n int64
+	if err := q.QueryRow(ctx, `SELECT pg_size_bytes(current_setting('wal_segment_size'))`).Scan(&n); err != nil {
+		return 0, fmt.Errorf("query wal_segment_size: %w", err)
+	}
+	return uint64(n), nil
+}
+
+// DataDirectory returns the server's data_directory (superuser or pg_read_all_settings).
+func DataDirectory(ctx context.Context, q queryer) (string, error) {
+	var dir string
+	if err := q.QueryRow(ctx, `SHOW data_directory`).Scan(&dir); err != nil {
+		return "", fmt.Errorf("query data_directory: %w", err)
+	}
+	return dir, nil
+}
+
+// ArchiveMode returns the server's archive_mode (off, on or always).
+func ArchiveMode(ctx context.Context, q queryer) (string, error) {
+	var mode string
+	if err := q.QueryRow(ctx, `SHOW archive_mode`).Scan(&mode); err != nil {
+		return "", fmt.Errorf("query archive_mode: %w", err)
+	}
+	return mode, nil
+}
+
+// Identity identifies a running server instance.
+type Identity struct {
+	SystemID   uint64
+	Timeline   uint32    // current WAL timeline; in recovery the one WAL is received (or replayed) on
+	StartTime  time.Time // pg_postmaster_start_time()
+	InRecovery bool
+}
+
+// QueryIdentity returns the system identifier, timeline, postmaster start time and recovery state.
+func QueryIdentity(ctx context.Context, q queryer) (Identity, error) {
+	var id Identity
+	var sysID int64
+	var walFile *string
+	var recoveryTLI *int32
+	// pg_walfile_name is not allowed during recovery
+	err := q.QueryRow(ctx, `SELECT system_identifier, pg_postmaster_start_time(), pg_is_in_recovery(),
+                                   CASE WHEN NOT pg_is_in_recovery() THEN pg_walfile_name(pg_current_wal_lsn()) END,
+                                   CASE WHEN pg_is_in_recovery() THEN coalesce(
+                                       (SELECT received_tli FROM pg_stat_wal_receiver),
+                                       (SELECT timeline_id FROM pg_control_checkpoint())) END
+                              FROM pg_control_system()`).Scan(&sysID, &id.StartTime, &id.InRecovery, &walFile, &recoveryTLI)
+	if err != nil {
+		return Identity{}, fmt.Errorf("query system identity: %w", err)
+	}
+	id.SystemID = uint64(sysID)
+	if walFile != nil {
+		t, err := strconv.ParseUint((*walFile)[:min(8, len(*walFile))], 16, 32)
+		if err != nil {
+			return Identity{}, fmt.Errorf("unexpected WAL file name %q", *walFile)
+		}
+		id.Timeline = uint32(t)
+	}
+	if recoveryTLI != nil {
+		id.Timeline = uint32(*recoveryTLI)
+	}
+	return id, nil
+}
@@ -2,6 +2,7 @@ package postgres
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -34,3 +35,105 @@ func WaitReplicationStarted(ctx context.Context, q queryer, appName string, time
 		}
 	}
 }
+
+// SlotInfo is the WAL retention state of a replication slot (pg_replication_slots).
+type SlotInfo struct {
+	Exists      bool
+	WALStatus   string // reserved | extended | unreserved | lost
+	Retained    int64  // bytes between restart_lsn and the current WAL position
+	SafeWALSize int64  // bytes left before invalidation; -1 = unlimited (max_slot_wal_keep_size = -1)
+}
+
+// SlotStatus reads the retention state of slot.
+func SlotStatus(ctx context.Context, q queryer, slot string) (SlotInfo, error) {
+	info := SlotInfo{Exists: true}
+	// wal_status and safe_wal_size appeared in PostgreSQL 13; read them through jsonb
+	// so the same query works on 12
+	err := q.QueryRow(ctx, `SELECT coalesce(to_jsonb(s)->>'wal_status', ''),
+                                   coalesce(pg_wal_lsn_diff(CASE WHEN pg_is_in_recovery() THEN pg_last_wal_receive_lsn()
+                                                                   ELSE pg_current_wal_lsn() END, restart_lsn), 0)::bigint,
+                                   coalesce((to_jsonb(s)->>'safe_wal_size')::bigint, -1)
+                              FROM pg_replication_slots s WHERE slot_name = $1`, slot).
+		Scan(&info.WALStatus, &info.Retained, &info.SafeWALSize)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return SlotInfo{}, nil
+	}
+	if err != nil {
+		return SlotInfo{}, fmt.Errorf("query slot %s: %w", slot, err)
+	}
+	return info, nil
+}
+
+// StandbyStatus describes how far a standby is behind its upstream.
+type StandbyStatus struct {
+	Streaming   bool          // WAL receiver is connected and streaming
+	ReceiveLSN  LSN           // last WAL received and flushed
+	ReplayLSN   LSN           // last WAL replayed
+	ReplayDelay time.Duration // age of the last replayed transaction; 0 when replay caught up with receive
+}
+
+// ReplayLag is the WAL received but not replayed yet, in bytes.
+func (s StandbyStatus) ReplayLag() uint64 {
+	if s.ReceiveLSN <= s.ReplayLSN {
+		return 0
+	}
+	return uint64(s.ReceiveLSN - s.ReplayLSN)
+}
+
+// QueryStandbyStatus reads the replication state of a standby.
+func QueryStandbyStatus(ctx context.Context, q queryer) (StandbyStatus, error) {
+	var st StandbyStatus
+	var recv, replay *string
+	var delay float64
+	err := q.QueryRow(ctx, `SELECT coalesce((SELECT status = 'streaming' FROM pg_stat_wal_receiver), false),
+                                   pg_last_wal_receive_lsn()::text, pg_last_wal_replay_lsn()::text,
+                                   CASE WHEN pg_last_wal_receive_lsn() IS NULL
+                                          OR pg_last_wal_receive_lsn() <= pg_last_wal_replay_lsn() THEN 0
+                                        ELSE coalesce(extract(epoch FROM now() - pg_last_xact_replay_timestamp()), 0) END::float8`).
+		Scan(&st.Streaming, &recv, &replay, &delay)
+	if err != nil {
+		return StandbyStatus{}, fmt.Errorf("query standby status: %w", err)
+	}
+	for _, v := range []struct {
+		s   *string
+		dst *LSN
+	}{{recv, &st.ReceiveLSN}, {replay, &st.ReplayLSN}} {
+		if v.s == nil {
+			continue
+		}
+		if *v.dst, err = ParseLSN(*v.s); err != nil {
+			return StandbyStatus{}, err
+		}
+	}
+	st.ReplayDelay = time.Duration(delay * float64(time.Second))
+	return st, nil
+}
+
+// ReplicationWritten reports whether the receiver connected as appName has written WAL up to
+// lsn (pg_stat_replication.write_lsn, as last reported by the receiver).
+func ReplicationWritten(ctx context.Context, q queryer, appName string, lsn LSN) (bool, error) {
+	var ok bool
+	err := q.QueryRow(ctx, `SELECT coalesce(max(write_lsn) >= $2::pg_lsn, false)
+                              FROM pg_stat_replication WHERE application_name = $1`, appName, lsn.String()).Scan(&ok)
+	if err != nil {
+		return false, fmt.Errorf("query pg_stat_replication: %w", err)
+	}
+	return ok, nil
+}
+
+// ReplicaLag returns how many bytes of WAL the standby connected as appName still has to
+// replay; ok is false while it is not streaming from this server.
+func ReplicaLag(ctx context.Context, q queryer, appName string) (lag int64, ok bool, err error) {
+	var v *int64
+	err = q.QueryRow(ctx, `SELECT max(pg_wal_lsn_diff(CASE WHEN pg_is_in_recovery() THEN pg_last_wal_receive_lsn()
+                                                            ELSE pg_current_wal_lsn() END,
+                                                       coalesce(replay_lsn, '0/0')))::bigint
+                             FROM pg_stat_replication WHERE application_name = $1 AND state = 'streaming'`, appName).Scan(&v)
+	if err != nil {
+		return 0, false, fmt.Errorf("query pg_stat_replication: %w", err)
+	}
+	if v == nil {
+		return 0, false, nil
+	}
+	return max(*v, 0), true, nil
+}
@@ -29,3 +29,70 @@ func TestWaitReplicationStarted(t *testing.T) {
 		t.Fatalf("unmet expectations: %v", err)
 	}
 }
+
+func TestSlotStatus(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("mock: %v", err)
+	}
+	defer mock.Close()
+
+	mock.ExpectQuery("FROM pg_replication_slots").WithArgs("s1").
+		WillReturnRows(pgxmock.NewRows([]string{"wal_status", "retained", "safe"}).AddRow("extended", int64(300), int64(700)))
+	mock.ExpectQuery("FROM pg_replication_slots").WithArgs("gone").
+		WillReturnRows(pgxmock.NewRows([]string{"wal_status", "retained", "safe"}))
+
+	info, err := SlotStatus(context.Background(), mock, "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Exists || info.WALStatus != "extended" || info.Retained != 300 || info.SafeWALSize != 700 {
+		t.Fatalf("unexpected info %+v", info)
+	}
+	info, err = SlotStatus(context.Background(), mock, "gone")
+	if err != nil || info.Exists {
+		t.Fatalf("expected missing slot, got %+v, %v", info, err)
+	}
+}
+
+func TestQueryStandbyStatus(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("mock: %v", err)
+	}
+	defer mock.Close()
+
+	recv, replay := "0/3000100", "0/3000000"
+	mock.ExpectQuery("FROM pg_stat_wal_receiver").
+		WillReturnRows(pgxmock.NewRows([]string{"streaming", "recv", "replay", "delay"}).AddRow(true, &recv, &replay, 2.5))
+
+	st, err := QueryStandbyStatus(context.Background(), mock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.Streaming || st.ReplayLag() != 0x100 || st.ReplayDelay != 2500*time.Millisecond {
+		t.Fatalf("unexpected status %+v", st)
+	}
+}
+
+func TestReplicaLag(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("mock: %v", err)
+	}
+	defer mock.Close()
+
+	lag := int64(8192)
+	mock.ExpectQuery("FROM pg_stat_replication").WithArgs("replica1").
+		WillReturnRows(pgxmock.NewRows([]string{"lag"}).AddRow((*int64)(nil)))
+	mock.ExpectQuery("FROM pg_stat_replication").WithArgs("replica1").
+		WillReturnRows(pgxmock.NewRows([]string{"lag"}).AddRow(&lag))
+
+	if _, ok, err := ReplicaLag(context.Background(), mock, "replica1"); err != nil || ok {
+		t.Fatalf("not streaming: ok=%v err=%v", ok, err)
+	}
+	got, ok, err := ReplicaLag(context.Background(), mock, "replica1")
+	if err != nil || !ok || got != 8192 {
+		t.Fatalf("got %d ok=%v err=%v", got, ok, err)
+	}
+}
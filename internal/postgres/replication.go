@@ -0,0 +1,120 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ConnectReplication opens a physical replication connection (replication=true) using connString.
+// Such a connection accepts only replication commands (IDENTIFY_SYSTEM, BASE_BACKUP, START_REPLICATION, ...).
+// appName, if not empty, overrides application_name so the session can be found in pg_stat_replication.
+func ConnectReplication(ctx context.Context, connString string, appName string) (*pgconn.PgConn, error) {
+	cfg, err := pgconn.ParseConfig(connString)
+	if err != nil {
+		return nil, fmt.Errorf("parse replication conninfo: %w", err)
+	}
+	cfg.RuntimeParams["replication"] = "true"
+	if appName != "" {
+		cfg.RuntimeParams["application_name"] = appName
+	}
+	conn, err := pgconn.ConnectConfig(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("replication connect: %w", err)
+	}
+	return conn, nil
+}
+
+// QuoteLiteral quotes s as an SQL string literal for replication commands,
+// which do not support bind parameters.
+func QuoteLiteral(s string) string {
+	out := make([]byte, 0, len(s)+2)
+	out = append(out, '\'')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\'' {
+			out = append(out, '\'')
+		}
+		out = append(out, s[i])
+	}
+	return string(append(out, '\''))
+}
+
+// SystemInfo is the result of IDENTIFY_SYSTEM.
+type SystemInfo struct {
+	SystemID string
+	Timeline uint32
+	XLogPos  LSN
+}
+
+// IdentifySystem runs IDENTIFY_SYSTEM on a replication connection.
+func IdentifySystem(ctx context.Context, conn *pgconn.PgConn) (SystemInfo, error) {
+	row, err := replicationRow(ctx, conn, "IDENTIFY_SYSTEM", 3)
+	if err != nil {
+		return SystemInfo{}, err
+	}
+	tli, err := strconv.ParseUint(string(row[1]), 10, 32)
+	if err != nil {
+		return SystemInfo{}, fmt.Errorf("IDENTIFY_SYSTEM: bad timeline %q", row[1])
+	}
+	pos, err := ParseLSN(string(row[2]))
+	if err != nil {
+		return SystemInfo{}, fmt.Errorf("IDENTIFY_SYSTEM: %w", err)
+	}
+	return SystemInfo{SystemID: string(row[0]), Timeline: uint32(tli), XLogPos: pos}, nil
+}
+
+// ShowSetting runs SHOW name on a replication connection and returns the raw value.
+func ShowSetting(ctx context.Context, conn *pgconn.PgConn, name string) (string, error) {
+	row, err := replicationRow(ctx, conn, "SHOW "+name, 1)
+	if err != nil {
+		return "", err
+	}
+	return string(row[0]), nil
+}
+
+// TimelineHistory fetches the history file of timeline tli (TIMELINE_HISTORY).
+func TimelineHistory(ctx context.Context, conn *pgconn.PgConn, tli uint32) (name string, content []byte, err error) {
+	row, err := replicationRow(ctx, conn, fmt.Sprintf("TIMELINE_HISTORY %d", tli), 2)
+	if err != nil {
+		return "", nil, err
+	}
+	return string(row[0]), row[1], nil
+}
+
+// CreatePhysicalSlot creates a permanent physical slot that reserves WAL immediately.
+func CreatePhysicalSlot(ctx context.Context, conn *pgconn.PgConn, slot string) error {
+	_, err := conn.Exec(ctx, fmt.Sprintf("CREATE_REPLICATION_SLOT %s PHYSICAL RESERVE_WAL", QuoteIdent(slot))).ReadAll()
+	if err != nil {
+		return fmt.Errorf("create slot %s: %w", slot, err)
+	}
+	return nil
+}
+
+// DropSlot drops a replication slot, waiting for it to become inactive.
+func DropSlot(ctx context.Context, conn *pgconn.PgConn, slot string) error {
+	_, err := conn.Exec(ctx, fmt.Sprintf("DROP_REPLICATION_SLOT %s WAIT", QuoteIdent(slot))).ReadAll()
+	if err != nil {
+		return fmt.Errorf("drop slot %s: %w", slot, err)
+	}
+	return nil
+}
+
+// replicationRow executes a replication command returning a single row with at least cols columns.
+func replicationRow(ctx context.Context, conn *pgconn.PgConn, cmd string, cols int) ([][]byte, error) {
+	results, err := conn.Exec(ctx, cmd).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", cmd, err)
+	}
+	if len(results) != 1 || len(results[0].Rows) != 1 || len(results[0].Rows[0]) < cols {
+		return nil, fmt.Errorf("%s: unexpected result", cmd)
+	}
+	return results[0].Rows[0], nil
+}
+
+// QuoteIdent quotes an identifier for replication commands.
+func QuoteIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
@@ -0,0 +1,129 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// sessionKeepAlive detects a silently dropped connection (NAT, proxy) within about a minute
+// and keeps idle NAT entries alive.
+var sessionKeepAlive = net.KeepAliveConfig{Enable: true, Idle: 30 * time.Second, Interval: 10 * time.Second, Count: 3}
+
+// sessionTimeouts are disabled on the control session, which stays idle for hours.
+var sessionTimeouts = []string{"statement_timeout", "idle_in_transaction_session_timeout", "idle_session_timeout"}
+
+// Session serialises access to one pgx.Conn shared by the clone steps and background
+// monitors (a pgx.Conn must not be used concurrently). It satisfies queryer.
+type Session struct {
+	mu   sync.Mutex
+	conn *pgx.Conn
+}
+
+// ConnectSession opens a long-lived session with TCP keepalives and the server-side
+// timeouts that could kill it disabled.
+func ConnectSession(ctx context.Context, connString string) (*Session, error) {
+	cfg, err := pgx.ParseConfig(connString)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{KeepAliveConfig: sessionKeepAlive}
+	cfg.DialFunc = dialer.DialContext
+	conn, err := pgx.ConnectConfig(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range sessionTimeouts {
+		if _, err := conn.Exec(ctx, fmt.Sprintf("SET %s = 0", name)); err != nil {
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && pgErr.Code == "42704" {
+				continue // undefined_object: parameter not known to this server version
+			}
+			_ = conn.Close(ctx)
+			return nil, fmt.Errorf("disable %s: %w", name, err)
+		}
+		slog.Debug("control session", "set", name+" = 0")
+	}
+	return NewSession(conn), nil
+}
+
+// NewSession wraps conn.
+func NewSession(conn *pgx.Conn) *Session { return &Session{conn: conn} }
+
+// QueryRow runs sql; the session stays locked until Scan is called on the returned row.
+func (s *Session) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	s.mu.Lock()
+	return &lockedRow{row: s.conn.QueryRow(ctx, sql, args...), unlock: s.mu.Unlock}
+}
+
+// Exec runs sql without returning rows.
+func (s *Session) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn.Exec(ctx, sql, args...)
+}
+
+// Do runs fn with exclusive access to the underlying connection (e.g. to iterate rows).
+func (s *Session) Do(fn func(conn *pgx.Conn) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fn(s.conn)
+}
+
+// TryDo is Do without waiting: it returns false without calling fn while the session is busy.
+func (s *Session) TryDo(fn func(conn *pgx.Conn) error) (bool, error) {
+	if !s.mu.TryLock() {
+		return false, nil
+	}
+	defer s.mu.Unlock()
+	return true, fn(s.conn)
+}
+
+// WithStatementTimeout runs fn in a transaction with statement_timeout d. Unlike a context
+// deadline, a query that runs too long is cancelled by the server and leaves the connection
+// (and a backup running on it) intact.
+func WithStatementTimeout(ctx context.Context, conn *pgx.Conn, d time.Duration, fn func(tx pgx.Tx) error) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	if _, err := tx.Exec(ctx, fmt.Sprintf("SET LOCAL statement_timeout = %d", d.Milliseconds())); err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// IsClosed reports whether the connection is closed or broken.
+func (s *Session) IsClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn.IsClosed()
+}
+
+// Close closes the connection.
+func (s *Session) Close(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn.Close(ctx)
+}
+
+type lockedRow struct {
+	row    pgx.Row
+	unlock func()
+}
+
+func (r *lockedRow) Scan(dest ...any) error {
+	defer r.unlock()
+	return r.row.Scan(dest...)
+}
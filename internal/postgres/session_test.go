@@ -0,0 +1,22 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestSessionTryDoBusy(t *testing.T) {
+	s := NewSession(nil)
+	called := false
+	fn := func(*pgx.Conn) error { called = true; return nil }
+
+	s.mu.Lock()
+	if ran, err := s.TryDo(fn); ran || err != nil || called {
+		t.Fatalf("busy session: ran=%v err=%v called=%v", ran, err, called)
+	}
+	s.mu.Unlock()
+	if ran, err := s.TryDo(fn); !ran || err != nil || !called {
+		t.Fatalf("idle session: ran=%v err=%v called=%v", ran, err, called)
+	}
+}
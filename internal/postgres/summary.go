@@ -0,0 +1,95 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+)
+
+// WALSummary is one row of pg_available_wal_summaries() (PostgreSQL 17+).
+type WALSummary struct {
+	Timeline uint32
+	Start    LSN
+	End      LSN
+}
+
+// BlockRef is one row of pg_wal_summary_contents(): a modified block, or with Limit set
+// the length the relation fork was truncated to.
+type BlockRef struct {
+	Tablespace  uint32
+	Database    uint32
+	RelFileNode uint32
+	Fork        int16 // 0 main, 1 fsm, 2 vm, 3 init
+	Block       uint32
+	Limit       bool
+}
+
+// WALSummaries lists the WAL summaries kept by the server.
+func WALSummaries(ctx context.Context, q Queryer) ([]WALSummary, error) {
+	rows, err := q.Query(ctx, `SELECT tli, start_lsn::text, end_lsn::text FROM pg_available_wal_summaries() ORDER BY tli, start_lsn`)
+	if err != nil {
+		return nil, fmt.Errorf("query WAL summaries: %w", err)
+	}
+	defer rows.Close()
+	var out []WALSummary
+	for rows.Next() {
+		var tli int64
+		var start, end string
+		if err := rows.Scan(&tli, &start, &end); err != nil {
+			return nil, err
+		}
+		s := WALSummary{Timeline: uint32(tli)}
+		if s.Start, err = ParseLSN(start); err != nil {
+			return nil, err
+		}
+		if s.End, err = ParseLSN(end); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// SummarizedLSN returns how far the WAL summarizer got (pg_get_wal_summarizer_state).
+func SummarizedLSN(ctx context.Context, q queryer) (LSN, error) {
+	var s *string
+	if err := q.QueryRow(ctx, `SELECT summarized_lsn::text FROM pg_get_wal_summarizer_state()`).Scan(&s); err != nil {
+		return 0, fmt.Errorf("query WAL summarizer state: %w", err)
+	}
+	if s == nil {
+		return 0, nil
+	}
+	return ParseLSN(*s)
+}
+
+// WALSummaryContents calls fn for every block reference recorded in summary s.
+func WALSummaryContents(ctx context.Context, q Queryer, s WALSummary, fn func(BlockRef) error) error {
+	rows, err := q.Query(ctx, `SELECT reltablespace, reldatabase, relfilenode, relforknumber, relblocknumber, is_limit_block
+                                 FROM pg_wal_summary_contents($1, $2::text::pg_lsn, $3::text::pg_lsn)`,
+		int64(s.Timeline), s.Start.String(), s.End.String())
+	if err != nil {
+		return fmt.Errorf("query WAL summary %s-%s: %w", s.Start, s.End, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var blk int64
+		var ref BlockRef
+		if err := rows.Scan(&ref.Tablespace, &ref.Database, &ref.RelFileNode, &ref.Fork, &blk, &ref.Limit); err != nil {
+			return err
+		}
+		ref.Block = uint32(blk)
+		if err := fn(ref); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ReadBinaryFile reads n bytes at off of a file below the server's data directory
+// (pg_read_binary_file; superuser or an explicit grant). ok is false if the file is gone.
+func ReadBinaryFile(ctx context.Context, q queryer, path string, off, n int64) (data []byte, ok bool, err error) {
+	err = q.QueryRow(ctx, `SELECT pg_read_binary_file($1, $2, $3, true)`, path, off, n).Scan(&data)
+	if err != nil {
+		return nil, false, fmt.Errorf("read %s at %d: %w", path, off, err)
+	}
+	return data, data != nil, nil
+}
@@ -0,0 +1,235 @@
+package progress
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vbauerster/mpb/v8"
+	"github.com/vbauerster/mpb/v8/decor"
+)
+
+// Tracker reports transfer progress of a single module either as an mpb bar
+// or as periodic plain lines on stderr. Add is safe for concurrent use.
+type Tracker struct {
+	name  string
+	total int64
+	cur   atomic.Int64
+
+	p   *mpb.Progress
+	bar *mpb.Bar
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+// New creates a tracker for name with expected total bytes.
+// showBar selects the mpb bar; otherwise mode "plain" prints a line every interval seconds
+// and any other mode disables output (Add still counts bytes).
+func New(name string, total int64, showBar bool, mode string, interval int) *Tracker {
+	t := &Tracker{name: name, total: total, stop: make(chan struct{})}
+	if showBar {
+		t.p = acquireContainer()
+		// Module name followed by space, then percentage
+		namePrefix := name + " "
+		t.bar = t.p.New(total, mpb.BarStyle().Rbound("|").Lbound("|"),
+			mpb.PrependDecorators(decor.Name(namePrefix, decor.WC{W: len(namePrefix), C: decor.DSyncWidth}), decor.Percentage()),
+			mpb.AppendDecorators(decor.Any(func(s decor.Statistics) string {
+				return fmt.Sprintf("%s / %s", FormatBytes(s.Current), FormatBytes(s.Total))
+			}), decor.Any(func(decor.Statistics) string {
+				if st := Status(); st != "" {
+					return "  " + st
+				}
+				return ""
+			})))
+	} else if mode == "plain" {
+		if interval <= 0 {
+			interval = 30
+		}
+		t.wg.Add(1)
+		go t.printPlain(time.Duration(interval) * time.Second)
+	}
+	return t
+}
+
+// Add accounts n transferred bytes.
+func (t *Tracker) Add(n int64) {
+	if n <= 0 {
+		return
+	}
+	t.cur.Add(n)
+	if t.bar != nil {
+		t.bar.IncrInt64(n)
+	}
+}
+
+// Current returns bytes accounted so far.
+func (t *Tracker) Current() int64 { return t.cur.Load() }
+
+// Finish completes the bar to exactly 100% and stops the plain printer.
+// Safe to call multiple times.
+func (t *Tracker) Finish() {
+	t.once.Do(func() {
+		close(t.stop)
+		t.wg.Wait()
+		if t.bar != nil && t.p != nil {
+			if remaining := t.total - t.bar.Current(); remaining > 0 {
+				t.bar.IncrInt64(remaining)
+			}
+			t.bar.SetTotal(t.total, true) // mark as complete
+			releaseContainer()
+		}
+	})
+}
+
+// Abort stops output without forcing the bar to completion.
+func (t *Tracker) Abort() {
+	t.once.Do(func() {
+		close(t.stop)
+		t.wg.Wait()
+		if t.bar != nil && t.p != nil {
+			t.bar.Abort(false)
+			releaseContainer()
+		}
+	})
+}
+
+// bars is the mpb container shared by the bars alive at the same time (one per clone
+// target), so they render as one block instead of overwriting each other.
+var bars struct {
+	mu    sync.Mutex
+	p     *mpb.Progress
+	users int
+}
+
+func acquireContainer() *mpb.Progress {
+	bars.mu.Lock()
+	defer bars.mu.Unlock()
+	if bars.p == nil {
+		bars.p = mpb.New(mpb.WithWidth(40), mpb.WithRefreshRate(100*time.Millisecond))
+	}
+	bars.users++
+	return bars.p
+}
+
+// releaseContainer waits for the final render once the last bar is done.
+func releaseContainer() {
+	bars.mu.Lock()
+	defer bars.mu.Unlock()
+	bars.users--
+	if bars.users == 0 {
+		bars.p.Wait()
+		bars.p = nil
+	}
+}
+
+func (t *Tracker) printPlain(every time.Duration) {
+	defer t.wg.Done()
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	startTime := time.Now()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+			current := t.cur.Load()
+			elapsed := time.Since(startTime)
+			percent := int64(0)
+			if t.total > 0 {
+				percent = (current * 100) / t.total
+				if percent > 100 {
+					percent = 100
+				}
+			}
+
+			speed := int64(0)
+			if elapsed.Seconds() > 0 {
+				speed = int64(float64(current) / elapsed.Seconds())
+			}
+
+			remaining := t.total - current
+			eta := int64(0)
+			if speed > 0 && remaining > 0 {
+				eta = remaining / speed
+			}
+
+			note := ""
+			if st := Status(); st != "" {
+				note = "  [" + st + "]"
+			}
+			fmt.Fprintf(os.Stderr, "[%s] %s %3d %%  (%s / %s, %s/s, ETA %02d:%02d:%02d)%s\n",
+				time.Now().Format("2006-01-02 15:04:05"),
+				t.name,
+				percent,
+				FormatBytes(current),
+				FormatBytes(t.total),
+				FormatBytes(speed),
+				eta/3600,
+				(eta%3600)/60,
+				eta%60,
+				note)
+
+			// exit when done
+			if current >= t.total {
+				return
+			}
+		}
+	}
+}
+
+// status holds notes shown next to progress output (e.g. replication slot retention).
+var status struct {
+	mu sync.Mutex
+	m  map[string]string
+}
+
+// SetStatus sets the note for key; an empty text removes it.
+func SetStatus(key, text string) {
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	if text == "" {
+		delete(status.m, key)
+		return
+	}
+	if status.m == nil {
+		status.m = make(map[string]string)
+	}
+	status.m[key] = text
+}
+
+// Status returns all notes as "key: text" in key order.
+func Status() string {
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	keys := make([]string, 0, len(status.m))
+	for k := range status.m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+": "+status.m[k])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// FormatBytes converts byte count to human-readable string (KB, MB, etc.).
+func FormatBytes(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	exp, value := 0, float64(n)
+	for value >= unit && exp < 5 {
+		value /= unit
+		exp++
+	}
+	suffix := []string{"KB", "MB", "GB", "TB", "PB"}[exp-1]
+	return fmt.Sprintf("%.2f %s", value, suffix)
+}
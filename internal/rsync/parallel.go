@@ -14,21 +14,25 @@ import (
 	"sync"
 	"time"
 
-	"github.com/vbauerster/mpb/v8"
-	"github.com/vbauerster/mpb/v8/decor"
 	"golang.org/x/sys/unix"
+
+	"github.com/vbp1/pgclone/internal/progress"
 )
 
+// Workers is the number of workers RunParallel starts for n: n, or half the CPUs of this
+// host (at least one) when n <= 0.
+func Workers(n int) int {
+	if n > 0 {
+		return n
+	}
+	return max(runtime.NumCPU()/2, 1)
+}
+
 // RunParallel starts N rsync workers to transfer provided files to dstDir.
 // It blocks until all workers finish or ctx is canceled.
 // Returned error – first non-zero exit or context cancellation.
 func RunParallel(ctx context.Context, cfg Config, module string, workers int, files []FileInfo, dstDir string, showBar bool, progressMode string, progressInterval int) (Stats, error) {
-	if workers <= 0 {
-		workers = runtime.NumCPU() / 2
-		if workers == 0 {
-			workers = 1
-		}
-	}
+	workers = Workers(workers)
 
 	const flushInterval = 500 * time.Millisecond
 	// Split files among workers
@@ -67,32 +71,15 @@ func RunParallel(ctx context.Context, cfg Config, module string, workers int, fi
 	}
 
 	// Log which module we are about to sync – printed before progress bar appears
-	slog.Info("syncing module", "module", module)
+	name := module
+	if cfg.Label != "" {
+		name = cfg.Label + ":" + module
+	}
+	slog.Info("syncing module", "module", name)
 
 	// prepare progress display
-	var p *mpb.Progress
-	var bar *mpb.Bar
-	var showPlain bool
-
-	// === Shared progress state (for plain mode) ===
-	var progressBytes int64
-	var progressMu sync.Mutex
-
-	if showBar {
-		p = mpb.New(mpb.WithWidth(40), mpb.WithRefreshRate(100*time.Millisecond))
-		// Module name followed by space, then percentage
-		namePrefix := module + " "
-		bar = p.New(totalBytes, mpb.BarStyle().Rbound("|").Lbound("|"),
-			mpb.PrependDecorators(decor.Name(namePrefix, decor.WC{W: len(namePrefix), C: decor.DSyncWidth}), decor.Percentage()),
-			mpb.AppendDecorators(decor.Any(func(s decor.Statistics) string {
-				return fmt.Sprintf("%s / %s", formatBytes(s.Current), formatBytes(s.Total))
-			})))
-	} else if progressMode == "plain" {
-		showPlain = true
-		if progressInterval <= 0 {
-			progressInterval = 30
-		}
-	}
+	tracker := progress.New(name, totalBytes, showBar, progressMode, progressInterval)
+	defer tracker.Abort()
 
 	tmpDir, err := os.MkdirTemp("", "pgclone_files")
 	if err != nil {
@@ -105,62 +92,6 @@ func RunParallel(ctx context.Context, cfg Config, module string, workers int, fi
 	errCh := make(chan error, workers)
 	statsCh := make(chan Stats, workers)
 
-	// Start single plain progress printer goroutine (once)
-	if showPlain {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			ticker := time.NewTicker(time.Duration(progressInterval) * time.Second)
-			defer ticker.Stop()
-			startTime := time.Now()
-			for {
-				select {
-				case <-ctx.Done():
-					return
-				case <-ticker.C:
-					progressMu.Lock()
-					current := progressBytes
-					progressMu.Unlock()
-
-					elapsed := time.Since(startTime)
-					percent := int64(0)
-					if totalBytes > 0 {
-						percent = (current * 100) / totalBytes
-						if percent > 100 {
-							percent = 100
-						}
-					}
-
-					speed := int64(0)
-					if elapsed.Seconds() > 0 {
-						speed = int64(float64(current) / elapsed.Seconds())
-					}
-
-					remaining := totalBytes - current
-					eta := int64(0)
-					if speed > 0 {
-						eta = remaining / speed
-					}
-
-					fmt.Fprintf(os.Stderr, "[%s] %3d %%  (%s / %s, %s/s, ETA %02d:%02d:%02d)\n",
-						time.Now().Format("2006-01-02 15:04:05"),
-						percent,
-						formatBytes(current),
-						formatBytes(totalBytes),
-						formatBytes(speed),
-						eta/3600,
-						(eta%3600)/60,
-						eta%60)
-
-					// exit when done
-					if current >= totalBytes {
-						return
-					}
-				}
-			}
-		}()
-	}
-
 	// Launch workers
 	for idx, bucket := range buckets {
 		if len(bucket) == 0 {
@@ -209,7 +140,7 @@ func RunParallel(ctx context.Context, cfg Config, module string, workers int, fi
 		go func(r io.Reader) {
 			defer wg.Done()
 			br := bufio.NewReaderSize(r, 256*1024)
-			pending := 0
+			var pending int64
 			lastFlush := time.Now()
 			for {
 				line, err := br.ReadBytes('\n')
@@ -217,32 +148,16 @@ func RunParallel(ctx context.Context, cfg Config, module string, workers int, fi
 					statsMu.Lock()
 					statsBuf.Write(line)
 					statsMu.Unlock()
-					if bar != nil || showPlain {
-						if n, ok := parseSizeBytes(line); ok && n > 0 {
-							if bar != nil {
-								pending += int(n)
-							}
-							if showPlain {
-								progressMu.Lock()
-								progressBytes += n
-								progressMu.Unlock()
-							}
-						}
+					if n, ok := parseSizeBytes(line); ok && n > 0 {
+						pending += n
 					}
 				}
-				flush := false
-				if pending > 0 && (time.Since(lastFlush) > flushInterval || err == io.EOF) {
-					flush = true
-				}
-				if flush && bar != nil {
-					bar.IncrBy(pending)
+				if pending > 0 && (time.Since(lastFlush) > flushInterval || err != nil) {
+					tracker.Add(pending)
 					pending = 0
 					lastFlush = time.Now()
 				}
 				if err != nil {
-					if err == io.EOF {
-						break
-					}
 					break
 				}
 			}
@@ -251,8 +166,6 @@ func RunParallel(ctx context.Context, cfg Config, module string, workers int, fi
 			}
 		}(stdout)
 
-		// Plain progress printer goroutine will be started once after launching all workers
-
 		// read stderr, log and collect for stats
 		wg.Add(1)
 		go func(r io.Reader) {
@@ -300,16 +213,7 @@ func RunParallel(ctx context.Context, cfg Config, module string, workers int, fi
 	case err := <-errCh:
 		return total, err
 	case <-done:
-		// Complete the bar to exactly 100%
-		if bar != nil && p != nil {
-			// Calculate remaining bytes to reach 100%
-			current := bar.Current()
-			if remaining := totalBytes - current; remaining > 0 {
-				bar.IncrInt64(remaining)
-			}
-			bar.SetTotal(totalBytes, true) // mark as complete
-			p.Wait()
-		}
+		tracker.Finish()
 		close(statsCh)
 		for st := range statsCh {
 			total = total.Add(st)
@@ -2,9 +2,12 @@ package rsync
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 )
 
 // Config holds parameters common for all rsync workers in a session.
@@ -14,6 +17,8 @@ type Config struct {
 	SecretFile string // local path to password file
 	Checksum   bool   // use --checksum flag (paranoid)
 	Verbose    bool   // add --stats --human-readable
+
+	Label string // clone target shown before the module name in logs and progress; empty = none
 }
 
 // BuildCmd constructs *exec.Cmd to sync files listed in filesFrom into dstDir.
@@ -44,3 +49,30 @@ func (c Config) BuildCmd(ctx context.Context, module string, filesFrom string, d
 	cmd := exec.CommandContext(ctx, rsyncBin, args...)
 	return cmd
 }
+
+// FetchFiles copies the named files from the root of module into dstDir in a single run.
+// Files that do not exist on the remote side are skipped (rsync exit code 23), so callers
+// must check dstDir for what actually arrived.
+func (c Config) FetchFiles(ctx context.Context, module string, names []string, dstDir string) error {
+	list, err := os.CreateTemp("", "pgclone_fetch_*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(list.Name()) }()
+	if _, err := list.WriteString(strings.Join(names, "\n") + "\n"); err != nil {
+		_ = list.Close()
+		return err
+	}
+	if err := list.Close(); err != nil {
+		return err
+	}
+	out, err := c.BuildCmd(ctx, module, list.Name(), dstDir).CombinedOutput()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 23 {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("rsync %s: %w: %s", module, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
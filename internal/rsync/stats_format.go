@@ -3,22 +3,12 @@ package rsync
 import (
 	"fmt"
 	"time"
+
+	"github.com/vbp1/pgclone/internal/progress"
 )
 
 // formatBytes converts byte count to human-readable string (KB, MB, etc.).
-func formatBytes(n int64) string {
-	const unit = 1000
-	if n < unit {
-		return fmt.Sprintf("%d B", n)
-	}
-	exp, value := 0, float64(n)
-	for value >= unit && exp < 5 {
-		value /= unit
-		exp++
-	}
-	suffix := []string{"KB", "MB", "GB", "TB", "PB"}[exp-1]
-	return fmt.Sprintf("%.2f %s", value, suffix)
-}
+func formatBytes(n int64) string { return progress.FormatBytes(n) }
 
 // Summary returns a formatted multi-line string with aggregated rsync statistics.
 func (s Stats) Summary(elapsed time.Duration) string {
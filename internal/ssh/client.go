@@ -97,6 +97,12 @@ func (c *Client) Close() error { return c.client.Close() }
 
 // Run executes cmd on remote host, attaching std streams to provided writers. If stdout/stderr nil – they are discarded.
 func (c *Client) Run(ctx context.Context, cmd string, stdout, stderr io.Writer) error {
+	return c.RunInput(ctx, cmd, nil, stdout, stderr)
+}
+
+// RunInput is Run with the remote stdin read from stdin (nil = empty); the remote side sees
+// EOF once stdin is exhausted or closed.
+func (c *Client) RunInput(ctx context.Context, cmd string, stdin io.Reader, stdout, stderr io.Writer) error {
 	session, err := c.client.NewSession()
 	if err != nil {
 		return err
@@ -107,6 +113,9 @@ func (c *Client) Run(ctx context.Context, cmd string, stdout, stderr io.Writer)
 		}
 	}()
 
+	if stdin != nil {
+		session.Stdin = stdin
+	}
 	if stdout != nil {
 		session.Stdout = stdout
 	}
@@ -141,6 +150,16 @@ func (c *Client) Output(ctx context.Context, cmd string) ([]byte, error) {
 	return lb.Bytes(), nil
 }
 
+// Upload writes r to path on the remote host with the given permissions.
+func (c *Client) Upload(ctx context.Context, r io.Reader, path string, mode os.FileMode) error {
+	lb := &limitedBuffer{N: 1 << 16}
+	cmd := fmt.Sprintf("cat > '%s' && chmod %o '%s'", path, mode.Perm(), path)
+	if err := c.RunInput(ctx, cmd, r, nil, lb); err != nil {
+		return fmt.Errorf("upload %s: %w: %s", path, err, bytes.TrimSpace(lb.Bytes()))
+	}
+	return nil
+}
+
 // ----------------- helpers ------------------
 
 func hasPort(addr string) bool {
@@ -0,0 +1,118 @@
+package wal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrNotArchived means the requested file is not (yet) available in the archive.
+var ErrNotArchived = errors.New("not in WAL archive")
+
+// Archive reads WAL from a WAL archive. Source is either a directory or a command
+// template in restore_command syntax (%f = file name, %p = destination path).
+type Archive struct {
+	Source string
+}
+
+// IsCommand reports whether Source is a command template rather than a directory.
+func (a Archive) IsCommand() bool {
+	return strings.Contains(a.Source, "%f") || strings.Contains(a.Source, "%p")
+}
+
+// RestoreCommand returns a restore_command that reads from the same archive.
+func (a Archive) RestoreCommand() string {
+	if a.IsCommand() {
+		return a.Source
+	}
+	return fmt.Sprintf(`cp "%s/%%f" "%%p"`, a.Source)
+}
+
+// Fetch copies name from the archive to dst (decompressing .gz/.lz4/.zst copies in a
+// directory archive). It returns ErrNotArchived if the file is not there, or in a directory
+// archive is a segment shorter than segSize: archive_command may still be writing it.
+func (a Archive) Fetch(ctx context.Context, name, dst string, segSize uint64) error {
+	if a.IsCommand() {
+		r := strings.NewReplacer("%f", name, "%p", dst, "%%", "%")
+		out, err := exec.CommandContext(ctx, "sh", "-c", r.Replace(a.Source)).CombinedOutput()
+		if err != nil {
+			_ = os.Remove(dst)
+			return fmt.Errorf("%w: %s: %v: %s", ErrNotArchived, name, err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+	src := filepath.Join(a.Source, name)
+	if _, err := os.Stat(src); err == nil {
+		n, err := copyTo(src, dst)
+		return fetched(name, dst, n, segSize, err)
+	}
+	for _, suf := range compressSuffixes {
+		if _, err := os.Stat(src + suf); err == nil {
+			n, err := DecompressFile(src+suf, dst, segSize)
+			return fetched(name, dst, n, segSize, err)
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrNotArchived, name)
+}
+
+// fetched turns a segment of n bytes other than segSize (a truncated compressed copy
+// included) into ErrNotArchived, so WaitFetch polls again.
+func fetched(name, dst string, n int64, segSize uint64, err error) error {
+	if !IsSegmentName(name) || segSize == 0 || uint64(n) == segSize {
+		return err
+	}
+	_ = os.Remove(dst)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrNotArchived, name, err)
+	}
+	return fmt.Errorf("%w: %s has %d of %d bytes", ErrNotArchived, name, n, segSize)
+}
+
+// WaitFetch retries Fetch until the file appears in the archive or timeout passes.
+func (a Archive) WaitFetch(ctx context.Context, name, dst string, segSize uint64, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := a.Fetch(ctx, name, dst, segSize)
+		if err == nil || !errors.Is(err, ErrNotArchived) {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("waited %s: %w", timeout, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// copyTo copies src to dst via a temporary file and returns the bytes copied.
+func copyTo(src, dst string) (int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = in.Close() }()
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(out, in)
+	if err != nil {
+		_ = out.Close()
+		_ = os.Remove(tmp)
+		return n, err
+	}
+	if err := out.Close(); err != nil {
+		return n, err
+	}
+	return n, os.Rename(tmp, dst)
+}
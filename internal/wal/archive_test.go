@@ -0,0 +1,75 @@
+package wal
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestArchiveFetchDir(t *testing.T) {
+	arch, dst := t.TempDir(), t.TempDir()
+	a := Archive{Source: arch}
+	name := SegmentName(1, 5, DefaultSegmentSize)
+	if err := a.Fetch(context.Background(), name, filepath.Join(dst, name), DefaultSegmentSize); !errors.Is(err, ErrNotArchived) {
+		t.Fatalf("expected ErrNotArchived, got %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(arch, name), []byte("segment"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CompressFile(filepath.Join(arch, name), CompressGzip); err != nil {
+		t.Fatal(err)
+	}
+	// the size of a segment is only checked against segSize
+	if err := a.Fetch(context.Background(), name, filepath.Join(dst, name), 7); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dst, name)); string(got) != "segment" {
+		t.Fatalf("unexpected content %q", got)
+	}
+	if a.IsCommand() || a.RestoreCommand() != `cp "`+arch+`/%f" "%p"` {
+		t.Fatalf("unexpected restore_command %q", a.RestoreCommand())
+	}
+}
+
+// archive_command cp still writing: a short segment is not archived yet.
+func TestArchiveFetchDirShort(t *testing.T) {
+	arch, dst := t.TempDir(), t.TempDir()
+	a := Archive{Source: arch}
+	name := SegmentName(1, 5, DefaultSegmentSize)
+	if err := os.WriteFile(filepath.Join(arch, name), make([]byte, 4096), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	err := a.WaitFetch(context.Background(), name, filepath.Join(dst, name), DefaultSegmentSize, 0)
+	if !errors.Is(err, ErrNotArchived) {
+		t.Fatalf("short segment: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, name)); !os.IsNotExist(err) {
+		t.Fatalf("short segment left in place: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(arch, name), make([]byte, DefaultSegmentSize), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Fetch(context.Background(), name, filepath.Join(dst, name), DefaultSegmentSize); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestArchiveFetchCommand(t *testing.T) {
+	arch, dst := t.TempDir(), t.TempDir()
+	a := Archive{Source: "cp " + arch + "/%f %p"}
+	if !a.IsCommand() {
+		t.Fatal("expected command archive")
+	}
+	name := HistoryFileName(2)
+	if err := a.Fetch(context.Background(), name, filepath.Join(dst, name), DefaultSegmentSize); !errors.Is(err, ErrNotArchived) {
+		t.Fatalf("expected ErrNotArchived, got %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(arch, name), []byte("1\t0/3000000\tno recovery target\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Fetch(context.Background(), name, filepath.Join(dst, name), DefaultSegmentSize); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,165 @@
+package wal
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Compression methods for WAL kept in the temporary directory.
+const (
+	CompressNone = "none"
+	CompressGzip = "gzip"
+	CompressLZ4  = "lz4"
+	CompressZstd = "zstd"
+)
+
+// compressSuffixes maps methods to file suffixes (pg_receivewal uses ".gz" and ".lz4").
+var compressSuffixes = map[string]string{
+	CompressGzip: ".gz",
+	CompressLZ4:  ".lz4",
+	CompressZstd: ".zst",
+}
+
+// CompressSuffix returns the file suffix of method ("" for none/unknown).
+func CompressSuffix(method string) string { return compressSuffixes[method] }
+
+// ValidCompress reports whether method is a known compression method.
+func ValidCompress(method string) bool {
+	return method == "" || method == CompressNone || compressSuffixes[method] != ""
+}
+
+// TrimCompressSuffix strips a compression suffix from name (a ".partial" may follow it)
+// and reports the method found ("" if none).
+func TrimCompressSuffix(name string) (string, string) {
+	partial := strings.HasSuffix(name, ".partial")
+	base := strings.TrimSuffix(name, ".partial")
+	for method, suf := range compressSuffixes {
+		if strings.HasSuffix(base, suf) {
+			base = strings.TrimSuffix(base, suf)
+			if partial {
+				base += ".partial"
+			}
+			return base, method
+		}
+	}
+	return name, ""
+}
+
+// CompressFile compresses path into path+suffix and removes path. It returns the new path.
+func CompressFile(path, method string) (string, error) {
+	suf := CompressSuffix(method)
+	if suf == "" {
+		return path, nil
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = in.Close() }()
+	dst := path + suf
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return "", err
+	}
+	if err := compressStream(out, in, method); err != nil {
+		_ = out.Close()
+		_ = os.Remove(tmp)
+		return "", fmt.Errorf("compress %s: %w", path, err)
+	}
+	if err := out.Sync(); err != nil {
+		_ = out.Close()
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		return "", err
+	}
+	return dst, os.Remove(path)
+}
+
+// DecompressFile writes the contents of the compressed file src (method from its suffix)
+// to dst and returns the number of bytes written. A truncated ".partial" stream is
+// accepted and zero-padded to segSize, as pg_receivewal leaves uncompressed partials.
+func DecompressFile(src, dst string, segSize uint64) (int64, error) {
+	name, method := TrimCompressSuffix(src)
+	if method == "" {
+		return 0, fmt.Errorf("%s: unknown compression", src)
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = in.Close() }()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return 0, err
+	}
+	n, err := decompressStream(out, in, method)
+	partial := strings.HasSuffix(name, ".partial")
+	if err != nil && !(partial && errors.Is(err, io.ErrUnexpectedEOF)) {
+		_ = out.Close()
+		return n, fmt.Errorf("decompress %s: %w", src, err)
+	}
+	if partial && uint64(n) < segSize {
+		if err := out.Truncate(int64(segSize)); err != nil {
+			_ = out.Close()
+			return n, err
+		}
+	}
+	return n, out.Close()
+}
+
+func compressStream(w io.Writer, r io.Reader, method string) error {
+	if method == CompressGzip {
+		zw := gzip.NewWriter(w)
+		if _, err := io.Copy(zw, r); err != nil {
+			return err
+		}
+		return zw.Close()
+	}
+	return filter(w, r, method, "-q", "-c")
+}
+
+func decompressStream(w io.Writer, r io.Reader, method string) (int64, error) {
+	if method == CompressGzip {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return 0, err
+		}
+		return io.Copy(w, zr)
+	}
+	cw := &countingWriter{w: w}
+	err := filter(cw, r, method, "-q", "-d", "-c")
+	return cw.n, err
+}
+
+// filter pipes r through the external lz4/zstd binary into w.
+func filter(w io.Writer, r io.Reader, method string, args ...string) error {
+	cmd := exec.Command(method, args...)
+	cmd.Stdin, cmd.Stdout = r, w
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w: %s", method, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
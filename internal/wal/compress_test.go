@@ -0,0 +1,76 @@
+package wal
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTrimCompressSuffix(t *testing.T) {
+	cases := map[string][2]string{
+		"000000010000000000000003":             {"000000010000000000000003", ""},
+		"000000010000000000000003.gz":          {"000000010000000000000003", CompressGzip},
+		"000000010000000000000003.lz4.partial": {"000000010000000000000003.partial", CompressLZ4},
+		"000000010000000000000003.zst":         {"000000010000000000000003", CompressZstd},
+		"000000010000000000000003.partial":     {"000000010000000000000003.partial", ""},
+	}
+	for in, want := range cases {
+		name, method := TrimCompressSuffix(in)
+		if name != want[0] || method != want[1] {
+			t.Errorf("%s: got (%s, %s), want %v", in, name, method, want)
+		}
+	}
+	if _, segNo, err := ParseSegmentName("000000010000000000000003.gz", DefaultSegmentSize); err != nil || segNo != 3 {
+		t.Fatalf("ParseSegmentName: %d %v", segNo, err)
+	}
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("wal record "), 10000)
+	path := filepath.Join(dir, "000000010000000000000003")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	gz, err := CompressFile(path, CompressGzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("uncompressed file should be removed")
+	}
+	out := filepath.Join(dir, "out")
+	n, err := DecompressFile(gz, out, DefaultSegmentSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(out)
+	if n != int64(len(data)) || !bytes.Equal(got, data) {
+		t.Fatalf("round trip mismatch: %d bytes", n)
+	}
+}
+
+func TestDecompressTruncatedPartial(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "000000010000000000000004")
+	if err := os.WriteFile(path, bytes.Repeat([]byte{1, 2, 3, 4}, 4096), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	gz, err := CompressFile(path, CompressGzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	full, _ := os.ReadFile(gz)
+	partial := path + ".gz.partial"
+	if err := os.WriteFile(partial, full[:len(full)-8], 0o600); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out.partial")
+	if _, err := DecompressFile(partial, out, 1<<16); err != nil {
+		t.Fatal(err)
+	}
+	if st, _ := os.Stat(out); st.Size() != 1<<16 {
+		t.Fatalf("partial not padded: %d", st.Size())
+	}
+}
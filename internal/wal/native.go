@@ -0,0 +1,561 @@
+package wal
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+
+	"github.com/vbp1/pgclone/internal/postgres"
+)
+
+// DefaultStatusInterval matches pg_receivewal --status-interval default.
+const DefaultStatusInterval = 10 * time.Second
+
+// syncInterval bounds how long written WAL may stay without fsync.
+const syncInterval = time.Second
+
+// NativeReceiver streams WAL over the physical replication protocol
+// (START_REPLICATION + standby status updates) without the external pg_receivewal.
+// Files in Dir follow pg_receivewal conventions: completed segments, one zero-padded
+// "<segment>.partial" being written and timeline history files.
+type NativeReceiver struct {
+	ConnString string // primary conninfo; replication=true is added automatically
+	Dir        string // target directory for WAL
+	Slot       string // optional; empty = no slot
+	CreateSlot bool   // create Slot on Start and drop it on Stop
+	AppName    string // optional application_name visible in pg_stat_replication
+
+	StatusInterval time.Duration // 0 = DefaultStatusInterval
+
+	// Compress is applied to completed segments (CompressGzip, CompressLZ4, CompressZstd);
+	// the segment being written stays an uncompressed .partial.
+	Compress string
+
+	// Restarts is how many times streaming is re-established after a failure.
+	// Only used together with Slot, which guarantees the server kept the missing WAL.
+	Restarts int
+
+	conn     *pgconn.PgConn
+	segSize  uint64
+	timeline uint32
+
+	written atomic.Uint64 // end of WAL written into Dir
+	flushed atomic.Uint64 // end of WAL fsynced in Dir
+
+	seg      *openSegment
+	lastSync time.Time
+
+	compressq    chan string
+	compressDone chan struct{}
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error // stream exit error; valid once done is closed
+	errCh  chan error
+	closed bool
+}
+
+type openSegment struct {
+	no   uint64
+	name string
+	f    *os.File
+}
+
+// Directory returns the directory WAL is written to.
+func (r *NativeReceiver) Directory() string { return r.Dir }
+
+// Timeline returns the timeline being streamed.
+func (r *NativeReceiver) Timeline() uint32 { return r.timeline }
+
+// SegmentSize returns wal_segment_size reported by the server.
+func (r *NativeReceiver) SegmentSize() uint64 { return r.segSize }
+
+// WrittenLSN returns the end of WAL written into Dir.
+func (r *NativeReceiver) WrittenLSN() postgres.LSN { return postgres.LSN(r.written.Load()) }
+
+// FlushLSN returns the end of WAL durably (fsync) stored in Dir; it is also reported to the server as flush position.
+func (r *NativeReceiver) FlushLSN() postgres.LSN { return postgres.LSN(r.flushed.Load()) }
+
+// Start connects, optionally creates the slot, fetches timeline history and begins streaming in background.
+func (r *NativeReceiver) Start(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.done != nil {
+		return fmt.Errorf("WAL receiver already started")
+	}
+	if r.Dir == "" {
+		return fmt.Errorf("dir not specified")
+	}
+	if err := os.MkdirAll(r.Dir, 0o700); err != nil {
+		return err
+	}
+
+	r.errCh = make(chan error, 1)
+	if err := r.connect(ctx, true); err != nil {
+		return err
+	}
+	r.lastSync = time.Now()
+	if CompressSuffix(r.Compress) != "" {
+		r.compressq = make(chan string, 64)
+		r.compressDone = make(chan struct{})
+		go r.compressLoop()
+	}
+
+	loopCtx, cancel := context.WithCancel(ctx)
+	r.cancel = cancel
+	r.done = make(chan struct{})
+	go r.run(loopCtx)
+	return nil
+}
+
+// connect opens the replication connection and enters streaming mode. The first
+// connection also creates the slot, learns segment size and timeline and picks the
+// start position from Dir; a reconnect resumes exactly at the written position.
+func (r *NativeReceiver) connect(ctx context.Context, first bool) error {
+	conn, err := postgres.ConnectReplication(ctx, r.ConnString, r.AppName)
+	if err != nil {
+		return err
+	}
+	sys, err := postgres.IdentifySystem(ctx, conn)
+	if err != nil {
+		_ = conn.Close(ctx)
+		return err
+	}
+
+	var start postgres.LSN
+	if first {
+		sizeStr, err := postgres.ShowSetting(ctx, conn, "wal_segment_size")
+		if err != nil {
+			_ = conn.Close(ctx)
+			return err
+		}
+		if r.segSize, err = ParseSegmentSize(sizeStr); err != nil {
+			_ = conn.Close(ctx)
+			return err
+		}
+		r.timeline = sys.Timeline
+
+		if r.CreateSlot && r.Slot != "" {
+			if err := postgres.CreatePhysicalSlot(ctx, conn, r.Slot); err != nil {
+				_ = conn.Close(ctx)
+				return err
+			}
+			slog.Info("replication slot created", "slot", r.Slot)
+		}
+		if sys.Timeline > 1 {
+			if err := FetchHistory(ctx, conn, r.Dir, sys.Timeline); err != nil {
+				_ = conn.Close(ctx)
+				return err
+			}
+		}
+		start = r.findStart(sys.XLogPos)
+		r.written.Store(uint64(start))
+		r.flushed.Store(uint64(start))
+	} else {
+		if sys.Timeline != r.timeline {
+			_ = conn.Close(ctx)
+			return fmt.Errorf("%w: primary moved from timeline %d to %d", ErrTimelineSwitch, r.timeline, sys.Timeline)
+		}
+		start = r.WrittenLSN()
+	}
+
+	cmd := "START_REPLICATION"
+	if r.Slot != "" {
+		cmd += " SLOT " + postgres.QuoteIdent(r.Slot)
+	}
+	cmd += fmt.Sprintf(" PHYSICAL %s TIMELINE %d", start, r.timeline)
+	if err := startCopyBoth(ctx, conn, cmd); err != nil {
+		_ = conn.Close(ctx)
+		return err
+	}
+	slog.Info("WAL streaming started", "start_lsn", start, "tli", r.timeline, "segment_size", r.segSize)
+	r.conn = conn
+	return nil
+}
+
+// run streams until ctx is canceled. Failures are retried via connect up to Restarts
+// times when a slot is used; the final error is published on Err().
+func (r *NativeReceiver) run(ctx context.Context) {
+	defer close(r.done)
+	restarts := 0
+	for {
+		err := r.stream(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		for err != nil && !errors.Is(err, ErrTimelineSwitch) && r.Slot != "" && restarts < r.Restarts {
+			restarts++
+			slog.Warn("WAL streaming interrupted, reconnecting", "slot", r.Slot, "attempt", restarts, "lsn", r.WrittenLSN(), "err", err)
+			_ = r.conn.Close(context.Background())
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(restartDelay(restarts)):
+			}
+			err = r.connect(ctx, false)
+			if ctx.Err() != nil {
+				return
+			}
+		}
+		if err != nil {
+			r.err = err
+			slog.Error("WAL receiver exited", "err", err)
+			r.errCh <- err
+			return
+		}
+	}
+}
+
+// Err reports a streaming failure that was not caused by Stop.
+func (r *NativeReceiver) Err() <-chan error { return r.errCh }
+
+// Stop ends streaming, fsyncs the current .partial segment and drops the slot created by Start.
+func (r *NativeReceiver) Stop() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	cancel, done := r.cancel, r.done
+	r.mu.Unlock()
+
+	if done == nil {
+		return nil
+	}
+	cancel()
+	<-done
+
+	err := r.closeSegment()
+	if r.compressq != nil {
+		close(r.compressq)
+		<-r.compressDone
+	}
+
+	ctx, stop := context.WithTimeout(context.Background(), 30*time.Second)
+	defer stop()
+	_ = r.conn.Close(ctx)
+
+	if r.CreateSlot && r.Slot != "" {
+		conn, cerr := postgres.ConnectReplication(ctx, r.ConnString, "")
+		if cerr != nil {
+			slog.Warn("drop slot: connect", "slot", r.Slot, "err", cerr)
+			return err
+		}
+		if derr := postgres.DropSlot(ctx, conn, r.Slot); derr != nil {
+			slog.Warn("drop slot", "slot", r.Slot, "err", derr)
+		}
+		_ = conn.Close(ctx)
+	}
+	return err
+}
+
+// WaitFor blocks until WAL up to lsn has been written into Dir.
+func (r *NativeReceiver) WaitFor(ctx context.Context, lsn postgres.LSN, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		if r.WrittenLSN() >= lsn {
+			return nil
+		}
+		select {
+		case <-r.done:
+			return fmt.Errorf("WAL receiver stopped at %s before reaching %s: %v", r.WrittenLSN(), lsn, r.err)
+		default:
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("WAL up to %s not received within %s (received up to %s)", lsn, timeout, r.WrittenLSN())
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+// stream runs the CopyBoth loop until ctx is canceled or the server ends streaming.
+func (r *NativeReceiver) stream(ctx context.Context) error {
+	interval := r.StatusInterval
+	if interval <= 0 {
+		interval = DefaultStatusInterval
+	}
+	nextStatus := time.Now()
+	for {
+		now := time.Now()
+		if !now.Before(nextStatus) {
+			if err := r.sendStatus(); err != nil {
+				return err
+			}
+			nextStatus = now.Add(interval)
+		}
+		deadline := nextStatus
+		if r.dirty() {
+			if d := r.lastSync.Add(syncInterval); d.Before(deadline) {
+				deadline = d
+			}
+		}
+
+		rctx, cancel := context.WithDeadline(ctx, deadline)
+		msg, err := r.conn.ReceiveMessage(rctx)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if !pgconn.Timeout(err) {
+				return err
+			}
+		}
+
+		switch m := msg.(type) {
+		case *pgproto3.CopyData:
+			if err := r.handleCopyData(m.Data); err != nil {
+				return err
+			}
+		case *pgproto3.CopyDone:
+			return fmt.Errorf("%w: server ended timeline %d at %s", ErrTimelineSwitch, r.timeline, r.WrittenLSN())
+		case *pgproto3.ErrorResponse:
+			return pgconn.ErrorResponseToPgError(m)
+		}
+
+		if r.dirty() && time.Since(r.lastSync) >= syncInterval {
+			if err := r.sync(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handleCopyData processes XLogData ('w') and primary keepalive ('k') messages.
+func (r *NativeReceiver) handleCopyData(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	switch data[0] {
+	case 'w':
+		if len(data) < 25 {
+			return fmt.Errorf("short XLogData message")
+		}
+		start := postgres.LSN(binary.BigEndian.Uint64(data[1:9]))
+		return r.write(start, data[25:])
+	case 'k':
+		if len(data) < 18 {
+			return fmt.Errorf("short keepalive message")
+		}
+		if data[17] == 1 {
+			if err := r.sync(); err != nil {
+				return err
+			}
+			return r.sendStatus()
+		}
+	}
+	return nil
+}
+
+// write stores WAL bytes starting at start, switching and completing segments as needed.
+func (r *NativeReceiver) write(start postgres.LSN, data []byte) error {
+	if cur := r.WrittenLSN(); start != cur {
+		return fmt.Errorf("unexpected WAL position %s, expected %s", start, cur)
+	}
+	for len(data) > 0 {
+		segNo := SegmentNo(start, r.segSize)
+		off := uint64(start) % r.segSize
+		if r.seg == nil || r.seg.no != segNo {
+			if err := r.closeSegment(); err != nil {
+				return err
+			}
+			if err := r.openSegment(segNo); err != nil {
+				return err
+			}
+		}
+		n := uint64(len(data))
+		if rest := r.segSize - off; n > rest {
+			n = rest
+		}
+		if _, err := r.seg.f.WriteAt(data[:n], int64(off)); err != nil {
+			return fmt.Errorf("write %s: %w", r.seg.name, err)
+		}
+		start += postgres.LSN(n)
+		data = data[n:]
+		r.written.Store(uint64(start))
+		if off+n == r.segSize {
+			if err := r.completeSegment(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// openSegment opens (or resumes) the .partial file of segNo, zero-padded to the full segment size.
+func (r *NativeReceiver) openSegment(segNo uint64) error {
+	name := SegmentName(r.timeline, segNo, r.segSize)
+	path := filepath.Join(r.Dir, name+".partial")
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	if st, err := f.Stat(); err == nil && uint64(st.Size()) < r.segSize {
+		if err := f.Truncate(int64(r.segSize)); err != nil {
+			_ = f.Close()
+			return fmt.Errorf("pad %s: %w", path, err)
+		}
+	}
+	r.seg = &openSegment{no: segNo, name: name, f: f}
+	return nil
+}
+
+// completeSegment fsyncs the current segment and renames it to its final name.
+func (r *NativeReceiver) completeSegment() error {
+	seg := r.seg
+	r.seg = nil
+	if err := seg.f.Sync(); err != nil {
+		_ = seg.f.Close()
+		return err
+	}
+	if err := seg.f.Close(); err != nil {
+		return err
+	}
+	partial := filepath.Join(r.Dir, seg.name+".partial")
+	if err := os.Rename(partial, filepath.Join(r.Dir, seg.name)); err != nil {
+		return err
+	}
+	syncDir(r.Dir)
+	r.flushed.Store(r.written.Load())
+	r.lastSync = time.Now()
+	if r.compressq != nil {
+		r.compressq <- filepath.Join(r.Dir, seg.name)
+	}
+	return nil
+}
+
+// compressLoop compresses completed segments off the streaming path; a segment that
+// fails to compress stays uncompressed, which is still valid WAL.
+func (r *NativeReceiver) compressLoop() {
+	defer close(r.compressDone)
+	for path := range r.compressq {
+		if _, err := CompressFile(path, r.Compress); err != nil {
+			slog.Warn("WAL segment left uncompressed", "file", filepath.Base(path), "err", err)
+		}
+	}
+}
+
+// closeSegment fsyncs and closes the current segment keeping its .partial name.
+func (r *NativeReceiver) closeSegment() error {
+	if r.seg == nil {
+		return nil
+	}
+	if err := r.sync(); err != nil {
+		_ = r.seg.f.Close()
+		r.seg = nil
+		return err
+	}
+	err := r.seg.f.Close()
+	r.seg = nil
+	return err
+}
+
+func (r *NativeReceiver) dirty() bool { return r.written.Load() != r.flushed.Load() }
+
+func (r *NativeReceiver) sync() error {
+	if r.seg != nil {
+		if err := r.seg.f.Sync(); err != nil {
+			return fmt.Errorf("fsync %s: %w", r.seg.name, err)
+		}
+	}
+	r.flushed.Store(r.written.Load())
+	r.lastSync = time.Now()
+	return nil
+}
+
+// sendStatus sends a standby status update: write and flush positions, apply left invalid like pg_receivewal.
+func (r *NativeReceiver) sendStatus() error {
+	buf := make([]byte, 34)
+	buf[0] = 'r'
+	binary.BigEndian.PutUint64(buf[1:], r.written.Load())
+	binary.BigEndian.PutUint64(buf[9:], r.flushed.Load())
+	binary.BigEndian.PutUint64(buf[17:], 0)
+	binary.BigEndian.PutUint64(buf[25:], uint64(pgTime(time.Now())))
+	buf[33] = 0
+	r.conn.Frontend().Send(&pgproto3.CopyData{Data: buf})
+	if err := r.conn.Frontend().Flush(); err != nil {
+		return fmt.Errorf("send standby status: %w", err)
+	}
+	return nil
+}
+
+// findStart resumes after WAL already present in Dir on the current timeline, else starts
+// at the beginning of the server's current segment.
+func (r *NativeReceiver) findStart(serverPos postgres.LSN) postgres.LSN {
+	serverSeg := SegmentNo(serverPos, r.segSize)
+	start, found := uint64(0), false
+	entries, _ := os.ReadDir(r.Dir)
+	for _, e := range entries {
+		tli, segNo, err := ParseSegmentName(e.Name(), r.segSize)
+		if err != nil || tli != r.timeline {
+			continue
+		}
+		next := segNo
+		if !strings.HasSuffix(e.Name(), ".partial") {
+			next = segNo + 1 // complete segment: continue with the following one
+		}
+		if !found || next > start {
+			start, found = next, true
+		}
+	}
+	if !found || start > serverSeg {
+		start = serverSeg
+	}
+	return SegmentStart(start, r.segSize)
+}
+
+// startCopyBoth sends a replication command and waits for CopyBothResponse.
+func startCopyBoth(ctx context.Context, conn *pgconn.PgConn, cmd string) error {
+	conn.Frontend().Send(&pgproto3.Query{String: cmd})
+	if err := conn.Frontend().Flush(); err != nil {
+		return fmt.Errorf("%s: %w", cmd, err)
+	}
+	for {
+		msg, err := conn.ReceiveMessage(ctx)
+		if err != nil {
+			return fmt.Errorf("%s: %w", cmd, err)
+		}
+		switch m := msg.(type) {
+		case *pgproto3.CopyBothResponse:
+			return nil
+		case *pgproto3.ErrorResponse:
+			return fmt.Errorf("%s: %w", cmd, pgconn.ErrorResponseToPgError(m))
+		case *pgproto3.NoticeResponse:
+		default:
+			return fmt.Errorf("%s: unexpected message %T", cmd, msg)
+		}
+	}
+}
+
+// pgEpoch is the PostgreSQL timestamp epoch (2000-01-01 UTC).
+var pgEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// pgTime converts t to microseconds since the PostgreSQL epoch.
+func pgTime(t time.Time) int64 { return t.Sub(pgEpoch).Microseconds() }
+
+// syncDir fsyncs a directory so renames are durable; errors are ignored (best effort).
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	_ = d.Close()
+}
@@ -0,0 +1,71 @@
+package wal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func xlogData(start uint64, payload []byte) []byte {
+	msg := make([]byte, 25, 25+len(payload))
+	msg[0] = 'w'
+	binary.BigEndian.PutUint64(msg[1:], start)
+	binary.BigEndian.PutUint64(msg[9:], start+uint64(len(payload)))
+	return append(msg, payload...)
+}
+
+func TestNativeReceiverWritesSegments(t *testing.T) {
+	dir := t.TempDir()
+	r := &NativeReceiver{Dir: dir, segSize: 32, timeline: 1}
+
+	payload := bytes.Repeat([]byte{0xAB}, 40) // one full segment + 8 bytes of the next
+	if err := r.handleCopyData(xlogData(0, payload)); err != nil {
+		t.Fatalf("handle: %v", err)
+	}
+	if err := r.closeSegment(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	full := filepath.Join(dir, SegmentName(1, 0, 32))
+	data, err := os.ReadFile(full)
+	if err != nil || len(data) != 32 {
+		t.Fatalf("complete segment: len=%d err=%v", len(data), err)
+	}
+	partial := filepath.Join(dir, SegmentName(1, 1, 32)+".partial")
+	data, err = os.ReadFile(partial)
+	if err != nil || len(data) != 32 || data[7] != 0xAB || data[8] != 0 {
+		t.Fatalf("partial segment must be zero padded: %v err=%v", data, err)
+	}
+	if r.FlushLSN() != 40 || r.WrittenLSN() != 40 {
+		t.Fatalf("unexpected positions written=%s flushed=%s", r.WrittenLSN(), r.FlushLSN())
+	}
+
+	// a gap in the stream must be reported
+	if err := r.handleCopyData(xlogData(64, []byte{1})); err == nil {
+		t.Fatalf("expected error for non-contiguous WAL")
+	}
+}
+
+func TestNativeReceiverFindStart(t *testing.T) {
+	dir := t.TempDir()
+	r := &NativeReceiver{Dir: dir, segSize: 16 << 20, timeline: 2}
+	for _, name := range []string{
+		SegmentName(2, 5, r.segSize),
+		SegmentName(2, 6, r.segSize) + ".partial",
+		SegmentName(1, 9, r.segSize), // other timeline is ignored
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := SegmentStart(8, r.segSize) + 100
+	if got := r.findStart(server); got != SegmentStart(6, r.segSize) {
+		t.Fatalf("expected resume at partial segment, got %s", got)
+	}
+	empty := &NativeReceiver{Dir: t.TempDir(), segSize: 16 << 20, timeline: 2}
+	if got := empty.findStart(server); got != SegmentStart(8, r.segSize) {
+		t.Fatalf("expected server segment start, got %s", got)
+	}
+}
@@ -2,25 +2,42 @@ package wal
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/vbp1/pgclone/internal/postgres"
 )
 
 // Receiver wraps pg_receivewal process lifecycle.
 type Receiver struct {
-	Host    string
-	Port    int
-	User    string
-	Dir     string // target directory for WAL
-	Slot    string // optional; empty = no slot
-	Verbose bool
-
-	AppName string // optional application_name (sets PGAPPNAME)
+	ConnString string // primary conninfo (keyword/value or URI)
+	Dir        string // target directory for WAL
+	Slot       string // optional; empty = no slot
+	Verbose    bool
+
+	CreateSlot  bool   // create Slot before streaming and drop it on Stop
+	Compress    string // pg_receivewal --compress method (CompressGzip or CompressLZ4); empty = none
+	SegmentSize uint64 // wal_segment_size; used by WaitFor (0 = DefaultSegmentSize)
+
+	// Restarts is how many times pg_receivewal is relaunched after an unexpected exit.
+	// Only used together with Slot, which guarantees the server kept the missing WAL.
+	Restarts int
+
+	AppName string // optional application_name, overrides the one in ConnString
+	bin     string
+	conn    postgres.Conninfo
 	cmd     *exec.Cmd
+	errCh   chan error
+	stop    chan struct{} // closed by Stop; ends a restart backoff
 	wg      sync.WaitGroup
 	mu      sync.Mutex
 	closed  bool
@@ -40,33 +57,53 @@ func (r *Receiver) Start(ctx context.Context) error {
 		return err
 	}
 
-	args := []string{
-		"--host", r.Host,
-		"--port", fmt.Sprintf("%d", r.Port),
-		"--username", r.User,
-		"--no-password",
-		"--directory", r.Dir,
+	bin, err := exec.LookPath("pg_receivewal")
+	if err != nil {
+		return err
+	}
+	r.bin = bin
+	if r.conn, err = postgres.ParseConninfo(r.ConnString); err != nil {
+		return err
+	}
+
+	if r.CreateSlot && r.Slot != "" {
+		create := exec.CommandContext(ctx, bin, append(r.connArgs(), "--create-slot", "--if-not-exists", "--slot", r.Slot)...)
+		create.Env = r.env()
+		if out, err := create.CombinedOutput(); err != nil {
+			return fmt.Errorf("create slot %s: %w\n%s", r.Slot, err, out)
+		}
+		slog.Info("replication slot created", "slot", r.Slot)
 	}
+
+	r.errCh = make(chan error, 1)
+	r.stop = make(chan struct{})
+	return r.launch(ctx, 0)
+}
+
+// launch starts one pg_receivewal process and watches it; must be called with r.mu held.
+// With a slot pg_receivewal resumes from the segments already in Dir, so an unexpected
+// exit is followed by up to Restarts relaunches before the failure is reported on Err().
+func (r *Receiver) launch(ctx context.Context, restarts int) error {
+	args := append(r.connArgs(), "--directory", r.Dir)
 	if r.Slot != "" {
 		args = append(args, "--slot", r.Slot)
 	}
+	if CompressSuffix(r.Compress) != "" {
+		args = append(args, "--compress="+r.Compress)
+	}
 	if r.Verbose {
 		args = append(args, "--verbose")
 	}
 
-	bin, err := exec.LookPath("pg_receivewal")
-	if err != nil {
-		return err
-	}
-
-	cmd := exec.CommandContext(ctx, bin, args...)
-	if r.AppName != "" {
-		// inherit current env and add PGAPPNAME
-		cmd.Env = append(os.Environ(), "PGAPPNAME="+r.AppName)
-	}
-	// Redirect outputs to log file under Dir
+	cmd := exec.CommandContext(ctx, r.bin, args...)
+	cmd.Env = r.env()
+	// Redirect outputs to log file under Dir (appended across restarts)
 	logFile := filepath.Join(r.Dir, "pg_receivewal.log")
-	lf, err := os.Create(logFile)
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if restarts > 0 {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	lf, err := os.OpenFile(logFile, flags, 0o644)
 	if err != nil {
 		return err
 	}
@@ -84,14 +121,52 @@ func (r *Receiver) Start(ctx context.Context) error {
 		defer r.wg.Done()
 		err := cmd.Wait()
 		_ = lf.Close()
-		if err != nil && !r.closed {
-			slog.Warn("pg_receivewal exited", "err", err)
+
+		if err == nil {
+			err = fmt.Errorf("exited unexpectedly")
+		}
+		err = fmt.Errorf("pg_receivewal: %w (see %s)", err, logFile)
+		if r.Slot != "" && restarts < r.Restarts && !r.stopped(ctx) {
+			slog.Warn("pg_receivewal exited, restarting", "slot", r.Slot, "attempt", restarts+1, "err", err)
+			// not under r.mu: Stop and Err must not wait for the backoff
+			timer := time.NewTimer(restartDelay(restarts + 1))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-r.stop:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
 		}
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.closed || ctx.Err() != nil {
+			return
+		}
+		if r.Slot != "" && restarts < r.Restarts {
+			lerr := r.launch(ctx, restarts+1)
+			if lerr == nil {
+				return
+			}
+			err = lerr
+		}
+		slog.Error("pg_receivewal exited", "err", err)
+		r.errCh <- err
 	}()
 
 	return nil
 }
 
+// stopped reports whether Stop was called or ctx ended.
+func (r *Receiver) stopped(ctx context.Context) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.closed || ctx.Err() != nil
+}
+
 // Stop terminates pg_receivewal process gracefully.
 func (r *Receiver) Stop() error {
 	r.mu.Lock()
@@ -100,6 +175,9 @@ func (r *Receiver) Stop() error {
 		return nil
 	}
 	r.closed = true
+	if r.stop != nil {
+		close(r.stop)
+	}
 	cmd := r.cmd
 	r.mu.Unlock()
 
@@ -107,7 +185,7 @@ func (r *Receiver) Stop() error {
 		return nil
 	}
 	// Send SIGTERM
-	if err := cmd.Process.Signal(os.Interrupt); err != nil {
+	if err := cmd.Process.Signal(os.Interrupt); err != nil && !errors.Is(err, os.ErrProcessDone) {
 		return err
 	}
 	done := make(chan struct{})
@@ -117,14 +195,11 @@ func (r *Receiver) Stop() error {
 	}()
 	select {
 	case <-done:
-		// after done, drop replication slot if set
-		if r.Slot != "" {
+		// after done, drop the replication slot we created
+		if r.CreateSlot && r.Slot != "" {
 			// drop slot via pg_receivewal --drop-slot
-			dropCmd := exec.Command("pg_receivewal",
-				"--host", r.Host,
-				"--port", fmt.Sprintf("%d", r.Port),
-				"--username", r.User,
-				"--no-password", "--drop-slot", "--slot", r.Slot)
+			dropCmd := exec.Command("pg_receivewal", append(r.connArgs(), "--drop-slot", "--slot", r.Slot)...)
+			dropCmd.Env = r.env()
 			_ = dropCmd.Run()
 		}
 		return nil
@@ -132,3 +207,79 @@ func (r *Receiver) Stop() error {
 		return fmt.Errorf("context closed")
 	}
 }
+
+// Directory returns the directory WAL is written to.
+func (r *Receiver) Directory() string { return r.Dir }
+
+// Err reports a pg_receivewal failure that was not caused by Stop.
+func (r *Receiver) Err() <-chan error { return r.errCh }
+
+// WaitFor polls Dir until the completed segment holding the WAL just before lsn appears
+// (any timeline; lsn on a segment boundary ends the previous segment, as pg_walfile_name), or
+// its .partial does and the server reports WAL written up to lsn: a quiet primary may not
+// switch segments for a long time, and a standby cannot be made to.
+func (r *Receiver) WaitFor(ctx context.Context, lsn postgres.LSN, timeout time.Duration) error {
+	segSize := r.SegmentSize
+	if segSize == 0 {
+		segSize = DefaultSegmentSize
+	}
+	want := SegmentName(0, SegmentNo(lsn-1, segSize), segSize)[8:]
+	deadline := time.Now().Add(timeout)
+	for {
+		partial := false
+		entries, _ := os.ReadDir(r.Dir)
+		for _, e := range entries {
+			name, _ := TrimCompressSuffix(e.Name())
+			if IsSegmentName(name) && name[8:] == want {
+				return nil
+			}
+			if base, ok := strings.CutSuffix(name, ".partial"); ok && IsSegmentName(base) && base[8:] == want {
+				partial = true
+			}
+		}
+		if partial && r.AppName != "" {
+			ok, err := r.written(ctx, lsn)
+			if err != nil {
+				slog.Debug("pg_receivewal write position", "err", err)
+			}
+			if ok {
+				slog.Debug("WAL up to lsn written into partial segment", "lsn", lsn, "segment", want)
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("wal segment *%s not received", want)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// written asks the server whether pg_receivewal reported WAL written up to lsn.
+func (r *Receiver) written(ctx context.Context, lsn postgres.LSN) (bool, error) {
+	conn, err := pgx.Connect(ctx, r.conn.String())
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = conn.Close(ctx) }()
+	return postgres.ReplicationWritten(ctx, conn, r.AppName, lsn)
+}
+
+// connArgs returns pg_receivewal connection options. The password is kept out of the
+// command line (visible in ps) and passed through env instead.
+func (r *Receiver) connArgs() []string {
+	c := r.conn.Without("password").With("application_name", r.AppName)
+	return []string{"--dbname", c.String(), "--no-password"}
+}
+
+// env returns the environment for pg_receivewal invocations.
+func (r *Receiver) env() []string {
+	env := os.Environ()
+	if pw := r.conn["password"]; pw != "" {
+		env = append(env, "PGPASSWORD="+pw)
+	}
+	return env
+}
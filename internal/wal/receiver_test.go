@@ -0,0 +1,64 @@
+package wal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vbp1/pgclone/internal/postgres"
+)
+
+func TestReceiverStopDuringRestartBackoff(t *testing.T) {
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "pg_receivewal"), []byte("#!/bin/sh\nexit 1\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin)
+	r := &Receiver{ConnString: "host=db1", Dir: t.TempDir(), Slot: "s1", Restarts: 3}
+	if err := r.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond) // exited, waiting restartDelay(1)
+
+	start := time.Now()
+	if err := r.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("Stop waited %s for the restart backoff", d)
+	}
+	select {
+	case err := <-r.Err():
+		t.Fatalf("failure reported after Stop: %v", err)
+	default:
+	}
+}
+
+func TestReceiverWaitFor(t *testing.T) {
+	dir := t.TempDir()
+	r := &Receiver{Dir: dir, SegmentSize: 16 << 20}
+	lsn := postgres.LSN(3<<24 + 100) // in segment 3
+	partial := filepath.Join(dir, "000000010000000000000003.partial")
+	if err := os.WriteFile(partial, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// a partial alone needs the server to confirm the write position
+	if err := r.WaitFor(context.Background(), lsn, 0); err == nil {
+		t.Fatal("partial segment accepted without a confirmed write position")
+	}
+	if err := os.Rename(partial, filepath.Join(dir, "000000010000000000000003.gz")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.WaitFor(context.Background(), lsn, 0); err != nil {
+		t.Fatal(err)
+	}
+	// on the boundary the WAL before lsn is in segment 3, segment 4 may never complete
+	if err := r.WaitFor(context.Background(), postgres.LSN(4<<24), 0); err != nil {
+		t.Fatalf("boundary lsn: %v", err)
+	}
+	if err := r.WaitFor(context.Background(), postgres.LSN(4<<24+1), 0); err == nil {
+		t.Fatal("segment 4 reported received")
+	}
+}
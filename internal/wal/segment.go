@@ -0,0 +1,86 @@
+package wal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/vbp1/pgclone/internal/postgres"
+)
+
+// DefaultSegmentSize is the compiled-in default wal_segment_size.
+const DefaultSegmentSize = 16 << 20
+
+// segmentsPerXLogID returns how many segments share one "xlogid" (high 32 bits of an LSN).
+func segmentsPerXLogID(segSize uint64) uint64 { return 0x100000000 / segSize }
+
+// SegmentNo returns the segment number containing lsn.
+func SegmentNo(lsn postgres.LSN, segSize uint64) uint64 { return uint64(lsn) / segSize }
+
+// SegmentStart returns the first LSN of segment segNo.
+func SegmentStart(segNo, segSize uint64) postgres.LSN { return postgres.LSN(segNo * segSize) }
+
+// SegmentName returns the WAL file name (as pg_walfile_name) of segment segNo on timeline tli.
+func SegmentName(tli uint32, segNo, segSize uint64) string {
+	per := segmentsPerXLogID(segSize)
+	return fmt.Sprintf("%08X%08X%08X", tli, segNo/per, segNo%per)
+}
+
+// IsSegmentName reports whether name looks like a complete WAL segment file name.
+func IsSegmentName(name string) bool {
+	if len(name) != 24 {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if (c < '0' || c > '9') && (c < 'A' || c > 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseSegmentName returns the timeline and segment number encoded in a WAL file name.
+// Compression and ".partial" suffixes are accepted.
+func ParseSegmentName(name string, segSize uint64) (tli uint32, segNo uint64, err error) {
+	base, _ := TrimCompressSuffix(name)
+	base = strings.TrimSuffix(base, ".partial")
+	if !IsSegmentName(base) {
+		return 0, 0, fmt.Errorf("not a WAL segment name: %q", name)
+	}
+	t, _ := strconv.ParseUint(base[0:8], 16, 32)
+	hi, _ := strconv.ParseUint(base[8:16], 16, 32)
+	lo, _ := strconv.ParseUint(base[16:24], 16, 32)
+	return uint32(t), hi*segmentsPerXLogID(segSize) + lo, nil
+}
+
+// HistoryFileName returns the name of the timeline history file for tli.
+func HistoryFileName(tli uint32) string { return fmt.Sprintf("%08X.history", tli) }
+
+// ParseSegmentSize parses wal_segment_size as reported by SHOW (e.g. "16MB", "1GB").
+func ParseSegmentSize(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	n, err := strconv.ParseUint(s[:i], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid wal_segment_size %q", s)
+	}
+	switch strings.TrimSpace(s[i:]) {
+	case "", "B":
+	case "kB":
+		n <<= 10
+	case "MB":
+		n <<= 20
+	case "GB":
+		n <<= 30
+	default:
+		return 0, fmt.Errorf("invalid wal_segment_size %q", s)
+	}
+	if n == 0 || n&(n-1) != 0 {
+		return 0, fmt.Errorf("invalid wal_segment_size %q: not a power of two", s)
+	}
+	return n, nil
+}
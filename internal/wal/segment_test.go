@@ -0,0 +1,42 @@
+package wal
+
+import (
+	"testing"
+
+	"github.com/vbp1/pgclone/internal/postgres"
+)
+
+func TestSegmentName(t *testing.T) {
+	lsn, err := postgres.ParseLSN("1/2A000028")
+	if err != nil {
+		t.Fatalf("parse lsn: %v", err)
+	}
+	const seg = 16 << 20
+	name := SegmentName(3, SegmentNo(lsn, seg), seg)
+	if name != "00000003000000010000002A" {
+		t.Fatalf("unexpected segment name %s", name)
+	}
+	tli, segNo, err := ParseSegmentName(name+".partial", seg)
+	if err != nil || tli != 3 || segNo != SegmentNo(lsn, seg) {
+		t.Fatalf("round trip failed: tli=%d segNo=%d err=%v", tli, segNo, err)
+	}
+	if SegmentStart(segNo, seg).String() != "1/2A000000" {
+		t.Fatalf("unexpected segment start %s", SegmentStart(segNo, seg))
+	}
+	if IsSegmentName(name+".partial") || IsSegmentName("00000001.history") {
+		t.Fatalf("non-segment names accepted")
+	}
+}
+
+func TestParseSegmentSize(t *testing.T) {
+	cases := map[string]uint64{"16MB": 16 << 20, "1GB": 1 << 30, "1048576": 1 << 20}
+	for in, want := range cases {
+		got, err := ParseSegmentSize(in)
+		if err != nil || got != want {
+			t.Fatalf("%s: got %d err %v, want %d", in, got, err, want)
+		}
+	}
+	if _, err := ParseSegmentSize("3MB"); err == nil {
+		t.Fatalf("expected error for non power of two")
+	}
+}
@@ -0,0 +1,24 @@
+package wal
+
+import (
+	"context"
+	"time"
+
+	"github.com/vbp1/pgclone/internal/postgres"
+)
+
+// Streamer is a running WAL receiver that writes segments into Directory()
+// using pg_receivewal naming (complete segments plus one trailing ".partial").
+type Streamer interface {
+	Start(ctx context.Context) error
+	Stop() error
+	Directory() string
+	// WaitFor blocks until WAL up to lsn has been written into Directory().
+	WaitFor(ctx context.Context, lsn postgres.LSN, timeout time.Duration) error
+	// Err delivers the error that ended streaming (after restarts are exhausted).
+	// Nothing is sent when streaming ends because of Stop or context cancellation.
+	Err() <-chan error
+}
+
+// restartDelay is the pause before reconnect attempt n (1-based).
+func restartDelay(n int) time.Duration { return time.Duration(n) * 2 * time.Second }
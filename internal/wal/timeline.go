@@ -0,0 +1,37 @@
+package wal
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/vbp1/pgclone/internal/postgres"
+)
+
+// ErrTimelineSwitch means the primary moved to a new timeline (failover/promotion) while WAL was being received.
+var ErrTimelineSwitch = errors.New("timeline switch")
+
+// FetchHistory stores the history file of timeline tli into dir unless it is already there.
+// conn must be a replication connection.
+func FetchHistory(ctx context.Context, conn *pgconn.PgConn, dir string, tli uint32) error {
+	path := filepath.Join(dir, HistoryFileName(tli))
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	name, content, err := postgres.TimelineHistory(ctx, conn, tli)
+	if err != nil {
+		return err
+	}
+	if name != HistoryFileName(tli) {
+		slog.Warn("unexpected timeline history file name", "name", name)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
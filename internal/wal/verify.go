@@ -0,0 +1,304 @@
+package wal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+
+	"github.com/vbp1/pgclone/internal/postgres"
+)
+
+// On-disk WAL layout (access/xlog_internal.h, access/xlogrecord.h).
+const (
+	shortPageHeaderSize = 24
+	longPageHeaderSize  = 40
+	recordHeaderSize    = 24
+	recordCRCOffset     = 20
+	maxRecordSize       = 1020 << 20 // XLogRecordMaxSize
+
+	xlpFirstIsContRecord = 0x0001
+	xlpLongHeader        = 0x0002
+	xlpAllFlags          = 0x000F
+
+	rmXLogID   = 0
+	xlogSwitch = 0x40
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// VerifyError points at the first WAL segment that is missing or damaged.
+type VerifyError struct {
+	Segment string       // WAL file name
+	LSN     postgres.LSN // position of the problem
+	Reason  string
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("WAL segment %s: %s (at %s)", e.Segment, e.Reason, e.LSN)
+}
+
+// VerifyStats summarises a successful verification.
+type VerifyStats struct {
+	Segments int
+	Pages    int
+	Records  int
+}
+
+// Verifier checks the WAL a backup needs: every segment from the start LSN's one through
+// the stop LSN must exist on Timeline with valid page headers and the records in
+// [start, stop) must chain via xl_prev and match their CRC32C.
+type Verifier struct {
+	Dir         string
+	Timeline    uint32
+	SegmentSize uint64 // 0 = DefaultSegmentSize
+	SystemID    uint64 // optional; 0 = take from the first segment
+
+	pageSize uint64
+	magic    uint16
+	lastTLI  uint32
+
+	segNo   uint64
+	segName string
+	seg     *os.File
+
+	pageLSN postgres.LSN
+	page    []byte
+	stats   VerifyStats
+}
+
+type pageHeader struct {
+	magic    uint16
+	info     uint16
+	tli      uint32
+	pageAddr postgres.LSN
+	remLen   uint32
+	size     uint64
+}
+
+// Verify walks WAL from start to stop; the returned error is a *VerifyError for WAL problems.
+func (v *Verifier) Verify(start, stop postgres.LSN) (VerifyStats, error) {
+	if v.SegmentSize == 0 {
+		v.SegmentSize = DefaultSegmentSize
+	}
+	defer v.closeSegment()
+	if err := v.readPageSize(start); err != nil {
+		return v.stats, err
+	}
+
+	pos, prev := start, postgres.LSN(0)
+	for pos < stop {
+		if uint64(pos)%v.pageSize == 0 {
+			if err := v.loadPage(pos); err != nil {
+				return v.stats, err
+			}
+			hdr := v.header()
+			pos += postgres.LSN(hdr.size)
+			if hdr.info&xlpFirstIsContRecord != 0 {
+				pos += postgres.LSN(maxAlign(uint64(hdr.remLen)))
+			}
+			continue
+		}
+		rec, next, err := v.readRecord(pos)
+		if err != nil {
+			return v.stats, err
+		}
+		if p := postgres.LSN(binary.LittleEndian.Uint64(rec[8:16])); prev != 0 && p != prev {
+			return v.stats, v.fail(pos, fmt.Sprintf("record xl_prev %s, expected %s", p, prev))
+		}
+		crc := crc32.Update(0, crcTable, rec[recordHeaderSize:])
+		crc = crc32.Update(crc, crcTable, rec[:recordCRCOffset])
+		if want := binary.LittleEndian.Uint32(rec[recordCRCOffset:]); crc != want {
+			return v.stats, v.fail(pos, fmt.Sprintf("record CRC mismatch (%08X, expected %08X)", crc, want))
+		}
+		v.stats.Records++
+		prev = pos
+
+		if rec[17] == rmXLogID && rec[16]&0xF0 == xlogSwitch {
+			// the rest of the segment is unused
+			pos = SegmentStart(SegmentNo(pos, v.SegmentSize)+1, v.SegmentSize)
+			continue
+		}
+		pos = postgres.LSN(maxAlign(uint64(next)))
+	}
+	return v.stats, nil
+}
+
+// readRecord returns the record at pos reassembled across page boundaries and the LSN right after it.
+func (v *Verifier) readRecord(pos postgres.LSN) ([]byte, postgres.LSN, error) {
+	start := pos
+	total := -1
+	rec := make([]byte, 0, recordHeaderSize)
+	for total < 0 || len(rec) < total {
+		if uint64(pos)%v.pageSize == 0 {
+			if err := v.loadPage(pos); err != nil {
+				return nil, 0, err
+			}
+			hdr := v.header()
+			if hdr.info&xlpFirstIsContRecord == 0 || int(hdr.remLen) != total-len(rec) {
+				return nil, 0, v.fail(pos, fmt.Sprintf("continuation of record %s missing (rem_len %d, expected %d)", start, hdr.remLen, total-len(rec)))
+			}
+			pos += postgres.LSN(hdr.size)
+		}
+		if err := v.loadPage(pos); err != nil {
+			return nil, 0, err
+		}
+		off := uint64(pos) % v.pageSize
+		want := recordHeaderSize - len(rec)
+		if total >= 0 {
+			want = total - len(rec)
+		}
+		n := min(uint64(want), v.pageSize-off)
+		rec = append(rec, v.page[off:off+n]...)
+		pos += postgres.LSN(n)
+
+		if total < 0 {
+			// xl_tot_len is 8-aligned, so it never crosses a page
+			total = int(binary.LittleEndian.Uint32(rec[0:4]))
+			if total < recordHeaderSize || total > maxRecordSize {
+				return nil, 0, v.fail(start, fmt.Sprintf("invalid record length %d", total))
+			}
+			if cap(rec) < total {
+				rec = append(make([]byte, 0, total), rec...)
+			}
+		}
+	}
+	return rec, pos, nil
+}
+
+// readPageSize learns XLOG_BLCKSZ from the long header of the segment containing lsn.
+func (v *Verifier) readPageSize(lsn postgres.LSN) error {
+	segNo := SegmentNo(lsn, v.SegmentSize)
+	if err := v.openSegment(segNo, lsn); err != nil {
+		return err
+	}
+	buf := make([]byte, longPageHeaderSize)
+	if _, err := v.seg.ReadAt(buf, 0); err != nil {
+		return v.fail(SegmentStart(segNo, v.SegmentSize), "cannot read header: "+err.Error())
+	}
+	v.pageSize = uint64(binary.LittleEndian.Uint32(buf[36:40]))
+	if v.pageSize < longPageHeaderSize || v.pageSize&(v.pageSize-1) != 0 || v.SegmentSize%v.pageSize != 0 {
+		return v.fail(SegmentStart(segNo, v.SegmentSize), fmt.Sprintf("invalid page size %d", v.pageSize))
+	}
+	return nil
+}
+
+// loadPage makes the page containing lsn current, validating its header on first access.
+func (v *Verifier) loadPage(lsn postgres.LSN) error {
+	pageLSN := lsn - lsn%postgres.LSN(v.pageSize)
+	if v.page != nil && pageLSN == v.pageLSN {
+		return nil
+	}
+	segNo := SegmentNo(pageLSN, v.SegmentSize)
+	if v.seg == nil || segNo != v.segNo {
+		if err := v.openSegment(segNo, pageLSN); err != nil {
+			return err
+		}
+	}
+	if v.page == nil {
+		v.page = make([]byte, v.pageSize)
+	}
+	off := uint64(pageLSN) % v.SegmentSize
+	if _, err := v.seg.ReadAt(v.page, int64(off)); err != nil {
+		v.page = nil
+		return v.fail(pageLSN, "cannot read page: "+err.Error())
+	}
+	v.pageLSN = pageLSN
+	v.stats.Pages++
+	if err := v.checkPageHeader(pageLSN, off == 0); err != nil {
+		v.page = nil
+		return err
+	}
+	return nil
+}
+
+// checkPageHeader validates the header of the current page located at pageLSN.
+func (v *Verifier) checkPageHeader(pageLSN postgres.LSN, first bool) error {
+	hdr := v.header()
+	switch {
+	case hdr.magic == 0 && hdr.pageAddr == 0:
+		return v.fail(pageLSN, "page is empty (WAL not received up to here)")
+	case hdr.magic&0xFF00 != 0xD100:
+		return v.fail(pageLSN, fmt.Sprintf("invalid page magic %04X", hdr.magic))
+	case v.magic != 0 && hdr.magic != v.magic:
+		return v.fail(pageLSN, fmt.Sprintf("page magic %04X differs from %04X", hdr.magic, v.magic))
+	case hdr.info&^xlpAllFlags != 0:
+		return v.fail(pageLSN, fmt.Sprintf("invalid page info bits %04X", hdr.info))
+	case hdr.pageAddr != pageLSN:
+		return v.fail(pageLSN, fmt.Sprintf("unexpected page address %s", hdr.pageAddr))
+	case hdr.tli > v.Timeline || hdr.tli < v.lastTLI:
+		return v.fail(pageLSN, fmt.Sprintf("unexpected page timeline %d", hdr.tli))
+	case first != (hdr.info&xlpLongHeader != 0):
+		return v.fail(pageLSN, "long page header expected only at segment start")
+	}
+	v.magic, v.lastTLI = hdr.magic, hdr.tli
+	if !first {
+		return nil
+	}
+	sysID := binary.LittleEndian.Uint64(v.page[24:32])
+	segSize := binary.LittleEndian.Uint32(v.page[32:36])
+	blcksz := binary.LittleEndian.Uint32(v.page[36:40])
+	switch {
+	case v.SystemID != 0 && sysID != v.SystemID:
+		return v.fail(pageLSN, fmt.Sprintf("system identifier %d, expected %d", sysID, v.SystemID))
+	case uint64(segSize) != v.SegmentSize:
+		return v.fail(pageLSN, fmt.Sprintf("segment size %d, expected %d", segSize, v.SegmentSize))
+	case uint64(blcksz) != v.pageSize:
+		return v.fail(pageLSN, fmt.Sprintf("page size %d, expected %d", blcksz, v.pageSize))
+	}
+	v.SystemID = sysID
+	return nil
+}
+
+// header decodes the header of the current page.
+func (v *Verifier) header() pageHeader {
+	p := v.page
+	h := pageHeader{
+		magic:    binary.LittleEndian.Uint16(p[0:2]),
+		info:     binary.LittleEndian.Uint16(p[2:4]),
+		tli:      binary.LittleEndian.Uint32(p[4:8]),
+		pageAddr: postgres.LSN(binary.LittleEndian.Uint64(p[8:16])),
+		remLen:   binary.LittleEndian.Uint32(p[16:20]),
+		size:     shortPageHeaderSize,
+	}
+	if h.info&xlpLongHeader != 0 {
+		h.size = longPageHeaderSize
+	}
+	return h
+}
+
+// openSegment opens segment segNo on Timeline (complete file or .partial).
+func (v *Verifier) openSegment(segNo uint64, lsn postgres.LSN) error {
+	v.closeSegment()
+	v.segNo = segNo
+	v.segName = SegmentName(v.Timeline, segNo, v.SegmentSize)
+	for _, name := range []string{v.segName, v.segName + ".partial"} {
+		f, err := os.Open(filepath.Join(v.Dir, name))
+		if err != nil {
+			continue
+		}
+		st, err := f.Stat()
+		if err != nil || uint64(st.Size()) != v.SegmentSize {
+			_ = f.Close()
+			return v.fail(lsn, fmt.Sprintf("file %s has wrong size", name))
+		}
+		v.seg = f
+		v.stats.Segments++
+		return nil
+	}
+	return v.fail(lsn, "segment is missing")
+}
+
+func (v *Verifier) closeSegment() {
+	if v.seg != nil {
+		_ = v.seg.Close()
+		v.seg = nil
+	}
+}
+
+func (v *Verifier) fail(lsn postgres.LSN, reason string) error {
+	return &VerifyError{Segment: SegmentName(v.Timeline, SegmentNo(lsn, v.SegmentSize), v.SegmentSize), LSN: lsn, Reason: reason}
+}
+
+func maxAlign(n uint64) uint64 { return (n + 7) &^ 7 }
@@ -0,0 +1,201 @@
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vbp1/pgclone/internal/postgres"
+)
+
+const (
+	testPageSize = 8192
+	testSegSize  = 8 * testPageSize
+	testMagic    = 0xD116
+)
+
+// walBuilder writes synthetic WAL (page headers, records, continuations) starting at a segment boundary.
+type walBuilder struct {
+	tli  uint32
+	base postgres.LSN
+	buf  []byte
+	prev postgres.LSN
+}
+
+func (b *walBuilder) pos() postgres.LSN { return b.base + postgres.LSN(len(b.buf)) }
+
+// pageHeader appends the header of the page starting at the current position.
+func (b *walBuilder) pageHeader(remLen uint32) {
+	pos := b.pos()
+	info := uint16(0)
+	if remLen > 0 {
+		info |= xlpFirstIsContRecord
+	}
+	size := shortPageHeaderSize
+	if uint64(pos)%testSegSize == 0 {
+		info |= xlpLongHeader
+		size = longPageHeaderSize
+	}
+	h := make([]byte, size)
+	binary.LittleEndian.PutUint16(h[0:], testMagic)
+	binary.LittleEndian.PutUint16(h[2:], info)
+	binary.LittleEndian.PutUint32(h[4:], b.tli)
+	binary.LittleEndian.PutUint64(h[8:], uint64(pos))
+	binary.LittleEndian.PutUint32(h[16:], remLen)
+	if size == longPageHeaderSize {
+		binary.LittleEndian.PutUint64(h[24:], 7000000000000000001)
+		binary.LittleEndian.PutUint32(h[32:], testSegSize)
+		binary.LittleEndian.PutUint32(h[36:], testPageSize)
+	}
+	b.buf = append(b.buf, h...)
+}
+
+// record appends a record with payload bytes and returns its start LSN.
+func (b *walBuilder) record(payload int, rmid, info byte) postgres.LSN {
+	if uint64(b.pos())%testPageSize == 0 {
+		b.pageHeader(0)
+	}
+	start := b.pos()
+	rec := make([]byte, recordHeaderSize+payload)
+	binary.LittleEndian.PutUint32(rec[0:], uint32(len(rec)))
+	binary.LittleEndian.PutUint64(rec[8:], uint64(b.prev))
+	rec[16], rec[17] = info, rmid
+	for i := recordHeaderSize; i < len(rec); i++ {
+		rec[i] = byte(i)
+	}
+	crc := crc32.Update(0, crcTable, rec[recordHeaderSize:])
+	crc = crc32.Update(crc, crcTable, rec[:recordCRCOffset])
+	binary.LittleEndian.PutUint32(rec[recordCRCOffset:], crc)
+
+	for len(rec) > 0 {
+		if uint64(b.pos())%testPageSize == 0 {
+			b.pageHeader(uint32(len(rec)))
+		}
+		n := min(len(rec), testPageSize-int(uint64(b.pos())%testPageSize))
+		b.buf = append(b.buf, rec[:n]...)
+		rec = rec[n:]
+	}
+	b.buf = append(b.buf, make([]byte, maxAlign(uint64(len(b.buf)))-uint64(len(b.buf)))...)
+	b.prev = start
+	return start
+}
+
+// switchSegment appends an XLOG_SWITCH record and fills the rest of the segment with empty pages.
+func (b *walBuilder) switchSegment() {
+	b.record(0, rmXLogID, xlogSwitch)
+	for uint64(b.pos())%testSegSize != 0 {
+		b.buf = append(b.buf, make([]byte, testPageSize-uint64(b.pos())%testPageSize)...)
+		if uint64(b.pos())%testSegSize != 0 {
+			b.pageHeader(0)
+		}
+	}
+}
+
+// write stores the WAL as segment files in dir (the last one zero-padded as .partial).
+func (b *walBuilder) write(t *testing.T, dir string) {
+	t.Helper()
+	data := b.buf
+	segNo := SegmentNo(b.base, testSegSize)
+	for len(data) > 0 {
+		name := SegmentName(b.tli, segNo, testSegSize)
+		seg := make([]byte, testSegSize)
+		n := copy(seg, data)
+		data = data[n:]
+		if n < testSegSize {
+			name += ".partial"
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), seg, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		segNo++
+	}
+}
+
+// buildWAL creates three segments with records crossing pages and segments plus one switch.
+func buildWAL(t *testing.T) (dir string, start, stop postgres.LSN) {
+	dir = t.TempDir()
+	b := &walBuilder{tli: 2, base: SegmentStart(10, testSegSize)}
+	b.record(100, 1, 0)
+	start = b.record(50, 1, 0)
+	for i := 0; i < 12; i++ {
+		b.record(3000+i*1000, 10, 0)
+	}
+	b.switchSegment()
+	b.record(20000, 10, 0)
+	b.record(200, 1, 0)
+	stop = b.pos()
+	b.record(10, 1, 0)
+	b.write(t, dir)
+	return dir, start, stop
+}
+
+func verifyErr(t *testing.T, dir string, start, stop postgres.LSN) *VerifyError {
+	t.Helper()
+	v := &Verifier{Dir: dir, Timeline: 2, SegmentSize: testSegSize}
+	_, err := v.Verify(start, stop)
+	var ve *VerifyError
+	if !errors.As(err, &ve) {
+		t.Fatalf("expected VerifyError, got %v", err)
+	}
+	return ve
+}
+
+func TestVerify(t *testing.T) {
+	dir, start, stop := buildWAL(t)
+	v := &Verifier{Dir: dir, Timeline: 2, SegmentSize: testSegSize}
+	st, err := v.Verify(start, stop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Records != 16 {
+		t.Fatalf("records = %d, want 16", st.Records)
+	}
+	if st.Segments < 3 {
+		t.Fatalf("segments = %d, want >= 3", st.Segments)
+	}
+}
+
+func TestVerifyMissingSegment(t *testing.T) {
+	dir, start, stop := buildWAL(t)
+	missing := SegmentName(2, 11, testSegSize)
+	if err := os.Remove(filepath.Join(dir, missing)); err != nil {
+		t.Fatal(err)
+	}
+	ve := verifyErr(t, dir, start, stop)
+	if ve.Segment != missing || !strings.Contains(ve.Reason, "missing") {
+		t.Fatalf("unexpected error: %v", ve)
+	}
+}
+
+func TestVerifyCorruptRecord(t *testing.T) {
+	dir, start, stop := buildWAL(t)
+	name := SegmentName(2, 10, testSegSize)
+	path := filepath.Join(dir, name)
+	data, _ := os.ReadFile(path)
+	data[3*testPageSize+100] ^= 0xFF
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ve := verifyErr(t, dir, start, stop)
+	if ve.Segment != name || !strings.Contains(ve.Reason, "CRC") {
+		t.Fatalf("unexpected error: %v", ve)
+	}
+}
+
+func TestVerifyStaleSegment(t *testing.T) {
+	dir, start, stop := buildWAL(t)
+	// a recycled segment keeps the page addresses of its previous life
+	stale := SegmentName(2, 11, testSegSize)
+	data, _ := os.ReadFile(filepath.Join(dir, SegmentName(2, 10, testSegSize)))
+	if err := os.WriteFile(filepath.Join(dir, stale), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ve := verifyErr(t, dir, start, stop)
+	if ve.Segment != stale || !strings.Contains(ve.Reason, "page address") {
+		t.Fatalf("unexpected error: %v", ve)
+	}
+}